@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"github.com/Masterminds/codl/parser"
+	"github.com/Masterminds/cookoo"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"fmt"
+	"os"
+)
+
+// Audit lists the code literals in every CODL file in a tree, along with
+// anything else that strict mode would not allow. Directories named vendor,
+// and those that start with ".", are skipped.
+//
+// Params:
+// 	- dir: The directory to search.
+//
+// Returns the literals that were listed.
+func Audit(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	dir := cookoo.GetString("dir", ".", p)
+
+	files := []string{}
+	err := filepath.Walk(dir, func(fname string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() && fname != dir && (name == "vendor" || strings.HasPrefix(name, ".")) {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && filepath.Ext(name) == ".codl" {
+			files = append(files, fname)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	listed := []*parser.CodeLiteral{}
+	// The literals already listed, by file and index.
+	seen := map[string]bool{}
+	for _, fname := range files {
+		reg, err := parse(fname)
+		if err != nil {
+			return listed, fmt.Errorf("Fatal error in %s: %s", fname, err)
+		}
+		cfg, err := parser.LoadConfig(filepath.Dir(fname))
+		if err != nil {
+			return listed, err
+		}
+		for _, lit := range parser.Literals(reg) {
+			strictErr := parser.StrictError(lit, reg, cfg.Allow)
+			// A literal from a file that is USEd more than once is only
+			// listed once.
+			key := lit.File
+			if abs, err := filepath.Abs(lit.File); err == nil {
+				key = abs
+			}
+			key += ":" + strconv.Itoa(lit.Index)
+			if seen[key] || (lit.Bare && strictErr == nil) {
+				continue
+			}
+			seen[key] = true
+			listed = append(listed, lit)
+
+			fmt.Println(lit)
+			if strictErr != nil {
+				fmt.Printf("\t%s\n", strictErr)
+			}
+		}
+	}
+	fmt.Printf("[INFO] Found %d literals in %d files\n", len(listed), len(files))
+	return listed, nil
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"github.com/Masterminds/codl/parser"
+	"github.com/Masterminds/cookoo"
+	"strings"
+	"flag"
+	"fmt"
+	"os"
+	"io"
+)
+
+// Positional parses flags that come after the positional arguments, as in
+// "codl completion bash -d routes/". The flag package stops at the first
+// positional argument, so cli.ParseArgs leaves those flags in its result.
+// Each flag that is set goes into the context.
+//
+// Params:
+// 	- args: The arguments that cli.ParseArgs left.
+// 	- flagset: The flag set to parse them with.
+//
+// Returns the positional arguments.
+func Positional(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	args := p.Get("args", []string{}).([]string)
+	flags := p.Get("flagset", nil).(*flag.FlagSet)
+
+	positional := []string{}
+	for len(args) > 0 {
+		if !strings.HasPrefix(args[0], "-") {
+			positional = append(positional, args[0])
+			args = args[1:]
+			continue
+		}
+		if err := flags.Parse(args); err != nil {
+			return positional, err
+		}
+		args = flags.Args()
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if g, ok := f.Value.(flag.Getter); ok {
+			c.Put(f.Name, g.Get())
+		}
+	})
+	return positional, nil
+}
+
+// Completion writes a shell completion script for the app that CODL files
+// describe.
+//
+// Params:
+// 	- files: The CODL files to read.
+// 	- args: The positional arguments. The first names the shell: bash, zsh
+// 	  or fish.
+// 	- name: The program to complete. The default is the name of the APP.
+// 	- out: The file to write to. If empty, the script goes to stdout.
+func Completion(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	files := p.Get("files", []string{}).([]string)
+	args := p.Get("args", []string{}).([]string)
+	name := cookoo.GetString("name", "", p)
+	out := cookoo.GetString("out", "", p)
+
+	if len(args) != 1 {
+		return nil, fmt.Errorf("Name one shell: bash, zsh or fish.")
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "No CODL files found. Quitting.\n")
+		os.Exit(ExitNoFiles)
+	}
+
+	regs := make([]parser.Registry, len(files))
+	for i, fname := range files {
+		reg, err := parse(fname)
+		if err != nil {
+			return nil, fmt.Errorf("Fatal error in %s: %s", fname, err)
+		}
+		regs[i] = reg
+	}
+	script := parser.NewCompletion(name, regs...)
+
+	var write func(io.Writer) error
+	switch args[0] {
+	case "bash":
+		write = script.WriteBash
+	case "zsh":
+		write = script.WriteZsh
+	case "fish":
+		write = script.WriteFish
+	default:
+		return nil, fmt.Errorf("Unknown shell %s. Use bash, zsh or fish.", args[0])
+	}
+
+	var output io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		output = f
+	}
+	return script, write(output)
+}
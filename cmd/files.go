@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"github.com/Masterminds/codl/parser"
 	"github.com/Masterminds/cookoo"
 	fsnotify "gopkg.in/fsnotify.v1"
 	"path/filepath"
 	"path"
+	"sort"
 	"time"
 	"fmt"
 	"os"
@@ -22,7 +24,8 @@ func FindCodl(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt
 	return files, err
 }
 
-// Watch uses fsnotify to watch for changes to .codl files.
+// Watch uses fsnotify to watch for changes to .codl files. When a file
+// changes, it is translated again, along with the files that USE it.
 func Watch(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	dir := cookoo.GetString("dir", ".", p)
 	route := cookoo.GetString("update", "@update", p)
@@ -41,6 +44,16 @@ func Watch(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	defer watcher.Close()
 	watcher.Add(dir)
 
+	// The files that each file USEs, so that they can be translated again
+	// when one of those changes.
+	uses := map[string][]string{}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.codl"))
+	for _, fname := range files {
+		if used, err := usedFiles(fname); err == nil {
+			uses[fname] = used
+		}
+	}
+
 	fmt.Printf("[INFO] Watching %s for changes to .codl files.\n", dir)
 
 	// Watch for updates to files.
@@ -56,7 +69,10 @@ func Watch(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 					continue
 				}
 				fmt.Printf("[INFO] %s has changed. Updating. (%s)\n", good.Name, good.String())
-				c.Put("files", []string{good.Name})
+				if used, err := usedFiles(good.Name); err == nil {
+					uses[good.Name] = used
+				}
+				c.Put("files", append([]string{good.Name}, dependents(good.Name, uses)...))
 				err := router.HandleRequest(route, c, false)
 				if err != nil {
 					fmt.Printf("[ERROR] %s\n", err)
@@ -67,6 +83,7 @@ func Watch(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 			// Log but otherwise ignore Remove.
 			case fsnotify.Remove:
 				fmt.Printf("[INFO] %s has been removed.\n", good.Name)
+				delete(uses, good.Name)
 			}
 		case bad := <-watcher.Errors:
 			c.Logf("warn", "Error watching: %s", bad.Error())
@@ -74,6 +91,33 @@ func Watch(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	}
 }
 
+// usedFiles returns the files that a CODL file USEs, directly or not.
+func usedFiles(fname string) ([]string, error) {
+	reg, err := parse(fname)
+	if err != nil {
+		return nil, err
+	}
+	return parser.Uses(reg), nil
+}
+
+// dependents returns the files that USE a file, sorted.
+func dependents(fname string, uses map[string][]string) []string {
+	abs, err := filepath.Abs(fname)
+	if err != nil {
+		return nil
+	}
+	deps := []string{}
+	for other, used := range uses {
+		for _, u := range used {
+			if u == abs && other != fname {
+				deps = append(deps, other)
+				break
+			}
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
 
 // FilterUnchanged takes a list of files and a timestamp and returns only those changed since the time.
 func FilterUnchanged(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
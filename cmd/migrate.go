@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"github.com/Masterminds/codl/parser"
+	"github.com/Masterminds/cookoo"
+	"io/ioutil"
+	"fmt"
+	"os"
+)
+
+// Migrate rewrites CODL 1 files as CODL 2.
+//
+// Each file is checked before any are written: the new version must generate
+// the same Go code as the old one. Files that are already CODL 2 are left
+// alone.
+//
+// Params:
+// 	- files: The CODL files to migrate.
+//
+// Returns the files that were rewritten.
+func Migrate(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	files := p.Get("files", []string{}).([]string)
+
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "No CODL files found. Quitting.\n")
+		os.Exit(ExitNoFiles)
+	}
+
+	sources := make([]string, len(files))
+	for i, fname := range files {
+		src, err := parser.MigrateFile(fname)
+		if err != nil {
+			return []string{}, fmt.Errorf("Fatal error in %s: %s. Nothing was migrated.", fname, err)
+		}
+		sources[i] = src
+	}
+
+	migrated := []string{}
+	for i, fname := range files {
+		old, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return migrated, err
+		}
+		if string(old) == sources[i] {
+			fmt.Printf("[INFO] %s is already CODL 2\n", fname)
+			continue
+		}
+		if err := ioutil.WriteFile(fname, []byte(sources[i]), 0644); err != nil {
+			return migrated, fmt.Errorf("Fatal error in %s: %s", fname, err)
+		}
+		fmt.Printf("[INFO] Migrated %s to CODL 2\n", fname)
+		migrated = append(migrated, fname)
+	}
+	return migrated, nil
+}
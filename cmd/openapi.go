@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"github.com/Masterminds/codl/parser"
+	"github.com/Masterminds/cookoo"
+	"fmt"
+	"os"
+	"io"
+)
+
+// OpenAPI writes an OpenAPI 3 document for the HTTP routes in CODL files.
+//
+// Params:
+// 	- files: The CODL files to read.
+// 	- format: "json" or "yaml".
+// 	- title: The API's title.
+// 	- version: The API's version.
+// 	- out: The file to write to. If empty, the document goes to stdout.
+func OpenAPI(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	files := p.Get("files", []string{}).([]string)
+	format := cookoo.GetString("format", "json", p)
+	title := cookoo.GetString("title", "API", p)
+	version := cookoo.GetString("version", "1.0.0", p)
+	out := cookoo.GetString("out", "", p)
+
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "No CODL files found. Quitting.\n")
+		os.Exit(ExitNoFiles)
+	}
+
+	regs := make([]parser.Registry, len(files))
+	for i, fname := range files {
+		reg, err := parse(fname)
+		if err != nil {
+			return nil, fmt.Errorf("Fatal error in %s: %s", fname, err)
+		}
+		regs[i] = reg
+	}
+	doc := parser.NewOpenAPI(title, version, regs...)
+
+	var output io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		output = f
+	}
+
+	switch format {
+	case "json":
+		return doc, doc.WriteJSON(output)
+	case "yaml":
+		return doc, doc.WriteYAML(output)
+	}
+	return nil, fmt.Errorf("Unknown format %s. Use json or yaml.", format)
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/Masterminds/codl/parser"
 	"github.com/Masterminds/cookoo"
 	"strings"
+	"path/filepath"
 	"path"
 	"fmt"
 	"os"
@@ -12,9 +13,24 @@ import (
 
 const ExitNoFiles = 2
 
+// Translate transforms each CODL file into a Go file.
+//
+// Params:
+// 	- files: The CODL files to translate.
+// 	- skipEmpty: If true, an empty file list is not an error.
+// 	- profile: The build profile. Only IF blocks for this profile are kept.
+// 	- tags: If true, ignore 'profile' and write one Go file per profile,
+// 	  each guarded by a //go:build constraint.
+// 	- strict: If true, check every file in strict mode. A directory's
+// 	  codl.json may also turn strict mode on, and gives its allow list.
+//
+// A CODL file with TEST statements also gets a _test.go file.
 func Translate(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	files := p.Get("files", []string{}).([]string)
 	skipEmpty := p.Get("skipEmpty", false).(bool)
+	profile, _ := p.Get("profile", "").(string)
+	tags, _ := p.Get("tags", false).(bool)
+	strict, _ := p.Get("strict", false).(bool)
 
 	if len(files) == 0 {
 		// If the list of files is empty, just silently return. This is to
@@ -27,46 +43,200 @@ func Translate(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrup
 		os.Exit(ExitNoFiles)
 	}
 
-	created := []string{}
+	// all holds every parsed file, including the ones in the same
+	// directories that are not being translated.
+	regs := make([]parser.Registry, len(files))
+	all := map[string]parser.Registry{}
+	checker := parser.NewChecker()
+	for i, fname := range files {
+		reg, err := parse(fname)
+		if err != nil {
+			return []string{}, fmt.Errorf("Fatal error in %s: %s", fname, err)
+		}
+		regs[i] = reg
+		all[fname] = reg
+		checker.Add(fname, reg)
+	}
+
+	// The other files in the same directories are checked along with
+	// these, so that a REROUTE or INCLUDES can name a route in any of them.
+	// Only the files being translated are reported on. A file that does
+	// not parse is reported when it is translated.
+	for _, fname := range siblings(files) {
+		if reg, err := parse(fname); err == nil {
+			checker.Add(fname, reg)
+			all[fname] = reg
+		}
+	}
+
+	diags := []*parser.Diagnostic{}
+	translating := map[string]bool{}
 	for _, fname := range files {
+		translating[fname] = true
+	}
+	for _, d := range checker.Check() {
+		if translating[d.File] {
+			diags = append(diags, d)
+		}
+	}
+	configs := map[string]*parser.Config{}
+	for i, fname := range files {
+		dir := path.Dir(fname)
+		if _, ok := configs[dir]; !ok {
+			cfg, err := parser.LoadConfig(dir)
+			if err != nil {
+				return []string{}, err
+			}
+			configs[dir] = cfg
+		}
+		if cfg := configs[dir]; strict || cfg.Strict {
+			diags = append(diags, parser.CheckStrict(fname, regs[i], cfg.Allow)...)
+		}
+	}
+	for _, d := range diags {
+		if d.Warning {
+			fmt.Printf("[WARN] %s\n", d)
+		} else {
+			fmt.Printf("[ERROR] %s\n", d)
+		}
+	}
+	if parser.HasErrors(diags) {
+		return []string{}, fmt.Errorf("Errors found in CODL files. Nothing was translated.")
+	}
+
+	// A directory with an APP ... MAIN in any of its files holds package
+	// main.
+	mainDirs := map[string]bool{}
+	for fname, reg := range all {
+		if app := reg.Application(); app != nil && app.Main {
+			mainDirs[path.Dir(fname)] = true
+		}
+	}
+
+	created := []string{}
+	for i, fname := range files {
+		reg := regs[i]
 		basedir := path.Dir(fname)
 		pkgname := path.Base(basedir)
+		if mainDirs[basedir] {
+			pkgname = "main"
+		}
 		basename := strings.TrimSuffix(path.Base(fname), ".codl")
-		newname := path.Join(basedir, basename + ".go")
 
-		var input io.Reader
-		var output io.Writer
-		var err error
-		if input, err = os.Open(fname); err != nil {
-			return created, err
+		profiles := []string{profile}
+		if tags {
+			profiles = append([]string{""}, parser.Profiles(reg)...)
 		}
 
-		if output, err = os.Create(newname); err != nil {
-			return created, err
-		}
+		for _, prof := range profiles {
+			newname := path.Join(basedir, basename + ".go")
+			constraint := ""
+			if tags {
+				if prof != "" {
+					newname = path.Join(basedir, basename + "." + prof + ".go")
+				}
+				constraint = buildConstraint(prof, profiles[1:])
+			}
 
-		if err := translate(basename, pkgname, input, output); err != nil {
-			return created, fmt.Errorf("Fatal error in %s: %s", fname, err)
+			if err := write(newname, basename, pkgname, parser.ForProfile(reg, prof), constraint); err != nil {
+				return created, fmt.Errorf("Fatal error in %s: %s", fname, err)
+			}
+
+			fmt.Printf("[INFO] Translated %s to %s\n", fname, newname)
+			created = append(created, newname)
 		}
 
-		fmt.Printf("[INFO] Translated %s to %s\n", fname, newname)
+		if len(reg.Tests()) > 0 {
+			// With tags, the tests run the routes of the default profile.
+			prof := profile
+			if tags {
+				prof = ""
+			}
+			testname := path.Join(basedir, basename + "_test.go")
+			if err := writeTests(testname, basename, pkgname, parser.ForProfile(reg, prof)); err != nil {
+				return created, fmt.Errorf("Fatal error in %s: %s", fname, err)
+			}
 
-		created = append(created, newname)
+			fmt.Printf("[INFO] Translated the tests in %s to %s\n", fname, testname)
+			created = append(created, testname)
+		}
 	}
 
 	return created, nil
 }
 
-func translate(basename, pkgname string, in io.Reader, out io.Writer) error {
-	h, err := parser.Parse(in)
+func parse(fname string) (parser.Registry, error) {
+	h, err := parser.ParseFile(fname)
 	if err != nil {
+		return nil, err
+	}
+	return h.(parser.Registry), nil
+}
+
+// siblings returns the other CODL files in the directories of files.
+func siblings(files []string) []string {
+	given := map[string]bool{}
+	for _, fname := range files {
+		given[path.Clean(fname)] = true
+	}
+	dirs := map[string]bool{}
+	others := []string{}
+	for _, fname := range files {
+		dir := path.Dir(fname)
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		found, _ := filepath.Glob(filepath.Join(dir, "*.codl"))
+		for _, other := range found {
+			if !given[path.Clean(other)] {
+				others = append(others, other)
+			}
+		}
+	}
+	return others
+}
+
+func write(newname, basename, pkgname string, reg parser.Registry, constraint string) error {
+	var output io.WriteCloser
+	var err error
+	if output, err = os.Create(newname); err != nil {
 		return err
 	}
+	defer output.Close()
+
+	ser := parser.NewSerializer(basename, pkgname, output, reg)
+	ser.BuildConstraint(constraint)
+	return ser.Write()
+}
 
-	reg := h.(parser.Registry)
-	ser := parser.NewSerializer(basename, pkgname, out, reg)
-	if err := ser.Write(); err != nil {
+// writeTests writes the Go tests for the TEST statements in a CODL file.
+func writeTests(newname, basename, pkgname string, reg parser.Registry) error {
+	output, err := os.Create(newname)
+	if err != nil {
 		return err
 	}
-	return nil
+	defer output.Close()
+
+	return parser.NewSerializer(basename, pkgname, output, reg).WriteTests()
+}
+
+// buildConstraint builds the //go:build expression for one profile's file.
+//
+// Each profile's file excludes all other profiles so that only one copy of
+// the routes function is ever compiled. The default file excludes them all.
+func buildConstraint(profile string, all []string) string {
+	if len(all) == 0 {
+		return ""
+	}
+	terms := []string{}
+	if profile != "" {
+		terms = append(terms, profile)
+	}
+	for _, other := range all {
+		if other != profile {
+			terms = append(terms, "!" + other)
+		}
+	}
+	return strings.Join(terms, " && ")
 }
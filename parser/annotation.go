@@ -0,0 +1,71 @@
+package parser
+
+// Annotation is a piece of metadata attached to a ROUTE or DOES, such as
+// `@tag api` or `@hidden`.
+//
+// Unlike most strings in the parse tree, Value is not quoted for Go.
+type Annotation struct {
+	Name, Value string
+}
+
+// Annotations is the list of annotations on a ROUTE or DOES.
+type Annotations []*Annotation
+
+// Tags returns the values of all @tag annotations.
+func (a Annotations) Tags() []string {
+	tags := []string{}
+	for _, an := range a {
+		if an.Name == "tag" {
+			tags = append(tags, an.Value)
+		}
+	}
+	return tags
+}
+
+// HasTag reports whether there is a @tag annotation with the given value.
+func (a Annotations) HasTag(tag string) bool {
+	for _, t := range a.Tags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Owner returns the value of the last @owner annotation.
+func (a Annotations) Owner() string {
+	return a.value("owner")
+}
+
+// Deprecated returns the message of the last @deprecated annotation.
+func (a Annotations) Deprecated() string {
+	return a.value("deprecated")
+}
+
+// IsDeprecated reports whether there is a @deprecated annotation.
+func (a Annotations) IsDeprecated() bool {
+	return a.has("deprecated")
+}
+
+// Hidden reports whether there is a @hidden annotation.
+func (a Annotations) Hidden() bool {
+	return a.has("hidden")
+}
+
+func (a Annotations) has(name string) bool {
+	for _, an := range a {
+		if an.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (a Annotations) value(name string) string {
+	for i := len(a) - 1; i >= 0; i-- {
+		if a[i].Name == name {
+			return a[i].Value
+		}
+	}
+	return ""
+}
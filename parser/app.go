@@ -0,0 +1,350 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const cliImport = "`github.com/Masterminds/cookoo/cli`"
+
+var (
+	flagSetRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	flagRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
+// flagTypes maps the type of a flag to the flag.FlagSet method that
+// declares it, and to the kind of typed literal its default must be.
+var flagTypes = map[string][2]string{
+	"bool": {"Bool", typeBool},
+	"string": {"String", typeString},
+	"int": {"Int", typeInt},
+	"int64": {"Int64", typeInt},
+	"uint": {"Uint", typeInt},
+	"uint64": {"Uint64", typeInt},
+	"float64": {"Float64", typeFloat},
+	"duration": {"Duration", typeDuration},
+}
+
+// App is the command line application that an APP statement declares.
+type App struct {
+	// Name, Summary and Description are quoted, like other strings.
+	Name, Summary, Description string
+	// Main is true if a main function should be generated.
+	Main bool
+	// Shared is true if the APP is from a file in the same directory,
+	// which declares main in its own generated code.
+	Shared bool
+}
+
+// FlagsVar is the variable that holds the app's global flags.
+func (a *App) FlagsVar() string {
+	return Unquote(a.Name) + "Flags"
+}
+
+// FlagSet is a FLAGS block.
+type FlagSet struct {
+	Name string
+	Flags []*Flag
+	// Shared is true if the FLAGS are from a file in the same directory,
+	// which declares the variable in its own generated code.
+	Shared bool
+}
+
+// Var is the name of the variable that holds the flag set: "buildFlags".
+func (f *FlagSet) Var() string {
+	return f.Name + "Flags"
+}
+
+// Flag is one flag in a FLAGS block.
+type Flag struct {
+	// Name and Help are quoted, and Default is Go code.
+	Name, Type, Default, Help string
+}
+
+// Func is the flag.FlagSet method that declares the flag: "Bool".
+func (f *Flag) Func() string {
+	return flagTypes[f.Type][0]
+}
+
+// App starts an APP statement, which takes a name, a summary, an optional
+// description, and an optional MAIN. It ends any open ROUTE.
+func (l *handler) App() {
+	if !l.unannotated("APP") || !l.ungrouped("APP") {
+		return
+	}
+	if l.app != nil {
+		l.err = fmt.Errorf("There can only be one APP")
+		return
+	}
+	l.closeRoute(AppMode)
+	l.app = &App{}
+	l.addImport(cliImport)
+}
+
+// appWord handles a string in an APP statement.
+func (l *handler) appWord(str string) {
+	a := l.app
+	switch {
+	case a.Main:
+		l.err = fmt.Errorf("APP has nothing after MAIN. No place for %s", str)
+	case a.Name == "":
+		if !flagSetRe.MatchString(str) {
+			l.err = fmt.Errorf("Illegal APP name: %s", str)
+			return
+		}
+		a.Name = asString(str)
+	case str == "MAIN" && a.Summary != "":
+		a.Main = true
+		l.addImport(asString("fmt"))
+		l.addImport(asString("os"))
+	case a.Summary == "":
+		a.Summary = asString(str)
+	case a.Description == "":
+		a.Description = asString(str)
+	default:
+		l.err = fmt.Errorf("APP takes a name, a summary and a description. No place for %s", str)
+	}
+}
+
+// Flags starts a FLAGS block, which takes a name followed by flags. Each
+// flag is a name, a type, a default and a help text.
+//
+// Right after SUBCOMMAND and its strings, FLAGS instead names the flag set
+// that the subcommand parses.
+func (l *handler) Flags() {
+	if !l.unannotated("FLAGS") || !l.ungrouped("FLAGS") {
+		return
+	}
+	if r := l.currentRoute; r != nil && r.Subcommand && l.mode == RouteMode && len(r.Commands) == 0 {
+		if r.Flags != "" {
+			l.err = fmt.Errorf("SUBCOMMAND %s can only have one FLAGS", Unquote(r.Name))
+			return
+		}
+		l.flagsRef = true
+		return
+	}
+	if len(l.profiles()) > 0 {
+		l.err = fmt.Errorf("FLAGS cannot be inside an IF profile block")
+		return
+	}
+	l.closeRoute(FlagsMode)
+	l.flagSet = &FlagSet{}
+	l.flagSets = append(l.flagSets, l.flagSet)
+	l.addImport(asString("flag"))
+}
+
+// flagWord handles a string or literal in a FLAGS block. code is true for a
+// literal, which can only be a default.
+func (l *handler) flagWord(str string, code bool) {
+	fs := l.flagSet
+	if fs.Name == "" {
+		if code || !flagSetRe.MatchString(str) {
+			l.err = fmt.Errorf("Illegal FLAGS name: %s", str)
+			return
+		}
+		if l.findFlagSet(str) != nil {
+			l.err = fmt.Errorf("FLAGS %s is declared more than once", str)
+			return
+		}
+		fs.Name = str
+		return
+	}
+
+	f := l.flag
+	switch {
+	case f == nil:
+		if code || !flagRe.MatchString(str) {
+			l.err = fmt.Errorf("Illegal flag name in FLAGS %s: %s", fs.Name, str)
+			return
+		}
+		if fs.has(str) {
+			l.err = fmt.Errorf("FLAGS %s has more than one flag %s", fs.Name, str)
+			return
+		}
+		l.flag = &Flag{ Name: asString(str) }
+	case f.Type == "":
+		if _, ok := flagTypes[str]; !ok || code {
+			l.err = fmt.Errorf("Flag %s has an unknown type %s. Use bool, string, int, int64, uint, uint64, float64 or duration", Unquote(f.Name), str)
+			return
+		}
+		f.Type = str
+		if str == "duration" {
+			l.addImport(asString("time"))
+		}
+	case f.Default == "":
+		def, err := flagDefault(f, str, code)
+		if err != nil {
+			l.err = err
+			return
+		}
+		f.Default = def
+	default:
+		f.Help = asString(str)
+		fs.Flags = append(fs.Flags, f)
+		l.flag = nil
+	}
+}
+
+// flagDefault returns Go code for a flag's default, which must suit the
+// flag's type. A literal is used as it is.
+func flagDefault(f *Flag, str string, code bool) (string, error) {
+	if code {
+		return str, nil
+	}
+	if f.Type == "string" {
+		return strconv.Quote(str), nil
+	}
+	def, typ, err := scalar(str)
+	if err != nil {
+		return "", err
+	}
+	want := flagTypes[f.Type][1]
+	if typ == typeInt && want == typeFloat {
+		typ = typeFloat
+	}
+	if typ != want || (strings.HasPrefix(f.Type, "uint") && strings.HasPrefix(def, "-")) {
+		return "", fmt.Errorf("Flag %s is a %s, so its default cannot be %s", Unquote(f.Name), f.Type, str)
+	}
+	return def, nil
+}
+
+// flagsDone reports whether the last FLAGS has a complete last flag, and a
+// SUBCOMMAND's FLAGS has its name. If not, that is an error.
+func (l *handler) flagsDone() bool {
+	switch {
+	case l.flagsRef:
+		l.err = fmt.Errorf("FLAGS after SUBCOMMAND requires the name of a FLAGS block")
+	case l.mode == FlagsMode && l.flagSet.Name == "":
+		l.err = fmt.Errorf("FLAGS requires a name")
+	case l.mode == FlagsMode && l.flag != nil:
+		l.err = fmt.Errorf("Flag %s requires a type, a default and a help text", Unquote(l.flag.Name))
+	case l.mode == AppMode && l.app.Summary == "":
+		l.err = fmt.Errorf("APP requires a name and a summary")
+	default:
+		return true
+	}
+	return false
+}
+
+// Subcommand starts a ROUTE that runs as a command line subcommand. Its
+// flags are parsed, and help shown for -h, before its first DOES.
+func (l *handler) Subcommand() {
+	if l.mode == TestMode {
+		l.err = fmt.Errorf("A TEST runs a ROUTE, not a SUBCOMMAND")
+		return
+	}
+	l.Route()
+	if r := l.currentRoute; r != nil && l.err == nil {
+		r.Subcommand = true
+		l.addImport(cliImport)
+	}
+}
+
+// closeRoute ends any open ROUTE or hook and starts a new statement.
+func (l *handler) closeRoute(mode int) {
+	l.mode = mode
+	l.currentRoute = nil
+	l.withParam = nil
+	l.hook = nil
+}
+
+func (l *handler) findFlagSet(name string) *FlagSet {
+	for _, fs := range l.flagSets {
+		if fs.Name == name {
+			return fs
+		}
+	}
+	return nil
+}
+
+func (fs *FlagSet) has(name string) bool {
+	for _, f := range fs.Flags {
+		if Unquote(f.Name) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// expandSubcommands gives each SUBCOMMAND the commands that parse its flags
+// and show its help. It also gives each flag set a -h flag, and the APP a
+// flag set of its own if it has none.
+func (l *handler) expandSubcommands() error {
+	if l.app != nil && l.findFlagSet(Unquote(l.app.Name)) == nil {
+		l.flagSets = append(l.flagSets, &FlagSet{ Name: Unquote(l.app.Name) })
+		l.addImport(asString("flag"))
+	}
+	for _, fs := range l.flagSets {
+		if !fs.has("h") {
+			h := &Flag{ Name: asString("h"), Type: "bool", Default: "false", Help: asString("Show help text and exit.") }
+			fs.Flags = append([]*Flag{h}, fs.Flags...)
+		}
+	}
+
+	for _, r := range l.routes {
+		if !r.Subcommand || r.expanded {
+			continue
+		}
+		name := r.Flags
+		if name == "" {
+			name = Unquote(r.Name)
+		}
+		fs := l.findFlagSet(name)
+		if fs == nil {
+			return fmt.Errorf("SUBCOMMAND %s has no FLAGS %s", Unquote(r.Name), name)
+		}
+		summary := r.Description
+		if summary == "" {
+			summary = r.Name
+		}
+		profiles := append([]string{}, r.Profiles...)
+		parse := &Command{ Cmd: "cli.ParseArgs", Name: "`@args`", Profiles: profiles, Params: []*Using{
+			{ Name: "`subcommand`", DefaultVal: "true" },
+			{ Name: "`args`", From: []string{"`cxt:runner.Args`"} },
+			{ Name: "`flagset`", DefaultVal: fs.Var() },
+		}}
+		help := &Command{ Cmd: "cli.ShowHelp", Name: "`@help`", Profiles: profiles, Params: []*Using{
+			{ Name: "`show`", From: []string{"`cxt:h`"} },
+			{ Name: "`summary`", DefaultVal: summary },
+			{ Name: "`flags`", DefaultVal: fs.Var() },
+		}}
+		r.Commands = append([]*Command{parse, help}, r.Commands...)
+		r.expanded = true
+	}
+	return nil
+}
+
+// subcommandKeys adds the context keys that a SUBCOMMAND can read to the
+// declared keys: runner.Args, which cli puts there, and the flags that
+// cli.ParseArgs puts there.
+func subcommandKeys(r *Route, flagSets map[string]*FlagSet, declared map[string]bool) map[string]bool {
+	if !r.Subcommand {
+		return declared
+	}
+	keys := map[string]bool{ "runner.Args": true }
+	for key := range declared {
+		keys[key] = true
+	}
+	name := r.Flags
+	if name == "" {
+		name = Unquote(r.Name)
+	}
+	if fs, ok := flagSets[name]; ok {
+		for _, f := range fs.Flags {
+			keys[Unquote(f.Name)] = true
+		}
+	}
+	return keys
+}
+
+// Application returns the APP, or nil.
+func (l *handler) Application() *App {
+	return l.app
+}
+
+// FlagSets returns the FLAGS blocks.
+func (l *handler) FlagSets() []*FlagSet {
+	return l.flagSets
+}
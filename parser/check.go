@@ -0,0 +1,234 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diagnostic is a problem that Checker found in a CODL file.
+type Diagnostic struct {
+	File string
+	// Route is the name of the route the problem is in, if any.
+	Route string
+	Warning bool
+	Message string
+}
+
+func (d *Diagnostic) String() string {
+	level := "error"
+	if d.Warning {
+		level = "warning"
+	}
+	if d.Route == "" {
+		return fmt.Sprintf("%s: %s: %s", d.File, level, d.Message)
+	}
+	return fmt.Sprintf("%s: route %s: %s: %s", d.File, d.Route, level, d.Message)
+}
+
+// Checker looks for problems that span a set of CODL files, such as
+// references from one route to another.
+type Checker struct {
+	files []string
+	regs []Registry
+}
+
+// NewChecker creates a new, empty Checker.
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add adds a parsed file to the set of files that are checked together.
+func (c *Checker) Add(file string, reg Registry) {
+	c.files = append(c.files, file)
+	c.regs = append(c.regs, reg)
+}
+
+// Check checks all added files and returns what it found, in file order.
+func (c *Checker) Check() []*Diagnostic {
+	routes := map[string]*Route{}
+	// owners maps each unquoted route name to the first file that has it.
+	names := map[string]bool{}
+	owners := map[string]int{}
+	for i, reg := range c.regs {
+		for _, r := range reg.Routes() {
+			routes[r.Name] = r
+			if !names[Unquote(r.Name)] {
+				names[Unquote(r.Name)] = true
+				owners[Unquote(r.Name)] = i
+			}
+		}
+	}
+
+	// Context keys and FROM prefixes are only checked once some are
+	// declared.
+	declared := map[string]bool{}
+	prefixes := map[string]bool{}
+	flagSets := map[string]*FlagSet{}
+	for _, reg := range c.regs {
+		for _, k := range reg.Contexts() {
+			declared[Unquote(k.Name)] = true
+		}
+		for _, d := range reg.Datasources() {
+			prefixes[Unquote(d.Name)] = true
+		}
+		for _, fs := range reg.FlagSets() {
+			flagSets[fs.Name] = fs
+		}
+	}
+
+	diags := []*Diagnostic{}
+	for i, reg := range c.regs {
+		for _, r := range reg.Routes() {
+			report := func(warning bool, msg string) {
+				diags = append(diags, &Diagnostic{
+					File: c.files[i],
+					Route: Unquote(r.Name),
+					Warning: warning,
+					Message: msg,
+				})
+			}
+			warn := func(msg string) {
+				report(true, msg)
+			}
+			// A name inside a namespace is only looked up in the routes of
+			// its own file, because that is done when the file is parsed.
+			local := func(keyword, name, ns string) {
+				found, ok := resolveName(Unquote(name), ns, names)
+				if ok && owners[found] != i {
+					warn(fmt.Sprintf("%s %s does not find %s in %s, which is another file. Write the full name to use it", keyword, Unquote(name), found, c.files[owners[found]]))
+				}
+			}
+			local("ON ERROR REROUTE", r.OnError, r.Namespace)
+			// A DOES that got its policy from the route is reported with
+			// the route.
+			if _, ok := routes[r.OnError]; r.OnError != "" && !ok {
+				warn("reroutes on error to unknown route " + candidates(Unquote(r.OnError), r.Namespace))
+			}
+			var end *Command
+			for _, cmd := range r.Commands {
+				if end != nil && shadows(end, cmd) {
+					warn(fmt.Sprintf("%s is unreachable after %s", describe(cmd), describe(end)))
+					end = nil
+				}
+				if cmd.IsReroute() {
+					local("REROUTE", cmd.Target, cmd.Namespace)
+				} else if cmd.IsIncludes() {
+					local("INCLUDES", cmd.Name, cmd.Namespace)
+				}
+				if cmd.IsFlow() {
+					if _, ok := routes[cmd.Target]; cmd.IsReroute() && !ok {
+						report(false, "reroutes to unknown route " + candidates(Unquote(cmd.Target), cmd.Namespace))
+					}
+					if end == nil || shadows(cmd, end) {
+						end = cmd
+					}
+					continue
+				}
+				if !cmd.IsIncludes() {
+					for _, c := range append([]*Command{cmd}, cmd.Steps...) {
+						for _, source := range sources(c) {
+							if name := unknownPath(source); name != "" {
+								warn(fmt.Sprintf("DOES %s reads %s, but the route's path has no {%s}", Unquote(c.Name), source, name))
+							}
+							if prefix := unknownPrefix(source, prefixes); len(prefixes) > 0 && prefix != "" {
+								warn(fmt.Sprintf("DOES %s reads %s, but there is no DATASOURCE %s", Unquote(c.Name), source, prefix))
+							}
+						}
+						if c.OnError != r.OnError {
+							local("ON ERROR REROUTE", c.OnError, c.Namespace)
+						}
+						if _, ok := routes[c.OnError]; c.OnError != "" && c.OnError != r.OnError && !ok {
+							warn(fmt.Sprintf("DOES %s reroutes on error to unknown route %s", Unquote(c.Name), candidates(Unquote(c.OnError), c.Namespace)))
+						}
+					}
+					continue
+				}
+				target, ok := routes[cmd.Name]
+				if !ok {
+					warn("includes unknown route " + candidates(Unquote(cmd.Name), cmd.Namespace))
+					continue
+				}
+				if target.Annotations.IsDeprecated() {
+					warn(fmt.Sprintf("includes deprecated route %s: %s", Unquote(cmd.Name), target.Annotations.Deprecated()))
+				}
+			}
+			for _, inc := range r.inlined {
+				if target, ok := routes[inc.Name]; ok && target.Annotations.IsDeprecated() {
+					warn(fmt.Sprintf("includes deprecated route %s: %s", Unquote(inc.Name), target.Annotations.Deprecated()))
+				}
+			}
+			if len(declared) > 0 {
+				for _, msg := range contextWarnings(r, routes, subcommandKeys(r, flagSets, declared)) {
+					warn(msg)
+				}
+			}
+		}
+	}
+	return diags
+}
+
+// shadows reports whether a REROUTE or STOP keeps a later command from ever
+// running. That is so if end is in the same IF blocks as cmd, or in fewer of
+// them.
+func shadows(end, cmd *Command) bool {
+	if len(end.Profiles) > len(cmd.Profiles) {
+		return false
+	}
+	for i, p := range end.Profiles {
+		if cmd.Profiles[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// describe names a command for a diagnostic: "DOES show", "INCLUDES help",
+// "REROUTE home" or "STOP".
+func describe(c *Command) string {
+	switch {
+	case c.IsStop():
+		return "STOP"
+	case c.IsReroute():
+		return "REROUTE " + Unquote(c.Target)
+	case c.IsIncludes():
+		return "INCLUDES " + Unquote(c.Name)
+	}
+	return "DOES " + Unquote(c.Name)
+}
+
+// candidates describes the routes a name could have referred to from inside
+// a namespace: "help (or admin.help)".
+func candidates(name, ns string) string {
+	tried := []string{}
+	for ns != "" {
+		tried = append(tried, ns + "." + name)
+		if i := strings.LastIndex(ns, "."); i >= 0 {
+			ns = ns[:i]
+		} else {
+			ns = ""
+		}
+	}
+	if len(tried) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s (or %s)", name, strings.Join(tried, ", "))
+}
+
+// HasErrors reports whether any of the diagnostics is an error.
+func HasErrors(diags []*Diagnostic) bool {
+	for _, d := range diags {
+		if !d.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// Unquote removes the quoting that the parser adds to strings, so that
+// "`name`" becomes "name". Other values are returned unchanged.
+func Unquote(str string) string {
+	if len(str) >= 2 && strings.HasPrefix(str, "`") && strings.HasSuffix(str, "`") {
+		return str[1 : len(str)-1]
+	}
+	return str
+}
@@ -0,0 +1,299 @@
+package parser
+
+import (
+	"testing"
+)
+
+func checkDocs(t *testing.T, docs map[string]string) []*Diagnostic {
+	c := NewChecker()
+	// Sorted so that results are in a predictable order.
+	for _, name := range []string{"a.codl", "b.codl", "c.codl"} {
+		doc, ok := docs[name]
+		if !ok {
+			continue
+		}
+		h, err := Parse(v2(doc))
+		if err != nil {
+			t.Fatalf("Surprise! Error in %s: %s", name, err)
+		}
+		c.Add(name, h.(Registry))
+	}
+	return c.Check()
+}
+
+func TestCheckDeprecatedIncludes(t *testing.T) {
+	diags := checkDocs(t, map[string]string{
+		"a.codl": `
+@deprecated "use new"
+ROUTE old "Old route"
+ROUTE new "New route"
+ROUTE a "Includes old" INCLUDES old`,
+		"b.codl": `
+ROUTE b "Includes old from another file"
+	INCLUDES new
+	INCLUDES old`,
+	})
+
+	if len(diags) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %v", diags)
+	}
+	if !diags[0].Warning || diags[0].File != "a.codl" || diags[0].Route != "a" {
+		t.Errorf("Unexpected diagnostic: %s", diags[0])
+	}
+	expect := "b.codl: route b: warning: includes deprecated route old: use new"
+	if diags[1].String() != expect {
+		t.Errorf("Expected %q, got %q", expect, diags[1].String())
+	}
+	if HasErrors(diags) {
+		t.Errorf("Deprecation should only warn")
+	}
+}
+
+func TestCheckDeprecatedInlined(t *testing.T) {
+	diags := checkDocs(t, map[string]string{
+		"a.codl": `
+@deprecated "use new"
+ROUTE old "Old route"
+	DOES «foo.Bar» bar
+		USING p «1»
+ROUTE a "Inlines old"
+	INCLUDES old WITH p «2»`,
+	})
+
+	expect := "a.codl: route a: warning: includes deprecated route old: use new"
+	if len(diags) != 1 || diags[0].String() != expect {
+		t.Errorf("Expected %q, got %v", expect, diags)
+	}
+}
+
+func TestCheckIncludes(t *testing.T) {
+	diags := checkDocs(t, map[string]string{
+		"a.codl": `
+NAMESPACE admin
+	ROUTE index "Index"
+		INCLUDES help
+		INCLUDES users.list
+END`,
+		"b.codl": `
+ROUTE users.list "Users"
+ROUTE b "B"
+	INCLUDES admin.index
+	INCLUDES admin.missing`,
+	})
+
+	if len(diags) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %v", diags)
+	}
+	expects := []string{
+		"a.codl: route admin.index: warning: includes unknown route help (or admin.help)",
+		"b.codl: route b: warning: includes unknown route admin.missing",
+	}
+	for i, expect := range expects {
+		if diags[i].String() != expect {
+			t.Errorf("Expected %q, got %q", expect, diags[i])
+		}
+	}
+}
+
+func TestCheckNamespaceOtherFile(t *testing.T) {
+	diags := checkDocs(t, map[string]string{
+		"a.codl": `
+ROUTE help "Help"
+NAMESPACE admin
+	ROUTE index "Index"
+		INCLUDES help
+		REROUTE help
+	ROUTE list "List" ON ERROR REROUTE help
+		DOES «x» x
+	ROUTE show "Show"
+		DOES «x» x ON ERROR REROUTE show
+END`,
+		"b.codl": `
+NAMESPACE admin
+	ROUTE help "Admin help"
+END`,
+	})
+
+	expects := []string{
+		"a.codl: route admin.index: warning: INCLUDES help does not find admin.help in b.codl, which is another file. Write the full name to use it",
+		"a.codl: route admin.index: warning: REROUTE help does not find admin.help in b.codl, which is another file. Write the full name to use it",
+		"a.codl: route admin.list: warning: ON ERROR REROUTE help does not find admin.help in b.codl, which is another file. Write the full name to use it",
+	}
+	if len(diags) != len(expects) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(expects), diags)
+	}
+	for i, expect := range expects {
+		if diags[i].String() != expect {
+			t.Errorf("Expected %q, got %q", expect, diags[i])
+		}
+	}
+}
+
+func TestCheckOnError(t *testing.T) {
+	diags := checkDocs(t, map[string]string{
+		"a.codl": `
+ROUTE errors.show "Show an error"
+ROUTE a "A" ON ERROR REROUTE errors.missing
+	DOES «a» a
+	DOES «b» b ON ERROR REROUTE errors.show
+	DOES «c» c ON ERROR REROUTE errors.gone`,
+	})
+
+	if len(diags) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %v", diags)
+	}
+	expects := []string{
+		"a.codl: route a: warning: reroutes on error to unknown route errors.missing",
+		"a.codl: route a: warning: DOES c reroutes on error to unknown route errors.gone",
+	}
+	for i, expect := range expects {
+		if diags[i].String() != expect {
+			t.Errorf("Expected %q, got %q", expect, diags[i])
+		}
+	}
+}
+
+func TestCheckFlow(t *testing.T) {
+	diags := checkDocs(t, map[string]string{
+		"a.codl": `
+ROUTE home "Home"
+ROUTE a "A"
+	REROUTE home
+	DOES «x» x
+	DOES «y» y
+ROUTE b "B"
+	IF profile dev STOP END
+	DOES «x» x
+	IF profile dev DOES «y» y END
+	REROUTE nowhere
+	STOP`,
+	})
+
+	expects := []string{
+		"a.codl: route a: warning: DOES x is unreachable after REROUTE home",
+		"a.codl: route b: warning: DOES y is unreachable after STOP",
+		"a.codl: route b: error: reroutes to unknown route nowhere",
+		"a.codl: route b: warning: STOP is unreachable after REROUTE nowhere",
+	}
+	if len(diags) != len(expects) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(expects), diags)
+	}
+	for i, expect := range expects {
+		if diags[i].String() != expect {
+			t.Errorf("Expected %q, got %q", expect, diags[i])
+		}
+	}
+	if !HasErrors(diags) {
+		t.Errorf("Expected an unknown REROUTE target to be an error")
+	}
+}
+
+func TestCheckPathSources(t *testing.T) {
+	diags := checkDocs(t, map[string]string{
+		"a.codl": `
+ROUTE GET "/users/{id}" "Show a user"
+	DOES «show» show
+		USING id FROM path:id
+		USING name FROM path:name
+ROUTE b "B"
+	DOES «x» x IF path:id`,
+	})
+
+	expects := []string{
+		"a.codl: route GET /users/*: warning: DOES show reads path:name, but the route's path has no {name}",
+		"a.codl: route b: warning: DOES x reads path:id, but the route's path has no {id}",
+	}
+	if len(diags) != len(expects) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(expects), diags)
+	}
+	for i, expect := range expects {
+		if diags[i].String() != expect {
+			t.Errorf("Expected %q, got %q", expect, diags[i])
+		}
+	}
+}
+
+func TestCheckContext(t *testing.T) {
+	diags := checkDocs(t, map[string]string{
+		"a.codl": `
+CONTEXT files []string
+ROUTE common "Common"
+	DOES «find» found
+ROUTE a "A"
+	DOES «x» x
+		USING files FROM cxt:files
+		USING late FROM cxt:y
+		USING typo FROM cxt:flies
+	INCLUDES common
+	DOES «y» y RETRY 2
+		USING found FROM cxt:found cxt:x query:x
+		USING err FROM cxt:codl.error
+	DOES «z» z
+		USING tries FROM cxt:y.attempts`,
+	})
+
+	expects := []string{
+		"a.codl: route a: warning: DOES x reads cxt:y before DOES y puts it there",
+		"a.codl: route a: warning: DOES x reads cxt:flies, which is not a CONTEXT key or the name of an earlier DOES",
+	}
+	if len(diags) != len(expects) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(expects), diags)
+	}
+	for i, expect := range expects {
+		if diags[i].String() != expect {
+			t.Errorf("Expected %q, got %q", expect, diags[i])
+		}
+	}
+}
+
+func TestCheckDatasources(t *testing.T) {
+	diags := checkDocs(t, map[string]string{
+		"a.codl": `
+DATASOURCE cfg «config.NewSource()»
+ROUTE a "A"
+	DOES «x» x
+		USING port FROM cfg:port query:port cxt:port
+		USING host FROM cgf:host`,
+		"b.codl": `
+ROUTE b "B"
+	DOES «y» y IF env:debug`,
+	})
+
+	expects := []string{
+		"a.codl: route a: warning: DOES x reads cgf:host, but there is no DATASOURCE cgf",
+		"b.codl: route b: warning: DOES y reads env:debug, but there is no DATASOURCE env",
+	}
+	if len(diags) != len(expects) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(expects), diags)
+	}
+	for i, expect := range expects {
+		if diags[i].String() != expect {
+			t.Errorf("Expected %q, got %q", expect, diags[i])
+		}
+	}
+}
+
+func TestCheckSubcommandContext(t *testing.T) {
+	diags := checkDocs(t, map[string]string{
+		"a.codl": `
+CONTEXT files []string
+FLAGS build
+	d string "." "Dir"
+SUBCOMMAND build "Build"
+	DOES «find» files
+		USING dir FROM cxt:d
+		USING tags FROM cxt:tags`,
+	})
+
+	expects := []string{
+		"a.codl: route build: warning: DOES files reads cxt:tags, which is not a CONTEXT key or the name of an earlier DOES",
+	}
+	if len(diags) != len(expects) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(expects), diags)
+	}
+	for i, expect := range expects {
+		if diags[i].String() != expect {
+			t.Errorf("Expected %q, got %q", expect, diags[i])
+		}
+	}
+}
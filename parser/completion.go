@@ -0,0 +1,258 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// completableRe matches the route names that a shell can complete without
+// quoting.
+var completableRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.+-]*$`)
+
+// Completion builds shell completion scripts for a command line app from
+// the routes and flag sets in a set of parsed CODL files. Routes marked
+// @hidden, internal routes such as "@update", and HTTP routes are left out.
+type Completion struct {
+	// Name is the program to complete. If it is empty, the APP's name is
+	// used.
+	Name string
+	regs []Registry
+}
+
+// completionCommand is one subcommand, with its flags.
+type completionCommand struct {
+	Name, Description string
+	Flags []*Flag
+}
+
+type completionContext struct {
+	Name, Func string
+	Globals []*Flag
+	Commands []*completionCommand
+}
+
+// NewCompletion creates completion scripts for the given files.
+func NewCompletion(name string, regs ...Registry) *Completion {
+	return &Completion{ Name: name, regs: regs }
+}
+
+// WriteBash writes a script for bash's complete builtin.
+func (c *Completion) WriteBash(out io.Writer) error {
+	return c.write(bashCompletion, out)
+}
+
+// WriteZsh writes a script for zsh's compsys.
+func (c *Completion) WriteZsh(out io.Writer) error {
+	return c.write(zshCompletion, out)
+}
+
+// WriteFish writes a script for fish's complete builtin.
+func (c *Completion) WriteFish(out io.Writer) error {
+	return c.write(fishCompletion, out)
+}
+
+func (c *Completion) write(tpl *template.Template, out io.Writer) error {
+	cxt, err := c.context()
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(out, cxt)
+}
+
+// context gathers the subcommands, and the flags of each one, in the order
+// that they are declared.
+func (c *Completion) context() (*completionContext, error) {
+	name := c.Name
+	sets := map[string]*FlagSet{}
+	for _, reg := range c.regs {
+		if app := reg.Application(); app != nil && name == "" {
+			name = Unquote(app.Name)
+		}
+		for _, fs := range reg.FlagSets() {
+			sets[fs.Name] = fs
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("No program to complete. Declare an APP, or give a name")
+	}
+
+	cxt := &completionContext{ Name: name, Func: "_" + strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name) }
+	if fs, ok := sets[name]; ok {
+		cxt.Globals = fs.Flags
+	}
+	for _, reg := range c.regs {
+		for _, r := range reg.Routes() {
+			route := Unquote(r.Name)
+			if r.IsHTTP() || r.Annotations.Hidden() || strings.HasPrefix(route, "@") || !completableRe.MatchString(route) {
+				continue
+			}
+			cmd := &completionCommand{ Name: route, Description: firstLine(Unquote(r.Description)) }
+			if r.Subcommand {
+				set := r.Flags
+				if set == "" {
+					set = route
+				}
+				if fs, ok := sets[set]; ok {
+					cmd.Flags = fs.Flags
+				}
+			}
+			cxt.Commands = append(cxt.Commands, cmd)
+		}
+	}
+	return cxt, nil
+}
+
+func firstLine(str string) string {
+	if i := strings.Index(str, "\n"); i >= 0 {
+		return strings.TrimSpace(str[:i])
+	}
+	return str
+}
+
+// valueFlags returns the names of the flags that take a value, as "-d|-o".
+func valueFlags(flags []*Flag) string {
+	names := []string{}
+	for _, f := range flags {
+		if f.Type != "bool" {
+			names = append(names, "-" + Unquote(f.Name))
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// shellQuote single-quotes a string for bash and zsh.
+func shellQuote(str string) string {
+	return "'" + strings.Replace(str, "'", `'\''`, -1) + "'"
+}
+
+// fishQuote single-quotes a string for fish.
+func fishQuote(str string) string {
+	str = strings.Replace(str, `\`, `\\`, -1)
+	return "'" + strings.Replace(str, "'", `\'`, -1) + "'"
+}
+
+// zshFlag writes a flag as an _arguments spec: '-d[The directory.]:d:_files'.
+func zshFlag(f *Flag) string {
+	help := strings.NewReplacer(`[`, `\[`, `]`, `\]`).Replace(firstLine(Unquote(f.Help)))
+	spec := "-" + Unquote(f.Name) + "[" + help + "]"
+	if f.Type != "bool" {
+		spec += ":" + Unquote(f.Name) + ":_files"
+	}
+	return shellQuote(spec)
+}
+
+// zshCommand writes a command as a _describe item: 'build:Build a directory'.
+func zshCommand(c *completionCommand) string {
+	return shellQuote(c.Name + ":" + c.Description)
+}
+
+func completionTemplate(name, text string) *template.Template {
+	return template.Must(template.New(name).Funcs(template.FuncMap{
+		"unquote": Unquote,
+		"firstLine": firstLine,
+		"valueFlags": valueFlags,
+		"shellQuote": shellQuote,
+		"fishQuote": fishQuote,
+		"zshFlag": zshFlag,
+		"zshCommand": zshCommand,
+	}).Parse(text))
+}
+
+var bashCompletion = completionTemplate("bash", `# bash completion for {{.Name}}. This file is auto-generated by Codl.
+
+{{.Func}}() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	local prev=${COMP_WORDS[COMP_CWORD-1]}
+	local cmd="" i
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		-*) ;;
+		*) cmd=${COMP_WORDS[i]}; break ;;
+		esac
+	done
+
+	case $cmd in
+	"")
+{{- with valueFlags .Globals}}
+		case $prev in
+		{{.}}) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+		esac
+{{- end}}
+		COMPREPLY=($(compgen -W "{{range .Globals}}-{{unquote .Name}} {{end}}{{range $i, $c := .Commands}}{{if $i}} {{end}}{{.Name}}{{end}}" -- "$cur"))
+		;;
+{{- range .Commands}}{{if .Flags}}
+	{{.Name}})
+{{- with valueFlags .Flags}}
+		case $prev in
+		{{.}}) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+		esac
+{{- end}}
+		COMPREPLY=($(compgen -W "{{range $i, $f := .Flags}}{{if $i}} {{end}}-{{unquote .Name}}{{end}}" -- "$cur"))
+		;;
+{{- end}}{{end}}
+	esac
+}
+
+complete -F {{.Func}} {{.Name}}
+`)
+
+var zshCompletion = completionTemplate("zsh", `#compdef {{.Name}}
+# zsh completion for {{.Name}}. This file is auto-generated by Codl.
+
+{{.Func}}() {
+	local state line
+	_arguments -C \
+{{- range .Globals}}
+		{{zshFlag .}} \
+{{- end}}
+		'1: :->command' \
+		'*:: :->args'
+
+	case $state in
+	command)
+		local -a commands
+		commands=(
+{{- range .Commands}}
+			{{zshCommand .}}
+{{- end}}
+		)
+		_describe -t commands {{shellQuote (print .Name " command")}} commands
+		;;
+	args)
+		case $line[1] in
+{{- range .Commands}}{{if .Flags}}
+		{{.Name}})
+			_arguments{{range .Flags}} \
+				{{zshFlag .}}{{end}}
+			;;
+{{- end}}{{end}}
+		esac
+		;;
+	esac
+}
+
+{{.Func}} "$@"
+`)
+
+var fishCompletion = completionTemplate("fish", `# fish completion for {{.Name}}. This file is auto-generated by Codl.
+
+complete -c {{.Name}} -f
+{{- $name := .Name}}
+{{- range .Globals}}
+complete -c {{$name}} -n __fish_use_subcommand -o {{unquote .Name}}{{if ne .Type "bool"}} -r -F{{end}} -d {{fishQuote (firstLine (unquote .Help))}}
+{{- end}}
+{{- range .Commands}}
+complete -c {{$name}} -n __fish_use_subcommand -a {{.Name}} -d {{fishQuote .Description}}
+{{- end}}
+{{- range $c := .Commands}}{{range .Flags}}
+complete -c {{$name}} -n '__fish_seen_subcommand_from {{$c.Name}}' -o {{unquote .Name}}{{if ne .Type "bool"}} -r -F{{end}} -d {{fishQuote (firstLine (unquote .Help))}}
+{{- end}}{{end}}
+`)
@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata.")
+
+func TestCompletion(t *testing.T) {
+	h, err := ParseFile("testdata/completion/app.codl")
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	c := NewCompletion("", h.(Registry))
+
+	shells := map[string]func(io.Writer) error{
+		"bash": c.WriteBash,
+		"zsh": c.WriteZsh,
+		"fish": c.WriteFish,
+	}
+	for shell, write := range shells {
+		var out bytes.Buffer
+		if err := write(&out); err != nil {
+			t.Fatalf("Failed to write the %s script: %s", shell, err)
+		}
+		golden := "testdata/completion/app." + shell
+		if *update {
+			if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+				t.Fatalf("Failed to update %s: %s", golden, err)
+			}
+			continue
+		}
+		expect, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s", golden, err)
+		}
+		if out.String() != string(expect) {
+			t.Errorf("The %s script does not match %s. Got:\n%s", shell, golden, out.String())
+		}
+	}
+}
+
+func TestCompletionName(t *testing.T) {
+	h, err := Parse(strings.NewReader(`ROUTE run "Run"`))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := NewCompletion("", h.(Registry)).WriteBash(&out); err == nil {
+		t.Errorf("Expected an error without an APP or a name")
+	}
+	if err := NewCompletion("my-tool", h.(Registry)).WriteBash(&out); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if !strings.Contains(out.String(), "complete -F _my_tool my-tool") {
+		t.Errorf("Expected a function for my-tool in:\n%s", out.String())
+	}
+}
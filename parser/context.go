@@ -0,0 +1,201 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var contextKeyRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+
+// ContextKey is a context key declared with CONTEXT, along with its Go type.
+type ContextKey struct {
+	// Name and Description are quoted, like other strings. Type is Go code.
+	Name, Type, Description string
+	// Default is Go code for the DEFAULT value, if any.
+	Default string
+	// Shared is true if the key is from a file in the same directory, which
+	// declares the accessors in its own generated code.
+	Shared bool
+}
+
+// Func is the name that the key's accessors are built on: "runner.Args"
+// has GetRunnerArgs and SetRunnerArgs.
+func (k *ContextKey) Func() string {
+	name := ""
+	for _, part := range strings.FieldsFunc(Unquote(k.Name), func(r rune) bool {
+		return r == '.' || r == '-' || r == '_'
+	}) {
+		name += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return name
+}
+
+// Context starts a CONTEXT declaration. It takes a key, a Go type, an
+// optional description, and an optional DEFAULT. It ends any open ROUTE.
+func (l *handler) Context() {
+	if !l.unannotated("CONTEXT") || !l.ungrouped("CONTEXT") {
+		return
+	}
+	if len(l.profiles()) > 0 {
+		l.err = fmt.Errorf("CONTEXT cannot be inside an IF profile block")
+		return
+	}
+	l.mode = ContextMode
+	l.currentRoute = nil
+	l.withParam = nil
+	l.hook = nil
+	l.contextKey = &ContextKey{}
+	l.contexts = append(l.contexts, l.contextKey)
+}
+
+// Default starts the DEFAULT value of a CONTEXT key.
+func (l *handler) Default() {
+	if !l.unannotated("DEFAULT") {
+		return
+	}
+	k := l.contextKey
+	if l.mode != ContextMode || k == nil || k.Type == "" {
+		l.err = fmt.Errorf("DEFAULT must come after a CONTEXT key and its type")
+		return
+	}
+	l.mode = DefaultMode
+}
+
+// inContextType reports whether the next word is a CONTEXT key's type.
+func (l *handler) inContextType() bool {
+	k := l.contextKey
+	return l.mode == ContextMode && k != nil && k.Name != "" && k.Type == ""
+}
+
+// contextWord handles a string or literal in a CONTEXT declaration. code is
+// true if the value is already Go code, rather than a string to be quoted.
+func (l *handler) contextWord(str string, code bool) {
+	k := l.contextKey
+	switch {
+	case l.mode == DefaultMode && k.Default == "":
+		k.Default = str
+		if !code {
+			k.Default = asString(str)
+		}
+	case l.mode == DefaultMode:
+		l.err = fmt.Errorf("DEFAULT takes one value. No place for %s", str)
+	case k.Name == "":
+		if code || !contextKeyRe.MatchString(str) {
+			l.err = fmt.Errorf("Illegal CONTEXT key: %s", str)
+			return
+		}
+		k.Name = asString(str)
+		for _, other := range l.contexts {
+			if other != k && other.Func() == k.Func() {
+				l.err = fmt.Errorf("CONTEXT %s and %s would have the same accessors", Unquote(other.Name), str)
+				return
+			}
+		}
+	case k.Type == "":
+		k.Type = str
+		l.code(LiteralType, str, !code)
+	case k.Description == "" && !code:
+		k.Description = asString(str)
+	default:
+		l.err = fmt.Errorf("CONTEXT takes a key, a type and a description. No place for %s", str)
+	}
+}
+
+// contextDone reports whether the last CONTEXT has its type, and its
+// DEFAULT has a value. If not, that is an error.
+func (l *handler) contextDone() bool {
+	k := l.contextKey
+	switch {
+	case k == nil || l.mode != ContextMode && l.mode != DefaultMode:
+		return true
+	case k.Type == "":
+		l.err = fmt.Errorf("CONTEXT %s requires a name and a Go type", Unquote(k.Name))
+	case l.mode == DefaultMode && k.Default == "":
+		l.err = fmt.Errorf("DEFAULT requires a value for CONTEXT %s", Unquote(k.Name))
+	default:
+		return true
+	}
+	return false
+}
+
+// Contexts returns the declared CONTEXT keys.
+func (l *handler) Contexts() []*ContextKey {
+	return l.contexts
+}
+
+// conversion returns Go code that converts a default value to a key's type,
+// so that it is stored with that type: "int64(5)".
+func conversion(k *ContextKey) string {
+	t := k.Type
+	if strings.HasPrefix(t, "*") || strings.HasPrefix(t, "<-") || strings.HasPrefix(t, "func") {
+		t = "(" + t + ")"
+	}
+	return t + "(" + k.Default + ")"
+}
+
+// contextWarnings follows the context through a route's commands, and
+// describes each cxt: source that reads a key that is neither declared with
+// CONTEXT nor put there by an earlier command.
+//
+// A command puts its result under its own name. Names in INCLUDES routes
+// count, as do "name.attempts" after a RETRY, and the error that ON ERROR
+// passes on.
+func contextWarnings(r *Route, routes map[string]*Route, declared map[string]bool) []string {
+	known := map[string]bool{ "codl.error": true }
+	for key := range declared {
+		known[key] = true
+	}
+	later := map[string]string{}
+	for _, put := range putNames(r.Commands, routes, map[*Route]bool{}) {
+		later[put[0]] = put[1]
+	}
+
+	warnings := []string{}
+	for _, cmd := range r.Commands {
+		if !cmd.IsIncludes() && !cmd.IsFlow() {
+			for _, c := range append([]*Command{cmd}, cmd.Steps...) {
+				for _, source := range sources(c) {
+					key := strings.TrimPrefix(source, "cxt:")
+					if key == source || known[key] {
+						continue
+					}
+					if by, ok := later[key]; ok {
+						warnings = append(warnings, fmt.Sprintf("DOES %s reads %s before DOES %s puts it there", Unquote(c.Name), source, by))
+					} else {
+						warnings = append(warnings, fmt.Sprintf("DOES %s reads %s, which is not a CONTEXT key or the name of an earlier DOES", Unquote(c.Name), source))
+					}
+				}
+			}
+		}
+		for _, put := range putNames([]*Command{cmd}, routes, map[*Route]bool{}) {
+			known[put[0]] = true
+		}
+	}
+	return warnings
+}
+
+// putNames returns the context keys that commands put values under, each
+// with the name of the command that puts it.
+func putNames(cmds []*Command, routes map[string]*Route, seen map[*Route]bool) [][2]string {
+	names := [][2]string{}
+	for _, c := range cmds {
+		switch {
+		case c.IsFlow():
+		case c.IsIncludes():
+			if inc, ok := routes[c.Name]; ok && !seen[inc] {
+				seen[inc] = true
+				names = append(names, putNames(inc.Commands, routes, seen)...)
+			}
+		default:
+			for _, c := range append([]*Command{c}, c.Steps...) {
+				name := Unquote(c.Name)
+				names = append(names, [2]string{name, name})
+				if c.Retries > 0 {
+					names = append(names, [2]string{name + ".attempts", name})
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var datasourceRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// builtinPrefixes are the FROM prefixes that cookoo provides without a
+// DATASOURCE: the context itself, and the datasources of cookoo's web
+// server.
+var builtinPrefixes = []string{"cxt", "context", "url", "path", "query", "form", "post", "header"}
+
+// Datasource is a datasource declared with DATASOURCE.
+type Datasource struct {
+	// Name is the FROM prefix, quoted like other strings. Source is Go code
+	// that creates the datasource.
+	Name, Source string
+	Profiles []string
+}
+
+// Datasource starts a DATASOURCE declaration. It takes a name and the Go
+// code that creates the datasource. It ends any open ROUTE.
+func (l *handler) Datasource() {
+	if !l.unannotated("DATASOURCE") || !l.ungrouped("DATASOURCE") {
+		return
+	}
+	l.mode = DatasourceMode
+	l.currentRoute = nil
+	l.withParam = nil
+	l.hook = nil
+	l.datasource = &Datasource{ Profiles: l.profiles() }
+	l.datasources = append(l.datasources, l.datasource)
+}
+
+// datasourceWord handles a string or literal in a DATASOURCE declaration.
+// Either way, the source is used as Go code.
+func (l *handler) datasourceWord(str string, code bool) {
+	d := l.datasource
+	switch {
+	case d.Name == "":
+		if code || !datasourceRe.MatchString(str) {
+			l.err = fmt.Errorf("Illegal DATASOURCE name: %s", str)
+			return
+		}
+		for _, prefix := range builtinPrefixes {
+			if str == prefix {
+				l.err = fmt.Errorf("DATASOURCE %s is built in", str)
+				return
+			}
+		}
+		for _, other := range l.datasources {
+			if other != d && Unquote(other.Name) == str && sameProfiles(other.Profiles, d.Profiles) {
+				l.err = fmt.Errorf("DATASOURCE %s is declared more than once", str)
+				return
+			}
+		}
+		d.Name = asString(str)
+	case d.Source == "":
+		d.Source = str
+		l.code(LiteralCode, str, !code)
+	default:
+		l.err = fmt.Errorf("DATASOURCE takes a name and one source. No place for %s", str)
+	}
+}
+
+// datasourceDone reports whether the last DATASOURCE has its source. If not,
+// that is an error.
+func (l *handler) datasourceDone() bool {
+	d := l.datasource
+	if l.mode != DatasourceMode || d == nil || d.Source != "" {
+		return true
+	}
+	l.err = fmt.Errorf("DATASOURCE %s requires a name and Go code for the source", Unquote(d.Name))
+	return false
+}
+
+// Datasources returns the declared DATASOURCEs.
+func (l *handler) Datasources() []*Datasource {
+	return l.datasources
+}
+
+func sameProfiles(a, b []string) bool {
+	return strings.Join(a, " ") == strings.Join(b, " ")
+}
+
+// unknownPrefix returns the prefix of a source, such as "cfg" in "cfg:port",
+// if it is neither built in nor declared. Otherwise it returns "".
+func unknownPrefix(source string, declared map[string]bool) string {
+	i := strings.Index(source, ":")
+	if i < 0 {
+		return ""
+	}
+	prefix := source[:i]
+	if declared[prefix] {
+		return ""
+	}
+	for _, builtin := range builtinPrefixes {
+		if prefix == builtin {
+			return ""
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"fmt"
+)
+
+// IsReroute reports whether the command is a REROUTE statement.
+func (c *Command) IsReroute() bool {
+	return c.cmdType == cmdReroute
+}
+
+// IsStop reports whether the command is a STOP statement.
+func (c *Command) IsStop() bool {
+	return c.cmdType == cmdStop
+}
+
+// IsFlow reports whether the command is a REROUTE or STOP, which end the
+// route rather than run Go code.
+func (c *Command) IsFlow() bool {
+	return c.IsReroute() || c.IsStop()
+}
+
+// Reroute starts a REROUTE statement. The next string is the route to go
+// to. In an ON ERROR clause, it is part of the clause instead.
+func (l *handler) Reroute() {
+	if c := l.clause; c != nil && c.expect == "REROUTE" {
+		l.clauseWord("REROUTE")
+		return
+	}
+	l.flow(cmdReroute, "REROUTE", "`@reroute`")
+}
+
+// Stop adds a STOP statement, which ends the route.
+func (l *handler) Stop() {
+	l.flow(cmdStop, "STOP", "`@stop`")
+}
+
+func (l *handler) flow(cmdType int, keyword, name string) {
+	if !l.unannotated(keyword) || !l.ungrouped(keyword) || !l.hookReady() {
+		return
+	}
+	switch l.mode {
+	case TopMode, ImportMode, ClosedMode, UseMode, ContextMode, DefaultMode, DatasourceMode, AppMode, FlagsMode, GoMode:
+		l.err = fmt.Errorf("%s can only appear inside of a ROUTE.", keyword)
+		return
+	case TestMode:
+		l.err = fmt.Errorf("%s cannot follow a TEST", keyword)
+		return
+	}
+	c := &Command{ cmdType: cmdType, Name: name, Profiles: l.profiles(), Namespace: l.namespace() }
+	l.mode = FlowMode
+	l.withParam = nil
+	l.currentRoute.Commands = append(l.currentRoute.Commands, c)
+	l.currentRoute.currentCommand = c
+}
+
+// flowWord handles a string after a REROUTE or STOP.
+func (l *handler) flowWord(word string) {
+	c := l.currentRoute.currentCommand
+	if c.IsReroute() && c.Target == "" {
+		c.Target = asString(word)
+		return
+	}
+	if c.IsReroute() {
+		l.err = fmt.Errorf("REROUTE takes one route name. No place for %s", word)
+		return
+	}
+	l.err = fmt.Errorf("STOP takes no arguments. No place for %s", word)
+}
+
+// flowReady reports whether a REROUTE has its route name. If it does not,
+// that is an error.
+func (l *handler) flowReady() bool {
+	if l.mode != FlowMode {
+		return true
+	}
+	if c := l.currentRoute.currentCommand; c.IsReroute() && c.Target == "" {
+		l.err = fmt.Errorf("REROUTE requires a route name")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"fmt"
+	gparser "go/parser"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// GoBlock is Go code from a GO block, which is copied into the generated
+// file after the imports.
+type GoBlock struct {
+	// Code is the block without its imports, which are merged into the
+	// file's imports.
+	Code string
+	Profiles []string
+	// Imports are the imports that the block declared.
+	Imports []string
+	// Shared is true if the block is from a file in the same directory,
+	// which declares it in its own generated code.
+	Shared bool
+}
+
+// Go starts a GO block, which takes one literal of Go declarations. It ends
+// any open ROUTE.
+func (l *handler) Go() {
+	if !l.unannotated("GO") || !l.ungrouped("GO") {
+		return
+	}
+	l.closeRoute(GoMode)
+	l.goBlock = &GoBlock{ Profiles: l.profiles() }
+}
+
+// templateImports are the packages that the generated file imports itself:
+// cookoo always, and support when the code uses it.
+var templateImports = map[string]bool{
+	"github.com/Masterminds/cookoo": true,
+	"github.com/Masterminds/codl/support": true,
+}
+
+// goCode handles the literal in a GO block. The code must parse as Go
+// declarations. Its imports are merged into the file's imports, except for
+// the ones that the generated file has anyway, and the rest is kept.
+func (l *handler) goCode(src string) {
+	if l.goBlock == nil {
+		l.err = fmt.Errorf("GO takes one literal. No place for %s", src)
+		return
+	}
+	b := l.goBlock
+	l.goBlock = nil
+
+	// The package clause is one line, so lines in errors are off by one.
+	fset := token.NewFileSet()
+	f, err := gparser.ParseFile(fset, "", "package codl\n" + src, gparser.ParseComments)
+	if err != nil {
+		if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+			l.err = fmt.Errorf("GO block is not valid Go: line %d: %s", list[0].Pos.Line - 1, list[0].Msg)
+			return
+		}
+		l.err = fmt.Errorf("GO block is not valid Go: %s", err)
+		return
+	}
+
+	offset := len("package codl\n")
+	code := src
+	for i := len(f.Decls) - 1; i >= 0; i-- {
+		d, ok := f.Decls[i].(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range d.Specs {
+			s := spec.(*ast.ImportSpec)
+			path, _ := strconv.Unquote(s.Path.Value)
+			if s.Name == nil && templateImports[path] {
+				continue
+			}
+			imp := asString(path)
+			if s.Name != nil {
+				imp = s.Name.Name + " " + imp
+			}
+			l.importFor(imp, b.Profiles)
+			b.Imports = append(b.Imports, imp)
+		}
+		start := d.Pos()
+		if d.Doc != nil {
+			start = d.Doc.Pos()
+		}
+		code = code[:fset.Position(start).Offset - offset] + code[fset.Position(d.End()).Offset - offset:]
+	}
+
+	b.Code = strings.TrimSpace(code)
+	if b.Code != "" {
+		l.goBlocks = append(l.goBlocks, b)
+	}
+}
+
+// goDone reports whether the last GO block has its code. If not, that is
+// an error.
+func (l *handler) goDone() bool {
+	if l.goBlock == nil {
+		return true
+	}
+	l.err = fmt.Errorf("GO requires a literal with Go code: GO «...»")
+	return false
+}
+
+// importFor imports a package for the given profiles, unless it is already
+// imported for all of them.
+func (l *handler) importFor(imp string, profiles []string) {
+	if len(profiles) == 0 {
+		l.addImport(imp)
+		return
+	}
+	if !l.hasImport(imp) {
+		l.imports = append(l.imports, imp)
+		l.importConds = append(l.importConds, profiles)
+	}
+}
+
+// GoBlocks returns the code from GO blocks.
+func (l *handler) GoBlocks() []*GoBlock {
+	return l.goBlocks
+}
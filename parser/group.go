@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"fmt"
+)
+
+// IsParallel reports whether the command is a PARALLEL block, whose Steps
+// run at the same time.
+func (c *Command) IsParallel() bool {
+	return c.cmdType == cmdParallel
+}
+
+// startGroup opens a PARALLEL block. It may be followed by a name, and then
+// holds DOES commands until END.
+func (l *handler) startGroup() {
+	if !l.ungrouped("PARALLEL") || !l.hookReady() {
+		return
+	}
+	switch l.mode {
+	case TopMode, ImportMode, ClosedMode, UseMode, ContextMode, DefaultMode, DatasourceMode, AppMode, FlagsMode, GoMode:
+		l.err = fmt.Errorf("PARALLEL can only appear inside of a ROUTE.")
+		return
+	case TestMode:
+		l.err = fmt.Errorf("PARALLEL cannot follow a TEST")
+		return
+	}
+	g := &Command{ cmdType: cmdParallel, Profiles: l.profiles(), Namespace: l.namespace() }
+	l.mode = GroupMode
+	l.withParam = nil
+	l.currentRoute.Commands = append(l.currentRoute.Commands, g)
+	l.currentRoute.currentCommand = g
+	l.blocks = append(l.blocks, &block{ group: g, route: l.currentRoute })
+}
+
+// groupWord handles a string after PARALLEL, which names the block.
+func (l *handler) groupWord(word string) {
+	g := l.group()
+	if g == nil || g.Name != "" || len(g.Steps) > 0 {
+		l.err = fmt.Errorf("PARALLEL takes one name. No place for %s", word)
+		return
+	}
+	g.Name = asString(word)
+}
+
+// endGroup closes a PARALLEL block. Nothing but a new command may follow
+// its END.
+func (l *handler) endGroup(g *Command) {
+	if len(g.Steps) == 0 {
+		l.err = fmt.Errorf("PARALLEL requires at least one DOES")
+		return
+	}
+	names := map[string]bool{}
+	for _, step := range g.Steps {
+		if names[step.Name] {
+			l.err = fmt.Errorf("PARALLEL has two commands named %s", Unquote(step.Name))
+			return
+		}
+		names[step.Name] = true
+	}
+	if g.Name == "" {
+		g.Name = asString("@parallel")
+	}
+	l.mode = GroupMode
+	l.withParam = nil
+	l.currentRoute.currentCommand = g
+}
+
+// group returns the command of the open PARALLEL block, if any.
+func (l *handler) group() *Command {
+	for i := len(l.blocks) - 1; i >= 0; i-- {
+		if g := l.blocks[i].group; g != nil {
+			return g
+		}
+	}
+	return nil
+}
+
+// ungrouped reports whether no PARALLEL block is open. If one is, keyword
+// cannot appear here, which is an error.
+func (l *handler) ungrouped(keyword string) bool {
+	if l.group() == nil {
+		return true
+	}
+	l.err = fmt.Errorf("%s is not allowed inside of a PARALLEL block", keyword)
+	return false
+}
@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"fmt"
+	"path"
+)
+
+// Hook is a BEFORE ROUTES or AFTER ROUTES block. Its commands are added to
+// the start or end of every matching route when the file is translated.
+type Hook struct {
+	After bool
+	// Match is a pattern for route names, as used by path.Match. Empty
+	// matches every route.
+	Match string
+	// Tag limits the hook to routes with this @tag. Empty matches every
+	// route.
+	Tag string
+	// Commands holds the hook's DOES and INCLUDES. Its Profiles are the IF
+	// blocks the hook was declared in.
+	Commands *Route
+
+	// The word the hook expects next: "ROUTES", "MATCHING" or "TAGGED" for
+	// their arguments, or "" once ROUTES has been seen.
+	expect string
+}
+
+// Matches reports whether the hook applies to a route. Routes marked with
+// @nohooks never match.
+func (h *Hook) Matches(r *Route) bool {
+	if r.Annotations.has("nohooks") {
+		return false
+	}
+	if h.Match != "" {
+		if ok, _ := path.Match(h.Match, Unquote(r.Name)); !ok {
+			return false
+		}
+	}
+	return h.Tag == "" || r.Annotations.HasTag(h.Tag)
+}
+
+func (h *Hook) keyword() string {
+	if h.After {
+		return "AFTER"
+	}
+	return "BEFORE"
+}
+
+// Before starts a BEFORE ROUTES block.
+func (l *handler) Before() {
+	l.startHook(false)
+}
+
+// After starts an AFTER ROUTES block.
+func (l *handler) After() {
+	l.startHook(true)
+}
+
+func (l *handler) startHook(after bool) {
+	h := &Hook{ After: after, expect: "ROUTES" }
+	if !l.unannotated(h.keyword()) || !l.ungrouped(h.keyword()) {
+		return
+	}
+	if l.namespace() != "" {
+		l.err = fmt.Errorf("%s ROUTES is not allowed inside of a NAMESPACE", h.keyword())
+		return
+	}
+	h.Commands = &Route{ Profiles: l.profiles() }
+	l.hooks = append(l.hooks, h)
+	l.hook = h
+	l.currentRoute = h.Commands
+	l.withParam = nil
+	l.mode = HookMode
+}
+
+// hookWord handles a string in the head of a BEFORE or AFTER block.
+func (l *handler) hookWord(word string) {
+	h := l.hook
+	switch h.expect {
+	case "ROUTES":
+		if word != "ROUTES" {
+			l.err = fmt.Errorf("%s must be followed by ROUTES, not %s", h.keyword(), word)
+			return
+		}
+		h.expect = ""
+	case "":
+		if word != "MATCHING" && word != "TAGGED" {
+			l.err = fmt.Errorf("%s ROUTES takes MATCHING or TAGGED, not %s", h.keyword(), word)
+			return
+		}
+		h.expect = word
+	case "MATCHING":
+		if _, err := path.Match(word, ""); err != nil {
+			l.err = fmt.Errorf("Bad MATCHING pattern %s: %s", word, err)
+			return
+		}
+		h.Match = word
+		h.expect = ""
+	case "TAGGED":
+		h.Tag = word
+		h.expect = ""
+	}
+}
+
+// hookReady reports whether the head of a BEFORE or AFTER block is complete,
+// so that it can take commands.
+func (l *handler) hookReady() bool {
+	if l.mode != HookMode || l.hook.expect == "" {
+		return true
+	}
+	if l.hook.expect == "ROUTES" {
+		l.err = fmt.Errorf("%s must be followed by ROUTES", l.hook.keyword())
+	} else {
+		l.err = fmt.Errorf("%s requires a value", l.hook.expect)
+	}
+	return false
+}
+
+// applyHooks adds the commands of each hook to the routes it matches.
+// BEFORE commands go first and AFTER commands go last, in the order the
+// hooks were declared.
+func (l *handler) applyHooks() {
+	if len(l.hooks) == 0 {
+		return
+	}
+	for _, r := range l.routes {
+		before := []*Command{}
+		after := []*Command{}
+		for _, h := range l.hooks {
+			if !h.Matches(r) {
+				continue
+			}
+			for _, c := range h.Commands.Commands {
+				nc := c.copy()
+				if len(h.Commands.Profiles) > 0 {
+					nc.Profiles = append(append([]string{}, h.Commands.Profiles...), c.Profiles...)
+				}
+				if h.After {
+					after = append(after, nc)
+				} else {
+					before = append(before, nc)
+				}
+			}
+		}
+		if len(before) + len(after) > 0 {
+			r.Commands = append(append(before, r.Commands...), after...)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// httpMethods are the methods an HTTP ROUTE may have.
+var httpMethods = map[string]bool{
+	"GET": true,
+	"HEAD": true,
+	"POST": true,
+	"PUT": true,
+	"PATCH": true,
+	"DELETE": true,
+	"OPTIONS": true,
+}
+
+// A word like this, followed by a path, starts an HTTP ROUTE.
+var methodRe = regexp.MustCompile(`^[A-Z]+$`)
+
+var (
+	placeholderRe = regexp.MustCompile(`^\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+	segmentRe = regexp.MustCompile(`^[A-Za-z0-9._~!$&'()+,;=:@%-]+$`)
+)
+
+// IsHTTP reports whether the route was declared as ROUTE METHOD "/path".
+func (r *Route) IsHTTP() bool {
+	return r.Method != ""
+}
+
+// isPath reports whether str, following the first word of a ROUTE, is the
+// path of an HTTP route.
+func (r *Route) isPath(str string) bool {
+	return r.Method == "" && r.Description == "" && methodRe.MatchString(r.firstWord) && strings.HasPrefix(str, "/")
+}
+
+// httpRoute turns the current ROUTE into an HTTP route, named the way
+// cookoo's web router expects: "GET /users/{id}" becomes "GET /users/*".
+func (l *handler) httpRoute(r *Route, path string) {
+	if !httpMethods[r.firstWord] {
+		l.err = fmt.Errorf("ROUTE %s %s: unknown HTTP method %s", r.firstWord, path, r.firstWord)
+		return
+	}
+	params, err := pathParams(path)
+	if err != nil {
+		l.err = fmt.Errorf("ROUTE %s %s: %s", r.firstWord, path, err)
+		return
+	}
+	r.Method = r.firstWord
+	r.Path = path
+	r.PathParams = params
+	r.Name = asString(r.Method + " " + placeholderless(path))
+}
+
+// pathParams checks a path pattern and returns the names of its {name}
+// placeholders, in order.
+func pathParams(path string) ([]string, error) {
+	if path == "/" {
+		return nil, nil
+	}
+	params := []string{}
+	for _, seg := range strings.Split(path[1:], "/") {
+		if m := placeholderRe.FindStringSubmatch(seg); m != nil {
+			for _, p := range params {
+				if p == m[1] {
+					return nil, fmt.Errorf("{%s} appears more than once", p)
+				}
+			}
+			params = append(params, m[1])
+		} else if seg != "*" && !segmentRe.MatchString(seg) {
+			return nil, fmt.Errorf("illegal path segment %q", seg)
+		}
+	}
+	return params, nil
+}
+
+// placeholderless replaces each placeholder in a path with "*".
+func placeholderless(path string) string {
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		if placeholderRe.MatchString(seg) {
+			segs[i] = "*"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// pathIndex returns the index that cookoo's path datasource uses for the
+// placeholder name: in "/users/{id}", path:id is path:2.
+func pathIndex(path, name string) (int, bool) {
+	for i, seg := range strings.Split(path, "/") {
+		if m := placeholderRe.FindStringSubmatch(seg); m != nil && m[1] == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// resolvePaths rewrites the path:name sources in each HTTP route to the
+// path:N that cookoo's path datasource understands. Sources that name no
+// placeholder are left for the checker to report.
+func (l *handler) resolvePaths() {
+	for _, r := range l.routes {
+		if !r.IsHTTP() {
+			continue
+		}
+		resolve := func(source *string) {
+			name := strings.TrimPrefix(Unquote(*source), "path:")
+			if name == Unquote(*source) {
+				return
+			}
+			if i, ok := pathIndex(r.Path, name); ok {
+				*source = asString("path:" + strconv.Itoa(i))
+			}
+		}
+		for _, c := range r.Commands {
+			for _, c := range append([]*Command{c}, c.Steps...) {
+				for _, u := range c.Params {
+					for i := range u.From {
+						resolve(&u.From[i])
+					}
+				}
+				resolve(&c.Condition)
+				if c.ForEach != nil {
+					resolve(&c.ForEach.Source)
+				}
+			}
+		}
+	}
+}
+
+// sources returns the FROM, IF, UNLESS and FOREACH sources that a command
+// reads, unquoted.
+func sources(c *Command) []string {
+	s := []string{}
+	for _, u := range c.Params {
+		for _, from := range u.From {
+			s = append(s, Unquote(from))
+		}
+	}
+	if c.Condition != "" {
+		s = append(s, Unquote(c.Condition))
+	}
+	if c.ForEach != nil {
+		s = append(s, Unquote(c.ForEach.Source))
+	}
+	return s
+}
+
+// unknownPath returns the name in a path:name source that resolvePaths
+// could not resolve, or "".
+func unknownPath(source string) string {
+	if !strings.HasPrefix(source, "path:") {
+		return ""
+	}
+	name := source[len("path:"):]
+	if _, err := strconv.Atoi(name); err == nil {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// override starts a WITH that overrides a parameter of the route that an
+// INCLUDES includes.
+func (l *handler) override(inc *Command) {
+	if len(inc.Name) == 0 {
+		l.err = fmt.Errorf("WITH requires INCLUDES to have a route name first")
+		return
+	}
+	u := &Using{ Profiles: l.profiles() }
+	inc.Overrides = append(inc.Overrides, u)
+	l.withParam = u
+	l.mode = WithMode
+}
+
+// inlineIncludes replaces each INCLUDES that has WITH overrides with copies
+// of the included route's commands, with the overrides applied.
+//
+// The included route must be in the same file or in a file it USEs. It is
+// inlined after its own INCLUDES are, so overrides apply all the way down.
+func (l *handler) inlineIncludes() error {
+	byName := map[string]*Route{}
+	for _, r := range l.routes {
+		byName[r.Name] = r
+	}
+
+	// 1 while a route's includes are being inlined, 2 once they are done.
+	state := map[*Route]int{}
+	var expand func(r *Route, via []string) error
+	expand = func(r *Route, via []string) error {
+		switch state[r] {
+		case 1:
+			return fmt.Errorf("INCLUDES cycle: %s", strings.Join(via, " -> "))
+		case 2:
+			return nil
+		}
+		state[r] = 1
+		cmds := []*Command{}
+		for _, c := range r.Commands {
+			if len(c.Overrides) == 0 {
+				cmds = append(cmds, c)
+				continue
+			}
+			target, ok := byName[c.Name]
+			if !ok {
+				return fmt.Errorf("INCLUDES %s WITH: no route named %s in this file or the files it uses", Unquote(c.Name), Unquote(c.Name))
+			}
+			if err := expand(target, append(via, Unquote(target.Name))); err != nil {
+				return err
+			}
+			copies, err := inline(target, c)
+			if err != nil {
+				return err
+			}
+			for _, nc := range copies {
+				if nc.Name != "" && !nc.IsIncludes() && !nc.IsFlow() && hasCommand(r.Commands, nc.Name) {
+					return fmt.Errorf("INCLUDES %s WITH: DOES %s is already in the including route", Unquote(c.Name), Unquote(nc.Name))
+				}
+			}
+			cmds = append(cmds, copies...)
+			r.inlined = append(r.inlined, c)
+		}
+		r.Commands = cmds
+		state[r] = 2
+		return nil
+	}
+
+	for _, r := range l.routes {
+		if err := expand(r, []string{Unquote(r.Name)}); err != nil {
+			return err
+		}
+	}
+	for _, h := range l.hooks {
+		if err := expand(h.Commands, []string{h.keyword()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inline copies the commands of target for the INCLUDES inc, overriding the
+// USING parameters that inc's WITH names. Each copy gets the WITH defaults
+// and error policy that it would have had in target. If target is
+// @deprecated, the copies start with its support.Deprecated command.
+func inline(target *Route, inc *Command) ([]*Command, error) {
+	name := Unquote(target.Name)
+	used := map[*Using]bool{}
+	for _, o := range inc.Overrides {
+		if len(o.Profiles) > len(inc.Profiles) {
+			return nil, fmt.Errorf("INCLUDES %s WITH %s cannot be inside an IF profile", name, Unquote(o.Name))
+		}
+	}
+
+	copies := []*Command{}
+	if target.Annotations.IsDeprecated() {
+		copies = append(copies, &Command{
+			Name: asString("@deprecated"),
+			Cmd: "support.Deprecated(" + target.Name + ", " + strconv.Quote(target.Annotations.Deprecated()) + ")",
+			Profiles: append([]string{}, inc.Profiles...),
+			Inlined: name,
+		})
+	}
+	for _, c := range target.Commands {
+		nc := c.copy()
+		nc.Profiles = mergeProfiles(inc.Profiles, c.Profiles)
+		if nc.Inlined == "" {
+			nc.Inlined = name
+			addDefaults(nc, target.Defaults)
+			if !nc.IsIncludes() && !nc.IsFlow() && !nc.HasErrorPolicy() {
+				nc.ErrorPolicy = target.ErrorPolicy
+			}
+		}
+		overrideParams(nc, inc.Overrides, used)
+		for _, step := range nc.Steps {
+			overrideParams(step, inc.Overrides, used)
+		}
+		copies = append(copies, nc)
+	}
+
+	for _, o := range inc.Overrides {
+		if !used[o] {
+			return nil, fmt.Errorf("INCLUDES %s WITH %s: no DOES in %s uses %s", name, Unquote(o.Name), name, Unquote(o.Name))
+		}
+	}
+	return copies, nil
+}
+
+// overrideParams replaces the default value and sources of each of c's
+// parameters that one of the overrides names.
+func overrideParams(c *Command, overrides []*Using, used map[*Using]bool) {
+	for _, o := range overrides {
+		for _, u := range c.Params {
+			if u.Name == o.Name {
+				u.DefaultVal = o.DefaultVal
+				u.From = append([]string{}, o.From...)
+				used[o] = true
+			}
+		}
+	}
+}
+
+// mergeProfiles returns the profiles in outer followed by those in inner
+// that are not already in outer.
+func mergeProfiles(outer, inner []string) []string {
+	p := append([]string{}, outer...)
+	seen := map[string]bool{}
+	for _, name := range outer {
+		seen[name] = true
+	}
+	for _, name := range inner {
+		if !seen[name] {
+			p = append(p, name)
+		}
+	}
+	return p
+}
+
+// hasCommand reports whether one of cmds is named name.
+func hasCommand(cmds []*Command, name string) bool {
+	for _, c := range cmds {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
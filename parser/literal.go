@@ -0,0 +1,277 @@
+package parser
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Typed literals
+//
+// Where a bare word is used as a default value, it may be turned into a Go
+// literal of a more specific type than string:
+//
+// 	true, false          bool
+// 	42, -7, 0x1F         int
+// 	3.14, 1e6            float64
+// 	10s, 1m30s, 250ms    time.Duration
+// 	[a, b, c]            a slice
+// 	{a: 1, b: 2}         a map with string keys
+//
+// A word that does not match one of these exactly is a string. Quoted
+// strings are always strings.
+
+var (
+	intRe = regexp.MustCompile(`^[-+]?(0|[1-9][0-9_]*|0[xX][0-9a-fA-F_]+|0[oO]?[0-7_]+|0[bB][01_]+)$`)
+	floatRe = regexp.MustCompile(`^[-+]?(([0-9]+\.[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?|[0-9]+[eE][-+]?[0-9]+)$`)
+	durationRe = regexp.MustCompile(`^[-+]?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`)
+	durationPartRe = regexp.MustCompile(`([0-9]+(?:\.[0-9]+)?)(ns|us|µs|ms|s|m|h)`)
+)
+
+var durationUnits = map[string]string{
+	"ns": "time.Nanosecond",
+	"us": "time.Microsecond",
+	"µs": "time.Microsecond",
+	"ms": "time.Millisecond",
+	"s": "time.Second",
+	"m": "time.Minute",
+	"h": "time.Hour",
+}
+
+var durationUnitValues = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"µs": time.Microsecond,
+	"ms": time.Millisecond,
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// Go types of typed literals.
+const (
+	typeString = "string"
+	typeBool = "bool"
+	typeInt = "int"
+	typeFloat = "float64"
+	typeDuration = "time.Duration"
+)
+
+// typedLiteral converts a bare word into Go code.
+//
+// If the word is not a typed literal, code is empty and the word should be
+// treated as a string. usesTime reports whether the code refers to the time
+// package.
+func typedLiteral(word string) (code string, usesTime bool, err error) {
+	switch {
+	case strings.HasPrefix(word, "["):
+		return listLiteral(word)
+	case strings.HasPrefix(word, "{"):
+		return mapLiteral(word)
+	}
+	code, typ, err := scalar(word)
+	return code, typ == typeDuration, err
+}
+
+// scalar converts a single bare word into Go code and returns its type. If it
+// is not a bool, number or duration, code is empty and the type is string.
+func scalar(word string) (code, typ string, err error) {
+	switch {
+	case word == "true" || word == "false":
+		return word, typeBool, nil
+	case intRe.MatchString(word):
+		if _, err := strconv.ParseInt(word, 0, 64); err == nil {
+			return strings.TrimPrefix(word, "+"), typeInt, nil
+		}
+	case floatRe.MatchString(word):
+		if _, err := strconv.ParseFloat(word, 64); err == nil {
+			return strings.TrimPrefix(word, "+"), typeFloat, nil
+		}
+	case durationRe.MatchString(word):
+		if _, err := time.ParseDuration(word); err == nil {
+			if !wholeNanoseconds(word) {
+				return "", typeString, fmt.Errorf("Duration %s is not a whole number of nanoseconds", word)
+			}
+			return durationCode(word), typeDuration, nil
+		}
+	}
+	return "", typeString, nil
+}
+
+// wholeNanoseconds reports whether each part of a duration is a whole number
+// of nanoseconds. Go rejects a constant such as 1.5*time.Nanosecond.
+func wholeNanoseconds(word string) bool {
+	for _, m := range durationPartRe.FindAllStringSubmatch(word, -1) {
+		n, _ := new(big.Rat).SetString(m[1])
+		n.Mul(n, new(big.Rat).SetInt64(int64(durationUnitValues[m[2]])))
+		if !n.IsInt() {
+			return false
+		}
+	}
+	return true
+}
+
+// durationCode turns "1m30s" into "1*time.Minute + 30*time.Second".
+func durationCode(word string) string {
+	neg := strings.HasPrefix(word, "-")
+	parts := []string{}
+	for _, m := range durationPartRe.FindAllStringSubmatch(word, -1) {
+		parts = append(parts, m[1] + "*" + durationUnits[m[2]])
+	}
+	code := strings.Join(parts, " + ")
+	if neg {
+		if len(parts) > 1 {
+			return "-(" + code + ")"
+		}
+		return "-" + code
+	}
+	return code
+}
+
+// listLiteral converts "[a, b]" into a Go slice literal.
+func listLiteral(word string) (string, bool, error) {
+	if !strings.HasSuffix(word, "]") {
+		return "", false, fmt.Errorf("List is missing a closing ']': %s", word)
+	}
+	items, err := splitItems(word[1:len(word)-1])
+	if err != nil {
+		return "", false, fmt.Errorf("%s in list %s", err, word)
+	}
+
+	codes, typ, err := elements(items)
+	if err != nil {
+		return "", false, fmt.Errorf("%s in list %s", err, word)
+	}
+	return fmt.Sprintf("[]%s{%s}", typ, strings.Join(codes, ", ")), typ == typeDuration, nil
+}
+
+// mapLiteral converts "{a: 1, b: 2}" into a Go map literal with string keys.
+func mapLiteral(word string) (string, bool, error) {
+	if !strings.HasSuffix(word, "}") {
+		return "", false, fmt.Errorf("Map is missing a closing '}': %s", word)
+	}
+	items, err := splitItems(word[1:len(word)-1])
+	if err != nil {
+		return "", false, fmt.Errorf("%s in map %s", err, word)
+	}
+
+	keys := make([]string, len(items))
+	values := make([]string, len(items))
+	for i, item := range items {
+		kv, err := splitOn(item, ':')
+		if err != nil || len(kv) != 2 {
+			return "", false, fmt.Errorf("Expected 'key: value', got %q in map %s", item, word)
+		}
+		key := strings.TrimSpace(kv[0])
+		if len(key) == 0 {
+			return "", false, fmt.Errorf("Empty key in map %s", word)
+		}
+		keys[i] = strconv.Quote(unquoteItem(key))
+		values[i] = strings.TrimSpace(kv[1])
+	}
+
+	codes, typ, err := elements(values)
+	if err != nil {
+		return "", false, fmt.Errorf("%s in map %s", err, word)
+	}
+	pairs := make([]string, len(codes))
+	for i, code := range codes {
+		pairs[i] = keys[i] + ": " + code
+	}
+	return fmt.Sprintf("map[string]%s{%s}", typ, strings.Join(pairs, ", ")), typ == typeDuration, nil
+}
+
+// elements converts the items of a list or map into Go code of one type.
+//
+// If every item is a bare word of the same type, that is the type. Ints and
+// floats together are float64. Anything else makes every item a string.
+func elements(items []string) ([]string, string, error) {
+	codes := make([]string, len(items))
+	typ := ""
+	for i, item := range items {
+		code, t := "", typeString
+		if !isQuoted(item) {
+			var err error
+			if code, t, err = scalar(item); err != nil {
+				return nil, "", err
+			}
+		}
+		codes[i] = code
+		switch {
+		case typ == "":
+			typ = t
+		case typ == t:
+		case (typ == typeInt && t == typeFloat) || (typ == typeFloat && t == typeInt):
+			typ = typeFloat
+		default:
+			typ = typeString
+		}
+	}
+
+	if typ == "" || typ == typeString {
+		for i, item := range items {
+			codes[i] = strconv.Quote(unquoteItem(item))
+		}
+		return codes, typeString, nil
+	}
+	return codes, typ, nil
+}
+
+// splitItems splits the inside of a list or map on commas, trimming space.
+// An empty list has no items.
+func splitItems(body string) ([]string, error) {
+	if strings.TrimSpace(body) == "" {
+		return []string{}, nil
+	}
+	parts, err := splitOn(body, ',')
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+		if parts[i] == "" {
+			return nil, fmt.Errorf("Empty item")
+		}
+	}
+	return parts, nil
+}
+
+// splitOn splits on a separator that is not inside of quotes.
+func splitOn(str string, sep rune) ([]string, error) {
+	parts := []string{}
+	var quote rune
+	start := 0
+	for i, r := range str {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[' || r == '{':
+			return nil, fmt.Errorf("Nested lists and maps are not supported")
+		case r == sep:
+			parts = append(parts, str[start:i])
+			start = i + len(string(r))
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated quote")
+	}
+	return append(parts, str[start:]), nil
+}
+
+func isQuoted(item string) bool {
+	return len(item) >= 2 && (item[0] == '"' || item[0] == '\'') && item[len(item)-1] == item[0]
+}
+
+func unquoteItem(item string) string {
+	if isQuoted(item) {
+		return item[1 : len(item)-1]
+	}
+	return item
+}
@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestTypedLiteral(t *testing.T) {
+	expects := map[string]string{
+		"true": "true",
+		"false": "false",
+		"42": "42",
+		"-7": "-7",
+		"+7": "7",
+		"0x1F": "0x1F",
+		"1_000": "1_000",
+		"3.14": "3.14",
+		"1e6": "1e6",
+		".5": ".5",
+		"10s": "10*time.Second",
+		"250ms": "250*time.Millisecond",
+		"1m30s": "1*time.Minute + 30*time.Second",
+		"1.5h": "1.5*time.Hour",
+		"-2m5s": "-(2*time.Minute + 5*time.Second)",
+		"[a, b]": `[]string{"a", "b"}`,
+		"[1, 2, 3]": "[]int{1, 2, 3}",
+		"[1, 2.5]": "[]float64{1, 2.5}",
+		"[1, a]": `[]string{"1", "a"}`,
+		`[1, "2"]`: `[]string{"1", "2"}`,
+		`["a, b", 'c']`: `[]string{"a, b", "c"}`,
+		"[1s, 2s]": "[]time.Duration{1*time.Second, 2*time.Second}",
+		"[]": "[]string{}",
+		"{a: 1, 'b c': 2}": `map[string]int{"a": 1, "b c": 2}`,
+		"{a: x, b: true}": `map[string]string{"a": "x", "b": "true"}`,
+
+		// These stay strings.
+		"True": "",
+		"yes": "",
+		"08": "",
+		"1.2.3": "",
+		"10 s": "",
+		"10sec": "",
+		"99999999999999999999": "",
+		"1e400": "",
+		"-1.5e400": "",
+		"[1, 1e400]": `[]string{"1", "1e400"}`,
+		"Inf": "",
+		"/a/path": "",
+	}
+
+	for word, expect := range expects {
+		code, _, err := typedLiteral(word)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", word, err)
+		}
+		if code != expect {
+			t.Errorf("Expected %s to be %q, got %q", word, expect, code)
+		}
+	}
+}
+
+func TestTypedLiteralErrors(t *testing.T) {
+	for _, word := range []string{"[a,, b]", "[[a]]", `["a]`, "{a}", "{: 1}", "[a]b", "1.5ns", "1.0000000001s", "[1s, 2.5ns]"} {
+		if _, _, err := typedLiteral(word); err == nil {
+			t.Errorf("Expected error for %s", word)
+		}
+	}
+}
+
+func TestParseTypedDefaults(t *testing.T) {
+	doc := `
+IMPORT github.com/Masterminds/codl/cmd
+ROUTE watch "Watch"
+	DOES cmd.Repeat repeat
+		USING period 10s
+		USING route "10s"
+		USING times 3 FROM cxt:times
+		USING names [a, b]
+		USING flag true`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	handy := h.(*handler)
+
+	expects := []string{"10*time.Second", "`10s`", "3", `[]string{"a", "b"}`, "true"}
+	for i, expect := range expects {
+		if v := handy.routes[0].Commands[0].Params[i].DefaultVal; v != expect {
+			t.Errorf("Expected default %s, got %s", expect, v)
+		}
+	}
+	if handy.routes[0].Commands[0].Params[2].From[0] != "`cxt:times`" {
+		t.Errorf("Expected FROM to be unaffected")
+	}
+
+	if len(handy.imports) != 2 || handy.imports[1] != "`time`" {
+		t.Errorf("Expected time to be imported, got %v", handy.imports)
+	}
+
+	// Typed literals are only defaults. Names stay strings.
+	h, err = Parse(v2(`ROUTE 10s true DOES «foo» 42 USING 1 2`))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	r := h.(*handler).routes[0]
+	if r.Name != "`10s`" || r.Description != "`true`" || r.Commands[0].Name != "`42`" || r.Commands[0].Params[0].Name != "`1`" {
+		t.Errorf("Expected names to stay strings")
+	}
+	if r.Commands[0].Params[0].DefaultVal != "2" {
+		t.Errorf("Expected default to be typed, got %s", r.Commands[0].Params[0].DefaultVal)
+	}
+}
@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Loop is a FOREACH on a DOES, which runs the command once for each element
+// of a slice.
+type Loop struct {
+	// Var is the name of the parameter that each element is passed as.
+	Var string
+	// Source is where the slice comes from, such as "cxt:files".
+	Source string
+	// Parallel is how many elements may run at once. 0 runs them one at a
+	// time, in order.
+	Parallel int
+}
+
+// loopClause is a FOREACH, or the PARALLEL after it, that is still being
+// read.
+type loopClause struct {
+	loop *Loop
+	// The word expected next: "var", "IN", "source" or "n".
+	expect string
+}
+
+func (c *loopClause) keyword() string {
+	if c.expect == "n" {
+		return "PARALLEL"
+	}
+	return "FOREACH"
+}
+
+// ForEach starts a FOREACH on the current DOES. It is followed by a name,
+// IN, and a source.
+func (l *handler) ForEach() {
+	if !l.unannotated("FOREACH") {
+		return
+	}
+	c := l.currentDoes()
+	if c == nil {
+		l.err = fmt.Errorf("FOREACH must come after a DOES")
+		return
+	}
+	if c.ForEach != nil {
+		l.err = fmt.Errorf("DOES %s can only have one FOREACH", c.Name)
+		return
+	}
+	c.ForEach = &Loop{}
+	l.loop = &loopClause{ loop: c.ForEach, expect: "var" }
+}
+
+// Parallel either sets how many elements of the current DOES's FOREACH may
+// run at once, or starts a PARALLEL block.
+//
+// Right after a FOREACH that does not have one yet, a number is the FOREACH's
+// bound. Anything else starts a block.
+func (l *handler) Parallel() {
+	if !l.unannotated("PARALLEL") {
+		return
+	}
+	if c := l.currentDoes(); c != nil && c.ForEach != nil && c.ForEach.Parallel == 0 {
+		l.loop = &loopClause{ loop: c.ForEach, expect: "n" }
+		return
+	}
+	l.startGroup()
+}
+
+// loopWord handles a string in a FOREACH or PARALLEL.
+func (l *handler) loopWord(word string) {
+	c := l.loop
+	switch c.expect {
+	case "var":
+		c.loop.Var = asString(word)
+		c.expect = "IN"
+	case "IN":
+		if word != "IN" {
+			l.err = fmt.Errorf("FOREACH %s must be followed by IN, not %s", Unquote(c.loop.Var), word)
+			return
+		}
+		c.expect = "source"
+	case "source":
+		if !strings.Contains(word, ":") {
+			l.err = fmt.Errorf("FOREACH %s IN takes a source such as cxt:name, not %s", Unquote(c.loop.Var), word)
+			return
+		}
+		c.loop.Source = asString(word)
+		l.loop = nil
+	case "n":
+		n, err := strconv.Atoi(word)
+		if err != nil {
+			// Not a bound, so this is a named PARALLEL block.
+			l.loop = nil
+			l.startGroup()
+			l.groupWord(word)
+			return
+		}
+		if n < 1 {
+			l.err = fmt.Errorf("PARALLEL takes a number greater than 0, not %s", word)
+			return
+		}
+		c.loop.Parallel = n
+		l.loop = nil
+	}
+}
+
+// loopDone reports whether a FOREACH or PARALLEL has all of its parts. If
+// not, that is an error.
+func (l *handler) loopDone() bool {
+	c := l.loop
+	if c == nil {
+		return true
+	}
+	switch c.expect {
+	case "var":
+		l.err = fmt.Errorf("FOREACH requires a name")
+	case "IN":
+		l.err = fmt.Errorf("FOREACH %s must be followed by IN", Unquote(c.loop.Var))
+	case "source":
+		l.err = fmt.Errorf("FOREACH %s IN requires a source such as cxt:name", Unquote(c.loop.Var))
+	case "n":
+		l.err = fmt.Errorf("PARALLEL requires a number")
+	}
+	return false
+}
@@ -0,0 +1,323 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// reserved are the words that CODL 2 reads as keywords, alone or when a
+// comment follows them directly, as in "IMPORT//". CODL 1 reads all but its
+// own keywords as bare words, and "IMPORT//" as a bare word too.
+var reserved = func() []string {
+	words := []string{"CODL",
+		"I" + mport, "I" + nclude, "I" + iF, "I" + gnore,
+		"R" + oute, "R" + eroute, "R" + etry,
+		"U" + sing, "U" + se, "U" + nless,
+		"D" + oes, "D" + efault, "D" + atasource,
+		"F" + rom, "F" + oreach, "F" + lags,
+		"E" + nd, "E" + xpect,
+		"B" + efore, "B" + ackoff,
+		"A" + fter, "A" + pp,
+		"W" + ith, "P" + arallel,
+		"T" + imeout, "T" + est,
+		"S" + top, "S" + ubcommand,
+		"O" + n, "G" + gO, "G" + iven,
+		"C" + ontext, "M" + ock, "N" + amespace,
+	}
+	for _, name := range annotations {
+		words = append(words, "@" + name)
+	}
+	return words
+}()
+
+// keywords1 are the keywords of CODL 1.
+var keywords1 = []string{"I" + mport, "I" + nclude, "R" + oute, "U" + sing, "D" + oes, "F" + rom}
+
+// Migrate rewrites CODL 1 source as CODL 2. The result reads the same, and
+// so generates the same Go code.
+//
+// It adds a CODL 2 pragma, or replaces a CODL 1 pragma. Quoted strings with
+// backslashes are escaped again, and bare words that CODL 2 would read
+// differently are quoted: keywords, annotations, lists, maps, typed literals
+// and the methods of HTTP routes. Everything else, including spaces and comments, is
+// copied as it is. Source that is already CODL 2 is returned unchanged.
+func Migrate(src string) (string, error) {
+	m := &migration{src: src}
+	if err := m.run(); err != nil {
+		return "", err
+	}
+	if m.version >= Version2 {
+		return src, nil
+	}
+	return m.out.String(), nil
+}
+
+// MigrateFile migrates a CODL 1 file, and checks that both versions generate
+// the same Go code for every profile. It returns the new source. The file
+// is not changed.
+func MigrateFile(filename string) (string, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	before, err := parseFile(filename, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	out, err := Migrate(string(src))
+	if err != nil {
+		return "", err
+	}
+
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	after := newHandler()
+	after.filename = filename
+	after.chain = []string{abs}
+	if _, err := after.parse(strings.NewReader(out)); err != nil {
+		return "", fmt.Errorf("The migrated file does not parse: %s", err)
+	}
+
+	for _, profile := range append([]string{""}, Profiles(before)...) {
+		expect, err := generate(before, profile)
+		if err != nil {
+			return "", err
+		}
+		got, err := generate(after, profile)
+		if err != nil {
+			return "", err
+		}
+		if line, ok := firstDiff(expect, got); !ok {
+			return "", fmt.Errorf("The migrated file generates different Go code for profile %q: %s", profile, line)
+		}
+	}
+	return out, nil
+}
+
+// generate writes the Go code, and any tests, for one profile of a registry.
+func generate(reg Registry, profile string) (string, error) {
+	pkg := "codl"
+	if app := reg.Application(); app != nil && app.Main {
+		pkg = "main"
+	}
+	reg = ForProfile(reg, profile)
+
+	var out bytes.Buffer
+	if err := NewSerializer("codl", pkg, &out, reg).Write(); err != nil {
+		return "", err
+	}
+	if len(reg.Tests()) > 0 {
+		if err := NewSerializer("codl", pkg, &out, reg).WriteTests(); err != nil {
+			return "", err
+		}
+	}
+	return out.String(), nil
+}
+
+// firstDiff returns the first line of got that differs from expect. It
+// reports false if there is one.
+func firstDiff(expect, got string) (string, bool) {
+	a := strings.Split(expect, "\n")
+	b := strings.Split(got, "\n")
+	for i := range b {
+		if i >= len(a) || a[i] != b[i] {
+			return fmt.Sprintf("line %d: %s", i + 1, strings.TrimSpace(b[i])), false
+		}
+	}
+	if len(a) != len(b) {
+		return fmt.Sprintf("line %d is missing: %s", len(b) + 1, strings.TrimSpace(a[len(b)])), false
+	}
+	return "", true
+}
+
+// migration reads CODL 1 source the way the Tokenizer does, and writes each
+// token as CODL 2.
+type migration struct {
+	src string
+	pos int
+	out bytes.Buffer
+	tokens int
+	// version is the version in the source's pragma, if it has one.
+	version int
+	// route is true when the last token was ROUTE.
+	route bool
+}
+
+func (m *migration) run() error {
+	for m.pos < len(m.src) {
+		if m.space() || m.comment() {
+			continue
+		}
+		m.tokens++
+		r, size := utf8.DecodeRuneInString(m.src[m.pos:])
+		if m.tokens == 1 {
+			if m.peekWord() == "CODL" {
+				if err := m.pragma(); err != nil || m.version >= Version2 {
+					return err
+				}
+				continue
+			}
+			m.out.WriteString("CODL 2\n\n")
+		}
+
+		route := m.route
+		m.route = false
+		switch r {
+		case '`':
+			m.through(m.pos + size, "`")
+		case '«':
+			m.through(m.pos + size, "»")
+		case '"', '\'':
+			m.quoted(r)
+		default:
+			m.word(route)
+		}
+	}
+	if m.tokens == 0 {
+		m.out.WriteString("CODL 2\n")
+	}
+	return nil
+}
+
+// space copies any spaces, and reports whether there were some.
+func (m *migration) space() bool {
+	start := m.pos
+	for m.pos < len(m.src) {
+		r, size := utf8.DecodeRuneInString(m.src[m.pos:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		m.pos += size
+	}
+	m.out.WriteString(m.src[start:m.pos])
+	return m.pos > start
+}
+
+// comment copies a comment, up to and including the end of the line.
+func (m *migration) comment() bool {
+	if !strings.HasPrefix(m.src[m.pos:], "//") {
+		return false
+	}
+	m.through(m.pos, "\n")
+	return true
+}
+
+// through copies up to and including the delimiter, searching from i. An
+// unterminated token runs to the end of the source.
+func (m *migration) through(i int, delim string) {
+	end := len(m.src)
+	if j := strings.Index(m.src[i:], delim); j >= 0 {
+		end = i + j + len(delim)
+	}
+	m.out.WriteString(m.src[m.pos:end])
+	m.pos = end
+}
+
+// peekWord returns the word at the current position, without reading it.
+func (m *migration) peekWord() string {
+	end := strings.IndexFunc(m.src[m.pos:], unicode.IsSpace)
+	if end < 0 {
+		return m.src[m.pos:]
+	}
+	return m.src[m.pos:m.pos + end]
+}
+
+// pragma replaces a CODL 1 pragma. A CODL 2 pragma means that there is
+// nothing to migrate.
+func (m *migration) pragma() error {
+	m.out.WriteString("CODL")
+	m.pos += len("CODL")
+	m.space()
+	v := m.peekWord()
+	switch v {
+	case "1":
+		m.version = Version1
+		m.out.WriteString("2")
+	case "2":
+		m.version = Version2
+	default:
+		return fmt.Errorf("Unknown version CODL %s. Use CODL 1 or CODL 2", v)
+	}
+	m.pos += len(v)
+	return nil
+}
+
+// quoted reads a string as CODL 1 does, dropping each backslash. If there
+// were any, the string is escaped for CODL 2.
+func (m *migration) quoted(delim rune) {
+	var b bytes.Buffer
+	skipNext := false
+	for i, r := range m.src[m.pos + 1:] {
+		if r == delim && !skipNext {
+			end := m.pos + 1 + i + 1
+			if raw := m.src[m.pos:end]; strings.Contains(raw, `\`) {
+				m.out.WriteString(requote(b.String(), delim))
+			} else {
+				m.out.WriteString(raw)
+			}
+			m.pos = end
+			return
+		} else if r == '\\' {
+			skipNext = true
+		} else {
+			skipNext = false
+			b.WriteRune(r)
+		}
+	}
+	m.out.WriteString(m.src[m.pos:])
+	m.pos = len(m.src)
+}
+
+// word copies a bare word or keyword. A bare word that CODL 2 would read
+// differently is quoted. route is true for the first word of a ROUTE.
+func (m *migration) word(route bool) {
+	w := m.peekWord()
+	m.pos += len(w)
+	m.route = w == "R" + oute
+	if changes(w, route) {
+		m.out.WriteString(requote(w, '"'))
+		return
+	}
+	m.out.WriteString(w)
+}
+
+// changes reports whether CODL 2 reads the bare word w differently from
+// CODL 1.
+func changes(w string, route bool) bool {
+	for _, k := range reserved {
+		if strings.HasPrefix(w, k + "//") {
+			return true
+		}
+	}
+	for _, k := range keywords1 {
+		if w == k {
+			return false
+		}
+	}
+	for _, k := range reserved {
+		if w == k {
+			return true
+		}
+	}
+	if strings.HasPrefix(w, "[") || strings.HasPrefix(w, "{") {
+		return true
+	}
+	if code, _, err := typedLiteral(w); code != "" || err != nil {
+		return true
+	}
+	return route && methodRe.MatchString(w)
+}
+
+// requote quotes a string for CODL 2.
+func requote(str string, delim rune) string {
+	str = strings.Replace(str, `\`, `\\`, -1)
+	str = strings.Replace(str, string(delim), `\` + string(delim), -1)
+	return string(delim) + str + string(delim)
+}
@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	expects := map[string]string{
+		"": "CODL 2\n",
+		"IMPORT foo": "CODL 2\n\nIMPORT foo",
+		"// Routes.\nROUTE a": "// Routes.\nCODL 2\n\nROUTE a",
+		"CODL 1\nROUTE a": "CODL 2\nROUTE a",
+		"CODL 2\nROUTE a \"\\q\"": "CODL 2\nROUTE a \"\\q\"",
+		`ROUTE a "It\'s"`: "CODL 2\n\nROUTE a \"It's\"",
+		`ROUTE a 'It\'s'`: "CODL 2\n\nROUTE a 'It\\'s'",
+		`ROUTE a "C:\\data"`: "CODL 2\n\nROUTE a \"C:data\"",
+		`ROUTE a "\"hi\""`: "CODL 2\n\nROUTE a \"\\\"hi\\\"\"",
+		`ROUTE a "no escapes"`: "CODL 2\n\nROUTE a \"no escapes\"",
+		"ROUTE a «\\n» `\\n`": "CODL 2\n\nROUTE a «\\n» `\\n`",
+		`ROUTE a [b, "c\d"]x`: "CODL 2\n\nROUTE a \"[b,\" \"cd\"]x",
+		"ROUTE a b USING n 42": "CODL 2\n\nROUTE a b USING n \"42\"",
+		"ROUTE a b USING m {a:1}": "CODL 2\n\nROUTE a b USING m \"{a:1}\"",
+		"ROUTE a b USING n 1e400": "CODL 2\n\nROUTE a b USING n 1e400",
+		"ROUTE a b USING n 1.5e4": "CODL 2\n\nROUTE a b USING n \"1.5e4\"",
+		"ROUTE a b DOES «c» END": "CODL 2\n\nROUTE a b DOES «c» \"END\"",
+		"ROUTE IF b USING STOP x": "CODL 2\n\nROUTE \"IF\" b USING \"STOP\" x",
+		"ROUTE a @hidden": "CODL 2\n\nROUTE a \"@hidden\"",
+		"ROUTE GET \"/x\"": "CODL 2\n\nROUTE \"GET\" \"/x\"",
+		"ROUTE a GET": "CODL 2\n\nROUTE a GET",
+		"ROUTE a CODL": "CODL 2\n\nROUTE a \"CODL\"",
+		"ROUTE a IMPORT//x": "CODL 2\n\nROUTE a \"IMPORT//x\"",
+		"ROUTE a @tag//x": "CODL 2\n\nROUTE a \"@tag//x\"",
+		"ROUTE a IMPORTS//x": "CODL 2\n\nROUTE a IMPORTS//x",
+		"ROUTE a http://x//y // IMPORT//x": "CODL 2\n\nROUTE a http://x//y // IMPORT//x",
+	}
+
+	for src, expect := range expects {
+		got, err := Migrate(src)
+		if err != nil {
+			t.Errorf("Unexpected error migrating %q: %s", src, err)
+			continue
+		}
+		if got != expect {
+			t.Errorf("Expected %q from %q, got %q", expect, src, got)
+		}
+	}
+
+	if _, err := Migrate("CODL 3\nROUTE a"); err == nil {
+		t.Errorf("Expected an error for CODL 3")
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	got, err := MigrateFile("testdata/migrate/app.codl")
+	if err != nil {
+		t.Fatalf("Failed to migrate: %s", err)
+	}
+
+	golden := "testdata/migrate/app.v2.codl"
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatalf("Failed to update %s: %s", golden, err)
+		}
+		return
+	}
+	expect, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", golden, err)
+	}
+	if got != string(expect) {
+		t.Errorf("The migrated file does not match %s. Got:\n%s", golden, got)
+	}
+
+	// A migrated file has nothing more to migrate.
+	again, err := MigrateFile(golden)
+	if err != nil {
+		t.Fatalf("Failed to migrate %s: %s", golden, err)
+	}
+	if again != got {
+		t.Errorf("Expected %s to be unchanged, got:\n%s", golden, again)
+	}
+}
@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"fmt"
+)
+
+// ErrorPolicy says what happens when a command fails. It is set with
+// ON ERROR REROUTE or IGNORE ERRORS, on a DOES or on a whole ROUTE.
+type ErrorPolicy struct {
+	// OnError is the route to reroute to when the command fails, if any.
+	OnError string
+	// IgnoreErrors is true if a failure is logged and otherwise ignored.
+	IgnoreErrors bool
+}
+
+// HasErrorPolicy reports whether ON ERROR or IGNORE ERRORS was set.
+func (p *ErrorPolicy) HasErrorPolicy() bool {
+	return p.OnError != "" || p.IgnoreErrors
+}
+
+// errorClause is an ON ERROR REROUTE or IGNORE ERRORS clause that is still
+// being read.
+type errorClause struct {
+	// The words read so far, and the word expected next. After REROUTE, the
+	// next word is the route name, and expect is "".
+	said, expect string
+	policy *ErrorPolicy
+}
+
+// On starts an ON ERROR REROUTE clause.
+func (l *handler) On() {
+	l.startClause("ON", "ERROR")
+}
+
+// Ignore starts an IGNORE ERRORS clause.
+func (l *handler) Ignore() {
+	l.startClause("IGNORE", "ERRORS")
+}
+
+func (l *handler) startClause(keyword, expect string) {
+	if !l.unannotated(keyword) {
+		return
+	}
+	p, what := l.errorPolicy()
+	if p == nil {
+		l.err = fmt.Errorf("%s %s must come after a DOES, or after a ROUTE and before its first DOES", keyword, expect)
+		return
+	}
+	if p.HasErrorPolicy() {
+		l.err = fmt.Errorf("A %s can only have one ON ERROR or IGNORE ERRORS", what)
+		return
+	}
+	l.clause = &errorClause{ said: keyword, expect: expect, policy: p }
+}
+
+// errorPolicy returns the policy that an ON ERROR or IGNORE ERRORS applies
+// to here, and whether it belongs to a DOES or a ROUTE.
+func (l *handler) errorPolicy() (*ErrorPolicy, string) {
+	r := l.currentRoute
+	if r == nil {
+		return nil, ""
+	}
+	switch l.mode {
+	case DoesMode, UsingMode, FromMode:
+		if l.withParam == nil {
+			return &r.currentCommand.ErrorPolicy, "DOES"
+		}
+	}
+	switch l.mode {
+	case RouteMode, WithMode, FromMode:
+		if len(r.Commands) == 0 {
+			return &r.ErrorPolicy, "ROUTE"
+		}
+	}
+	return nil, ""
+}
+
+// clauseWord handles a string in an ON ERROR or IGNORE ERRORS clause.
+func (l *handler) clauseWord(word string) {
+	c := l.clause
+	if c.expect == "" {
+		c.policy.OnError = asString(word)
+		l.clause = nil
+		return
+	}
+	if word != c.expect {
+		l.err = fmt.Errorf("%s must be followed by %s, not %s", c.said, c.expect, word)
+		return
+	}
+	c.said += " " + word
+	switch word {
+	case "ERROR":
+		c.expect = "REROUTE"
+	case "REROUTE":
+		c.expect = ""
+	case "ERRORS":
+		c.policy.IgnoreErrors = true
+		l.clause = nil
+	}
+}
+
+// clauseDone reports whether no ON ERROR or IGNORE ERRORS clause is waiting
+// for more words. If one is, it is an error.
+func (l *handler) clauseDone() bool {
+	c := l.clause
+	if c == nil {
+		return true
+	}
+	if c.expect == "" {
+		l.err = fmt.Errorf("%s requires a route name", c.said)
+	} else {
+		l.err = fmt.Errorf("%s must be followed by %s", c.said, c.expect)
+	}
+	return false
+}
+
+// applyErrorPolicies gives each DOES in a route the route's ON ERROR or
+// IGNORE ERRORS, unless it has its own. INCLUDES are left alone; the
+// included route's commands follow that route's policy, as do inlined
+// commands. So are REROUTE and STOP, which cannot fail.
+func (l *handler) applyErrorPolicies() {
+	for _, r := range l.routes {
+		if !r.HasErrorPolicy() {
+			continue
+		}
+		for _, c := range r.Commands {
+			if !c.IsIncludes() && !c.IsFlow() && !c.HasErrorPolicy() && c.Inlined == "" {
+				c.ErrorPolicy = r.ErrorPolicy
+			}
+		}
+	}
+}
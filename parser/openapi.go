@@ -0,0 +1,246 @@
+package parser
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI builds an OpenAPI 3 document from the HTTP routes in a set of
+// parsed CODL files. Other routes, and routes marked @hidden, are left out.
+type OpenAPI struct {
+	Title, Version string
+	regs []Registry
+}
+
+// NewOpenAPI creates an OpenAPI document for the given files.
+func NewOpenAPI(title, version string, regs ...Registry) *OpenAPI {
+	return &OpenAPI{ Title: title, Version: version, regs: regs }
+}
+
+// WriteJSON writes the document as indented JSON.
+func (o *OpenAPI) WriteJSON(out io.Writer) error {
+	data, err := json.MarshalIndent(o.document(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+// WriteYAML writes the document as YAML.
+func (o *OpenAPI) WriteYAML(out io.Writer) error {
+	_, err := io.WriteString(out, strings.Join(yamlLines(o.document()), "\n") + "\n")
+	return err
+}
+
+// document returns the OpenAPI document as maps and lists.
+func (o *OpenAPI) document() map[string]interface{} {
+	routes := map[string]*Route{}
+	for _, reg := range o.regs {
+		for _, r := range reg.Routes() {
+			routes[r.Name] = r
+		}
+	}
+
+	paths := map[string]interface{}{}
+	for _, reg := range o.regs {
+		for _, r := range reg.Routes() {
+			if !r.IsHTTP() || r.Annotations.Hidden() {
+				continue
+			}
+			path := openAPIPath(r.Path)
+			item, ok := paths[path].(map[string]interface{})
+			if !ok {
+				item = map[string]interface{}{}
+				paths[path] = item
+			}
+			item[strings.ToLower(r.Method)] = operation(r, routes)
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title": o.Title,
+			"version": o.Version,
+		},
+		"paths": paths,
+	}
+}
+
+// operation describes one HTTP route.
+func operation(r *Route, routes map[string]*Route) map[string]interface{} {
+	op := map[string]interface{}{
+		"responses": map[string]interface{}{
+			"default": map[string]interface{}{ "description": "Response" },
+		},
+	}
+	if r.Description != "" {
+		op["summary"] = Unquote(r.Description)
+	}
+	if tags := r.Annotations.Tags(); len(tags) > 0 {
+		list := []interface{}{}
+		for _, t := range tags {
+			list = append(list, t)
+		}
+		op["tags"] = list
+	}
+	if r.Annotations.IsDeprecated() {
+		op["deprecated"] = true
+	}
+
+	// Every placeholder is a parameter, whether or not a command reads it.
+	params := []interface{}{}
+	seen := map[string]bool{}
+	add := func(in, name string) {
+		if name == "" || seen[in + ":" + name] {
+			return
+		}
+		seen[in + ":" + name] = true
+		p := map[string]interface{}{
+			"name": name,
+			"in": in,
+			"schema": map[string]interface{}{ "type": "string" },
+		}
+		if in == "path" {
+			p["required"] = true
+		}
+		params = append(params, p)
+	}
+	segs := strings.Split(r.Path, "/")
+	for i := range segs {
+		add("path", segmentName(segs, i))
+	}
+	for _, source := range routeSources(r, routes, map[*Route]bool{}) {
+		i := strings.Index(source, ":")
+		if i < 0 {
+			continue
+		}
+		switch prefix, key := source[:i], source[i+1:]; prefix {
+		case "query", "header":
+			add(prefix, key)
+		case "path":
+			if n, err := strconv.Atoi(key); err == nil && n < len(segs) {
+				add("path", segmentName(segs, n))
+			}
+		}
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	return op
+}
+
+// routeSources returns the sources read by a route's commands, and by the
+// routes it INCLUDES.
+func routeSources(r *Route, routes map[string]*Route, seen map[*Route]bool) []string {
+	if seen[r] {
+		return nil
+	}
+	seen[r] = true
+	s := []string{}
+	for _, c := range r.Commands {
+		if c.IsIncludes() {
+			if inc, ok := routes[c.Name]; ok {
+				s = append(s, routeSources(inc, routes, seen)...)
+			}
+			continue
+		}
+		for _, c := range append([]*Command{c}, c.Steps...) {
+			s = append(s, sources(c)...)
+		}
+	}
+	return s
+}
+
+// segmentName returns the parameter name of the i'th segment of a path: the
+// placeholder's name, "pathN" for a "*", or "" for plain text.
+func segmentName(segs []string, i int) string {
+	if m := placeholderRe.FindStringSubmatch(segs[i]); m != nil {
+		return m[1]
+	}
+	if segs[i] == "*" {
+		return "path" + strconv.Itoa(i)
+	}
+	return ""
+}
+
+// openAPIPath writes a route's path the way OpenAPI does, with each "*" as
+// a "{pathN}" placeholder.
+func openAPIPath(path string) string {
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		if seg == "*" {
+			segs[i] = "{" + segmentName(segs, i) + "}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+var plainKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// yamlLines writes a map or list as YAML block lines. Keys are sorted, as
+// they are in encoding/json, so the output is stable.
+func yamlLines(v interface{}) []string {
+	lines := []string{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			key := k
+			if !plainKeyRe.MatchString(k) {
+				key = strconv.Quote(k)
+			}
+			lines = append(lines, yamlEntry(key + ":", v[k])...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			lines = append(lines, yamlEntry("-", item)...)
+		}
+	}
+	return lines
+}
+
+// yamlEntry writes a value after a key or list dash. A map in a list starts
+// on the dash's line.
+func yamlEntry(head string, v interface{}) []string {
+	var block []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return []string{head + " {}"}
+		}
+		block = yamlLines(v)
+		if head == "-" {
+			lines := []string{"- " + block[0]}
+			for _, line := range block[1:] {
+				lines = append(lines, "  " + line)
+			}
+			return lines
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			return []string{head + " []"}
+		}
+		block = yamlLines(v)
+	case string:
+		return []string{head + " " + strconv.Quote(v)}
+	case bool:
+		return []string{head + " " + strconv.FormatBool(v)}
+	default:
+		return []string{head + " null"}
+	}
+	lines := []string{head}
+	for _, line := range block {
+		lines = append(lines, "  " + line)
+	}
+	return lines
+}
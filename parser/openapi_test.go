@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+const openAPIDoc = `
+ROUTE common.auth "Authenticate"
+	DOES «auth» user
+		USING token FROM header:Authorization
+@tag users
+ROUTE GET "/users/{id}/files/*" "Show a user's file"
+	INCLUDES common.auth
+	DOES «show» show
+		USING id FROM path:id
+		USING file FROM path:4
+		USING format FROM query:format
+@hidden
+ROUTE GET "/secret" "Hidden"
+ROUTE plain "Not HTTP"`
+
+func TestOpenAPIJSON(t *testing.T) {
+	h, err := Parse(v2(openAPIDoc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := NewOpenAPI("Test", "2.0", h.(Registry)).WriteJSON(&out); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+
+	var doc struct {
+		OpenAPI string
+		Info struct{ Title, Version string }
+		Paths map[string]map[string]struct {
+			Summary string
+			Tags []string
+			Parameters []struct {
+				Name, In string
+				Required bool
+			}
+		}
+	}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to read the JSON: %s\n%s", err, out.String())
+	}
+	if doc.OpenAPI != "3.0.3" || doc.Info.Title != "Test" || doc.Info.Version != "2.0" {
+		t.Errorf("Unexpected header: %s %v", doc.OpenAPI, doc.Info)
+	}
+	if len(doc.Paths) != 1 {
+		t.Fatalf("Expected only the visible HTTP route, got %v", doc.Paths)
+	}
+	op, ok := doc.Paths["/users/{id}/files/{path4}"]["get"]
+	if !ok {
+		t.Fatalf("Expected GET /users/{id}/files/{path4}, got %v", doc.Paths)
+	}
+	if op.Summary != "Show a user's file" {
+		t.Errorf("Expected the description as summary, got %q", op.Summary)
+	}
+	if len(op.Tags) != 1 || op.Tags[0] != "users" {
+		t.Errorf("Expected @tag users, got %v", op.Tags)
+	}
+
+	expects := []string{"path:id", "path:path4", "header:Authorization", "query:format"}
+	if len(op.Parameters) != len(expects) {
+		t.Fatalf("Expected %d parameters, got %v", len(expects), op.Parameters)
+	}
+	for i, expect := range expects {
+		p := op.Parameters[i]
+		if p.In + ":" + p.Name != expect || p.Required != (p.In == "path") {
+			t.Errorf("Expected parameter %s, got %v", expect, p)
+		}
+	}
+}
+
+func TestOpenAPIYAML(t *testing.T) {
+	h, err := Parse(v2(`ROUTE POST "/users" "Create a user"`))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := NewOpenAPI("Test", "1.0", h.(Registry)).WriteYAML(&out); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+
+	expect := `info:
+  title: "Test"
+  version: "1.0"
+openapi: "3.0.3"
+paths:
+  "/users":
+    post:
+      responses:
+        default:
+          description: "Response"
+      summary: "Create a user"
+`
+	if out.String() != expect {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expect, out.String())
+	}
+}
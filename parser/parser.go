@@ -3,8 +3,15 @@ package parser
 import (
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 )
 
+var namespaceRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 // Insertion modes
 const (
 	TopMode = iota
@@ -14,23 +21,66 @@ const (
 	UsingMode
 	DoesMode
 	FromMode
+	ClosedMode
+	UseMode
+	WithMode
+	HookMode
+	FlowMode
+	GroupMode
+	ContextMode
+	DefaultMode
+	DatasourceMode
+	AppMode
+	FlagsMode
+	GoMode
+	TestMode
 )
 
 const (
 	cmdCommand = iota
 	cmdInclude
 	cmdDoes
+	cmdReroute
+	cmdStop
+	cmdParallel
 )
 
 type Using struct {
 	From []string
 	Name, DefaultVal string
+	Profiles []string
 }
 
 type Command struct {
 	cmdType int
 	Name, Cmd string
+	// Namespace is the NAMESPACE the command was declared in, if any. Route
+	// names in INCLUDES, REROUTE and ON ERROR are looked up there first.
+	Namespace string
 	Params []*Using
+	Profiles []string
+	Annotations Annotations
+	ErrorPolicy
+	// Target is the route that a REROUTE goes to.
+	Target string
+	// Condition is the source that an IF or UNLESS on a DOES tests, such as
+	// "cxt:h". Unless is true for UNLESS.
+	Condition string
+	Unless bool
+	// ForEach is the DOES's FOREACH loop, if any.
+	ForEach *Loop
+	// Steps are the DOES commands in a PARALLEL block.
+	Steps []*Command
+	// Timeout and Backoff are Go code for a time.Duration, or empty. Retries
+	// is how many times to retry after a recoverable error.
+	Timeout, Backoff string
+	Retries int
+	// Overrides are the WITH parameters of an INCLUDES. An INCLUDES that
+	// has them is replaced by the included route's commands.
+	Overrides []*Using
+	// Inlined is the name of the route a command was copied from by an
+	// INCLUDES with WITH, if any.
+	Inlined string
 	currentParam *Using
 }
 
@@ -38,37 +88,270 @@ func (c *Command) IsIncludes() bool {
 	return c.cmdType == cmdInclude
 }
 
+// copy makes a copy of a command and its parameters.
+func (c *Command) copy() *Command {
+	nc := *c
+	nc.Params = make([]*Using, len(c.Params))
+	for i, u := range c.Params {
+		nu := *u
+		nu.From = append([]string{}, u.From...)
+		nc.Params[i] = &nu
+	}
+	nc.Overrides = append([]*Using{}, c.Overrides...)
+	if c.ForEach != nil {
+		loop := *c.ForEach
+		nc.ForEach = &loop
+	}
+	if c.Steps != nil {
+		nc.Steps = make([]*Command, len(c.Steps))
+		for i, step := range c.Steps {
+			nc.Steps[i] = step.copy()
+		}
+	}
+	nc.currentParam = nil
+	return &nc
+}
+
 type Route struct {
 	Name, Description string
+	// Namespace is the NAMESPACE the route was declared in, if any. Name
+	// already includes it.
+	Namespace string
 	Commands []*Command
+	// Defaults are the route's WITH parameters. After parsing, they have
+	// also been added to every DOES that does not set them itself.
+	Defaults []*Using
+	Profiles []string
+	Annotations Annotations
+	// ErrorPolicy is the route's ON ERROR or IGNORE ERRORS. After parsing,
+	// it has also been given to every DOES that does not set its own.
+	ErrorPolicy
+	// Method and Path are set for an HTTP route, declared as
+	// ROUTE GET "/users/{id}". PathParams are the names of the path's
+	// placeholders.
+	Method, Path string
+	PathParams []string
 	currentCommand *Command
+	// Subcommand is true for a SUBCOMMAND, and Flags is the name of the
+	// FLAGS block it parses, if it is not the subcommand's own name.
+	Subcommand bool
+	Flags string
+	// firstWord is the route's first word as written, if it is a bare word.
+	// It is the method if a path follows it.
+	firstWord string
+	// expanded is true once a SUBCOMMAND has its flag parsing commands.
+	expanded bool
+	// inlined are the INCLUDES that were replaced with copies of their
+	// routes' commands.
+	inlined []*Command
+}
+
+// block is an open IF, NAMESPACE or PARALLEL block, along with the parser
+// state to return to at END.
+type block struct {
+	profile string
+	namespace string
+	isNamespace bool
+	started bool
+	// group is the command of a PARALLEL block.
+	group *Command
+
+	mode int
+	route *Route
+	command *Command
+	param *Using
+	withParam *Using
 }
 
 type handler struct {
 	mode int
+	// z reads the file, and knows its CODL version. bare is true while a
+	// bare word is being handled.
+	z *Tokenizer
+	bare bool
 	imports []string
+	importConds [][]string
 	routes []*Route
+	hooks []*Hook
 	err error
 
 	currentRoute *Route
+	// withParam is the WITH parameter being declared, if any.
+	withParam *Using
+	// hook is the BEFORE or AFTER block being declared, if any.
+	hook *Hook
+	blocks []*block
+	// cond is the block whose name is still being read.
+	cond *block
+	// clause is the ON ERROR or IGNORE ERRORS that is still being read.
+	clause *errorClause
+	// unless is true when an UNLESS is waiting for its source.
+	unless bool
+	// loop is the FOREACH or PARALLEL that is still being read.
+	loop *loopClause
+	// modifier is the TIMEOUT, RETRY or BACKOFF that is waiting for its
+	// value.
+	modifier string
+	// contexts are the CONTEXT keys, and contextKey is the last one.
+	contexts []*ContextKey
+	contextKey *ContextKey
+	// datasources are the DATASOURCEs, and datasource is the last one.
+	datasources []*Datasource
+	datasource *Datasource
+	// app is the APP, if any. flagSets are the FLAGS blocks, flagSet is the
+	// last one, and flag is the flag in it that is still being read.
+	// flagsRef is true when a SUBCOMMAND's FLAGS is waiting for its name.
+	app *App
+	flagSets []*FlagSet
+	flagSet *FlagSet
+	flag *Flag
+	flagsRef bool
+	// goBlocks are the GO blocks, and goBlock is one that is waiting for its
+	// code.
+	goBlocks []*GoBlock
+	goBlock *GoBlock
+	// tests are the TEST statements, and test is the last one. testClause
+	// is the ROUTE, GIVEN, MOCK or EXPECT whose words are being read.
+	tests []*TestSpec
+	test *TestSpec
+	testClause string
+	// literals are the code literals, and the other strings that go into
+	// the generated code unaltered.
+	literals []*CodeLiteral
+
+	// Annotations waiting for the next ROUTE or DOES.
+	pending Annotations
+	annotation *Annotation
+
+	// The file being parsed, and the absolute paths of the files that USE it.
+	// USE paths are relative to filename.
+	filename string
+	chain []string
+	// uses holds the absolute paths of the files that have been USEd in the
+	// compilation unit. All of the unit's files share it, so that a file
+	// that two files USE is only added once. nested is true for all but the
+	// outermost file.
+	uses map[string]bool
+	nested bool
+	used bool
+	routed bool
 }
 
+// Parse parses CODL from a reader.
+//
+// Any USE statements are resolved relative to the current directory. Use
+// ParseFile to resolve them relative to a file.
 func Parse(input io.Reader) (EventHandler, error) {
-	l := &handler {
+	return newHandler().parse(input)
+}
+
+// ParseFile opens and parses a CODL file.
+func ParseFile(filename string) (EventHandler, error) {
+	l, err := parseFile(filename, nil, nil)
+	if l == nil {
+		return nil, err
+	}
+	return l, err
+}
+
+func parseFile(filename string, chain []string, uses map[string]bool) (*handler, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	for i, prev := range chain {
+		if prev == abs {
+			cycle := []string{}
+			for _, f := range chain[i:] {
+				cycle = append(cycle, filepath.Base(f))
+			}
+			cycle = append(cycle, filepath.Base(abs))
+			return nil, fmt.Errorf("USE cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	input, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	l := newHandler()
+	l.filename = filename
+	l.chain = append(append([]string{}, chain...), abs)
+	if uses != nil {
+		l.uses = uses
+		l.nested = true
+	}
+	return l.parse(input)
+}
+
+func newHandler() *handler {
+	return &handler {
 		mode: TopMode,
 		imports: []string{},
 		routes: []*Route{},
+		uses: map[string]bool{},
 	}
-	z := NewTokenizer(input, l)
+}
+
+func (l *handler) parse(input io.Reader) (*handler, error) {
+	l.z = NewTokenizer(input, l)
 
 	for l.err == nil {
-		z.Next()
+		l.z.Next()
 	}
 
 	if l.err != io.EOF {
 		return l, l.err
 	}
 
+	// A NAMESPACE may run to the end of the file, but an IF or PARALLEL
+	// may not.
+	for i := len(l.blocks) - 1; i >= 0; i-- {
+		if b := l.blocks[i]; b.group != nil {
+			return l, fmt.Errorf("PARALLEL is missing an END")
+		} else if !b.isNamespace {
+			return l, fmt.Errorf("IF profile %s is missing an END", b.profile)
+		}
+	}
+	if l.cond != nil {
+		return l, fmt.Errorf("%s requires a name", l.cond.keyword())
+	}
+	if l.mode == HookMode && !l.hookReady() {
+		return l, l.err
+	}
+	if !l.complete() {
+		return l, l.err
+	}
+
+	l.resolveNames()
+	if err := l.inlineIncludes(); err != nil {
+		return l, err
+	}
+	// Hooks in a used file apply to the whole compilation unit, so they are
+	// only expanded once, in the outermost file.
+	if !l.nested {
+		l.applyHooks()
+	}
+	l.applyDefaults()
+	l.applyErrorPolicies()
+	// Like hooks, subcommands are expanded once, so that their flags are
+	// parsed before anything else runs.
+	if !l.nested {
+		if err := l.expandSubcommands(); err != nil {
+			return l, err
+		}
+	}
+	l.resolvePaths()
+	if err := l.resolveTests(); err != nil {
+		return l, err
+	}
+
+	if len(l.pending) > 0 {
+		return l, fmt.Errorf("@%s must come before a ROUTE or DOES", l.pending[0].Name)
+	}
+
 	return l, nil
 }
 
@@ -79,22 +362,77 @@ func (l *handler) Package() string {
 func (l *handler) Routes() []*Route {
 	return l.routes
 }
+
+// Hooks returns the BEFORE ROUTES and AFTER ROUTES blocks.
+func (l *handler) Hooks() []*Hook {
+	return l.hooks
+}
 func (l *handler) Imports() []string {
 	return l.imports
 }
+func (l *handler) importProfiles() [][]string {
+	return l.importConds
+}
 
 func (l *handler) Err() error {
 	return l.err
 }
 
 func (l *handler) Error(err error) {
-	l.err = err
+	// A bare word at the end of the input is followed by io.EOF. Don't let
+	// that hide an error the word caused.
+	if l.err == nil {
+		l.err = err
+	}
 }
 
 func (l *handler) Literal(str string) {
+	if l.cond != nil {
+		l.err = fmt.Errorf("%s takes a name, not a literal: %s", l.cond.keyword(), str)
+		return
+	}
+	if l.annotation != nil {
+		l.err = fmt.Errorf("@%s takes a string, not a literal: %s", l.annotation.Name, str)
+		return
+	}
+	if l.clause != nil {
+		l.err = fmt.Errorf("%s takes a route name, not a literal: %s", l.clause.said, str)
+		return
+	}
+	if l.unless {
+		l.err = fmt.Errorf("UNLESS takes a source, not a literal: %s", str)
+		return
+	}
+	if l.loop != nil {
+		l.err = fmt.Errorf("%s takes a bare word, not a literal: %s", l.loop.keyword(), str)
+		return
+	}
+	if l.modifier != "" {
+		l.err = fmt.Errorf("%s takes a bare word, not a literal: %s", l.modifier, str)
+		return
+	}
+	// CONTEXT types and DATASOURCE sources are recorded whether or not
+	// they are literals.
+	if l.mode == DoesMode && l.currentRoute.currentCommand.Cmd == "" {
+		l.code(LiteralCommand, str, false)
+	} else if !l.inContextType() && l.mode != DatasourceMode {
+		l.code(LiteralCode, str, false)
+	}
 	switch l.mode {
-	case TopMode, ImportMode, RouteMode, FromMode, IncludeMode:
+	case TopMode, ImportMode, RouteMode, FromMode, IncludeMode, ClosedMode, UseMode, HookMode, FlowMode, GroupMode:
 		l.err = fmt.Errorf("Literals are only allowed in DOES and USING: %s", str)
+	case ContextMode, DefaultMode:
+		l.contextWord(str, true)
+	case DatasourceMode:
+		l.datasourceWord(str, true)
+	case FlagsMode:
+		l.flagWord(str, true)
+	case AppMode:
+		l.err = fmt.Errorf("APP takes strings, not a literal: %s", str)
+	case GoMode:
+		l.goCode(str)
+	case TestMode:
+		l.testWord(str, true)
 	case DoesMode:
 		cc := l.currentRoute.currentCommand
 		if len(cc.Cmd) > 0 {
@@ -102,32 +440,148 @@ func (l *handler) Literal(str string) {
 			return
 		}
 		cc.Cmd = str
-	case UsingMode:
-		cc := l.currentRoute.currentCommand
+	case UsingMode, WithMode:
+		cp := l.param()
 		// In Using mode, we can take a default that is a literal.
-		if len(cc.currentParam.Name) == 0 {
-			l.err = fmt.Errorf("USING requires a name that is not a literal.")
+		if len(cp.Name) == 0 {
+			l.err = fmt.Errorf("%s requires a name that is not a literal.", l.paramKeyword())
+			return
+		} else if len(cp.DefaultVal) > 0 {
+			l.err = fmt.Errorf("%s only allows one default value", l.paramKeyword())
+			return
+		}
+		cp.DefaultVal = str
+	}
+}
+
+// Bareword handles an unquoted word.
+//
+// In CODL 2, where a USING takes its default value, a word that is a typed
+// literal (a number, bool, duration, list or map) becomes Go code of that
+// type. Everywhere else, and always in CODL 1, a bare word is handled like a
+// quoted string.
+func (l *handler) Bareword(str string) {
+	if l.version() < Version2 {
+		l.Strval(str)
+		return
+	}
+	if !l.reading() && (l.mode == UsingMode || l.mode == WithMode) {
+		cp := l.param()
+		if len(cp.Name) > 0 && len(cp.DefaultVal) == 0 {
+			code, usesTime, err := typedLiteral(str)
+			if err != nil {
+				l.err = err
+				return
+			}
+			if len(code) > 0 {
+				if usesTime {
+					l.addImport(asString("time"))
+				}
+				cp.DefaultVal = code
+				return
+			}
+		}
+	}
+	if !l.reading() && l.mode == TestMode && l.testValueNext() {
+		code, usesTime, err := typedLiteral(str)
+		if err != nil {
+			l.err = err
+			return
+		}
+		if len(code) > 0 {
+			if usesTime {
+				l.addImport(asString("time"))
+			}
+			l.testWord(code, true)
 			return
-		} else if len(cc.currentParam.DefaultVal) > 0 {
-			l.err = fmt.Errorf("USING only allows one default value")
+		}
+	}
+	if !l.reading() && l.mode == DefaultMode && l.contextKey.Default == "" {
+		code, usesTime, err := typedLiteral(str)
+		if err != nil {
+			l.err = err
+			return
+		}
+		if len(code) > 0 {
+			if usesTime {
+				l.addImport(asString("time"))
+			}
+			l.contextWord(code, true)
 			return
 		}
-		cc.currentParam.DefaultVal = str
 	}
+	l.bare = true
+	l.Strval(str)
+	l.bare = false
 }
+
+// version returns the CODL version of the file being parsed.
+func (l *handler) version() int {
+	if l.z == nil {
+		return Version1
+	}
+	return l.z.Version()
+}
+
 func (l *handler) Strval(str string){
 	orig := str
+	if strings.Contains(orig, "`") {
+		l.code(LiteralString, orig, true)
+	}
+
+	if l.cond != nil {
+		l.condition(orig)
+		return
+	}
+	if l.annotation != nil {
+		l.annotation.Value = orig
+		l.annotation = nil
+		return
+	}
+	if l.clause != nil {
+		l.clauseWord(orig)
+		return
+	}
+	if l.unless {
+		l.unless = false
+		l.when(orig, true)
+		return
+	}
+	if l.loop != nil {
+		l.loopWord(orig)
+		return
+	}
+	if l.modifier != "" {
+		l.modifierWord(orig)
+		return
+	}
 
 	str = asString(str)
 
 	switch l.mode {
-	case TopMode:
+	case TopMode, ClosedMode:
 		l.err = fmt.Errorf("String value is in the top scope: %s", str)
 	case ImportMode:
+		if l.hasImport(str) && len(l.profiles()) == 0 {
+			// Already imported for generated code.
+			return
+		}
 		l.imports = append(l.imports, str)
+		l.importConds = append(l.importConds, l.profiles())
 	case RouteMode:
-		if len(l.currentRoute.Name) == 0 {
+		if l.flagsRef {
+			l.currentRoute.Flags = orig
+			l.flagsRef = false
+		} else if len(l.currentRoute.Name) == 0 {
+			if l.bare {
+				l.currentRoute.firstWord = orig
+			}
+			if ns := l.currentRoute.Namespace; ns != "" {
+				str = asString(ns + "." + orig)
+			}
 			l.currentRoute.Name = str
+		} else if l.currentRoute.isPath(orig) {
+			l.httpRoute(l.currentRoute, orig)
 		} else if len(l.currentRoute.Description) == 0 {
 			l.currentRoute.Description = str
 		} else {
@@ -146,23 +600,48 @@ func (l *handler) Strval(str string){
 			//l.err = fmt.Errorf("DOES requires a `literal` for a command, not a string %s.", str)
 			//fmt.Printf("Got a str for a command: %s\n", orig)
 			l.currentRoute.currentCommand.Cmd = orig
+			l.code(LiteralCommand, orig, true)
 		} else if len(l.currentRoute.currentCommand.Name) == 0 {
 			l.currentRoute.currentCommand.Name = str
 		} else {
 			l.err = fmt.Errorf("DOES takes one literal and one string. No place for %s", str)
 		}
-	case UsingMode:
-		cp := l.currentRoute.currentCommand.currentParam
+	case UsingMode, WithMode:
+		cp := l.param()
 		if len(cp.Name) == 0 {
 			cp.Name = str
 		} else if len(cp.DefaultVal) == 0 {
 			cp.DefaultVal = str
 		} else {
-			l.err = fmt.Errorf("USING takes one literal and one string or literal. No place for %s", str)
+			l.err = fmt.Errorf("%s takes one literal and one string or literal. No place for %s", l.paramKeyword(), str)
 		}
 	case FromMode:
-		cp := l.currentRoute.currentCommand.currentParam
+		cp := l.param()
 		cp.From = append(cp.From, str)
+	case HookMode:
+		l.hookWord(orig)
+	case FlowMode:
+		l.flowWord(orig)
+	case GroupMode:
+		l.groupWord(orig)
+	case ContextMode, DefaultMode:
+		l.contextWord(orig, false)
+	case DatasourceMode:
+		l.datasourceWord(orig, false)
+	case FlagsMode:
+		l.flagWord(orig, false)
+	case AppMode:
+		l.appWord(orig)
+	case GoMode:
+		l.err = fmt.Errorf("GO takes a literal, not a string: %s", orig)
+	case TestMode:
+		l.testWord(orig, false)
+	case UseMode:
+		if l.used {
+			l.err = fmt.Errorf("USE takes one file name. No place for %s", str)
+			return
+		}
+		l.use(orig)
 	}
 
 }
@@ -172,7 +651,10 @@ func asString(str string) string {
 }
 
 func (l *handler) Import(){
-	if l.mode != TopMode && l.mode != ImportMode {
+	if !l.unannotated("IMPORT") {
+		return
+	}
+	if l.mode != TopMode && l.mode != ImportMode && !((l.mode == UseMode || l.mode == ContextMode || l.mode == DefaultMode || l.mode == DatasourceMode || l.mode == AppMode || l.mode == FlagsMode || l.mode == GoMode || l.mode == TestMode) && !l.routed) {
 		l.err = fmt.Errorf("IMPORT must be before first ROUTE (mode: %d != %d)", l.mode, TopMode)
 		return
 	}
@@ -180,32 +662,52 @@ func (l *handler) Import(){
 	l.mode = ImportMode
 }
 func (l *handler) Includes(){
+	if !l.unannotated("INCLUDES") || !l.ungrouped("INCLUDES") {
+		return
+	}
+	if !l.hookReady() {
+		return
+	}
 	switch l.mode {
-	case TopMode, ImportMode:
+	case TopMode, ImportMode, ClosedMode, UseMode, ContextMode, DefaultMode, DatasourceMode, AppMode, FlagsMode, GoMode, TestMode:
 		l.err = fmt.Errorf("INCLUDE is only allowed inside of a ROUTE")
 	//case RouteMode, UsingMode, DoesMode, FromMode, IncludeMode:
 	default:
-		c := &Command{ cmdType: cmdInclude }
+		c := &Command{ cmdType: cmdInclude, Profiles: l.profiles(), Namespace: l.namespace() }
 		l.mode = IncludeMode
+		l.withParam = nil
 		l.currentRoute.currentCommand = c
 		l.currentRoute.Commands = append(l.currentRoute.Commands, c)
 	}
 }
 
 func (l *handler) Route(){
+	if l.mode == TestMode && l.test.Route == "" && l.testClause == "" {
+		l.testRoute()
+		return
+	}
+	if !l.complete() || !l.ungrouped("ROUTE") {
+		return
+	}
 	// No modes override this.
 	l.mode = RouteMode
-	r := new(Route)
+	l.routed = true
+	l.withParam = nil
+	l.hook = nil
+	r := &Route{ Profiles: l.profiles(), Annotations: l.annotations(), Namespace: l.namespace() }
 	l.currentRoute = r
 	l.routes = append(l.routes, r)
 }
 
 func (l *handler) Using() {
+	if !l.unannotated("USING") {
+		return
+	}
 	switch l.mode {
-	case TopMode, ImportMode, IncludeMode, RouteMode:
+	case TopMode, ImportMode, IncludeMode, RouteMode, ClosedMode, UseMode, HookMode, FlowMode, GroupMode, ContextMode, DefaultMode, DatasourceMode, AppMode, FlagsMode, GoMode, TestMode:
 		l.err = fmt.Errorf("USING is only allowed inside of a DOES")
 	case DoesMode, UsingMode, FromMode:
-		u := new(Using)
+		u := &Using{ Profiles: l.profiles() }
 		cc := l.currentRoute.currentCommand
 		cc.currentParam = u
 		cc.Params = append(cc.Params, u)
@@ -214,20 +716,618 @@ func (l *handler) Using() {
 }
 
 func (l *handler) Does(){
+	// "FOREACH ... PARALLEL DOES" starts a PARALLEL block.
+	if l.loop != nil && l.loop.expect == "n" {
+		l.loop = nil
+		l.startGroup()
+	}
+	if !l.complete() || !l.hookReady() {
+		return
+	}
 	switch l.mode {
-	case TopMode, ImportMode:
+	case TopMode, ImportMode, ClosedMode, UseMode, ContextMode, DefaultMode, DatasourceMode, AppMode, FlagsMode, GoMode, TestMode:
 		l.err = fmt.Errorf("DOES can only appear inside of a ROUTE.")
 	default:
 		l.mode = DoesMode
-		c := new(Command)
-		l.currentRoute.Commands = append(l.currentRoute.Commands, c)
+		l.withParam = nil
+		c := &Command{ Profiles: l.profiles(), Annotations: l.annotations(), Namespace: l.namespace() }
+		if g := l.group(); g != nil {
+			g.Steps = append(g.Steps, c)
+		} else {
+			l.currentRoute.Commands = append(l.currentRoute.Commands, c)
+		}
 		l.currentRoute.currentCommand = c
 	}
 }
 func (l *handler) From(){
-	if l.mode != UsingMode {
-		l.err = fmt.Errorf("FROM can only appear insude of a USING")
+	if !l.unannotated("FROM") {
+		return
+	}
+	if l.mode != UsingMode && l.mode != WithMode {
+		l.err = fmt.Errorf("FROM can only appear insude of a USING or WITH")
 		return
 	}
 	l.mode = FromMode
 }
+
+// With adds a default parameter to the current ROUTE. It takes a name, an
+// optional default and an optional FROM, just like USING.
+//
+// After an INCLUDES, it instead overrides a parameter of the included route.
+func (l *handler) With() {
+	if !l.unannotated("WITH") {
+		return
+	}
+	r := l.currentRoute
+	if r != nil && r.currentCommand != nil && r.currentCommand.IsIncludes() && (l.mode == IncludeMode || l.withParam != nil) {
+		l.override(r.currentCommand)
+		return
+	}
+	ok := l.mode == RouteMode || l.mode == WithMode || (l.mode == FromMode && l.withParam != nil)
+	if !ok || r == nil || len(r.Commands) > 0 {
+		l.err = fmt.Errorf("WITH must come after a ROUTE and before its first DOES")
+		return
+	}
+	u := &Using{ Profiles: l.profiles() }
+	r.Defaults = append(r.Defaults, u)
+	l.withParam = u
+	l.mode = WithMode
+}
+
+// param returns the USING or WITH parameter that is being declared.
+func (l *handler) param() *Using {
+	if l.withParam != nil {
+		return l.withParam
+	}
+	return l.currentRoute.currentCommand.currentParam
+}
+
+func (l *handler) paramKeyword() string {
+	if l.withParam != nil {
+		return "WITH"
+	}
+	return "USING"
+}
+
+// applyDefaults adds each route's WITH parameters to the route's commands.
+// Inlined commands already have the parameters of the route they came from.
+func (l *handler) applyDefaults() {
+	for _, r := range l.routes {
+		for _, c := range r.Commands {
+			if c.Inlined == "" {
+				addDefaults(c, r.Defaults)
+			}
+		}
+	}
+}
+
+// addDefaults adds WITH parameters to a DOES, or to each DOES in a PARALLEL
+// block, for the profiles in which it has no USING of the same name. A DOES
+// marked @nowith gets none.
+func addDefaults(c *Command, defaults []*Using) {
+	switch {
+	case c.IsIncludes(), c.IsFlow():
+	case c.IsParallel():
+		for _, step := range c.Steps {
+			addDefaults(step, defaults)
+		}
+	case c.Annotations.has("nowith"):
+	default:
+		for _, d := range defaults {
+			for _, conds := range c.unset(d.Name) {
+				nd := *d
+				nd.From = append([]string{}, d.From...)
+				nd.Profiles = append(append([]string{}, d.Profiles...), conds...)
+				c.Params = append(c.Params, &nd)
+			}
+		}
+	}
+}
+
+// unset returns the profile conditions under which no USING of a command
+// sets a parameter, as alternatives that never hold together. There are
+// none if a USING always sets it.
+func (c *Command) unset(name string) [][]string {
+	alts := [][]string{{}}
+	for _, u := range c.Params {
+		if u.Name != name {
+			continue
+		}
+		// The command's own conditions hold wherever it runs.
+		conds := []string{}
+		for _, cond := range u.Profiles {
+			if !hasProfile(c.Profiles, cond) {
+				conds = append(conds, cond)
+			}
+		}
+		// The USING is not used when its first condition fails, or the
+		// first holds and the second fails, and so on.
+		next := [][]string{}
+		for _, alt := range alts {
+			for i := range conds {
+				fails := append(append([]string{}, conds[:i]...), negate(conds[i]))
+				if more, ok := extend(alt, fails); ok {
+					next = append(next, more)
+				}
+			}
+		}
+		alts = next
+	}
+	return alts
+}
+
+// negate returns the opposite of a profile condition: "dev" for "!dev",
+// and "!dev,test" for "dev,test".
+func negate(cond string) string {
+	if strings.HasPrefix(cond, "!") {
+		return cond[1:]
+	}
+	return "!" + cond
+}
+
+// extend adds conditions to a list of them. It reports false if one of them
+// contradicts the list.
+func extend(conds, more []string) ([]string, bool) {
+	out := append([]string{}, conds...)
+	for _, c := range more {
+		if hasProfile(out, negate(c)) {
+			return nil, false
+		}
+		if !hasProfile(out, c) {
+			out = append(out, c)
+		}
+	}
+	return out, true
+}
+
+func hasProfile(conds []string, cond string) bool {
+	for _, c := range conds {
+		if c == cond {
+			return true
+		}
+	}
+	return false
+}
+
+// Use starts a USE statement, which ends any open ROUTE. The next string is
+// the name of the file to use.
+func (l *handler) Use() {
+	if !l.unannotated("USE") || !l.ungrouped("USE") {
+		return
+	}
+	if l.namespace() != "" {
+		l.err = fmt.Errorf("USE is not allowed inside of a NAMESPACE")
+		return
+	}
+	l.mode = UseMode
+	l.used = false
+	l.currentRoute = nil
+	l.withParam = nil
+	l.hook = nil
+}
+
+// useDone reports whether a USE has its file name. If not, that is an
+// error.
+func (l *handler) useDone() bool {
+	if l.mode != UseMode || l.used {
+		return true
+	}
+	l.err = fmt.Errorf("USE requires a file name")
+	return false
+}
+
+// use parses another CODL file and adds its imports and routes to this one.
+// Everything it adds is subject to the IF blocks that are open here. A file
+// that has already been USEd in the compilation unit is not added again.
+func (l *handler) use(name string) {
+	l.used = true
+
+	fname := name
+	if !filepath.IsAbs(fname) {
+		dir := "."
+		if l.filename != "" {
+			dir = filepath.Dir(l.filename)
+		}
+		fname = filepath.Join(dir, fname)
+	}
+	abs, err := filepath.Abs(fname)
+	if err != nil {
+		l.err = err
+		return
+	}
+	if l.uses[abs] {
+		return
+	}
+
+	other, err := parseFile(fname, l.chain, l.uses)
+	if err != nil {
+		from := l.filename
+		if from == "" {
+			from = "input"
+		}
+		l.err = fmt.Errorf("in %s (used from %s): %s", name, from, err)
+		return
+	}
+	l.uses[abs] = true
+	// A file in the same directory as the outermost one is translated into
+	// the same package, where it declares its own Go names.
+	if len(l.chain) > 0 && filepath.Dir(abs) == filepath.Dir(l.chain[0]) {
+		other.share()
+	}
+
+	conds := l.profiles()
+	for i, imp := range other.imports {
+		iconds := append(append([]string{}, conds...), other.importConds[i]...)
+		if len(iconds) == 0 && l.hasImport(imp) {
+			continue
+		}
+		l.imports = append(l.imports, imp)
+		l.importConds = append(l.importConds, iconds)
+	}
+	for _, k := range other.contexts {
+		for _, have := range l.contexts {
+			if have.Func() == k.Func() {
+				l.err = fmt.Errorf("CONTEXT %s in %s and %s would have the same accessors", Unquote(k.Name), name, Unquote(have.Name))
+				return
+			}
+		}
+		l.contexts = append(l.contexts, k)
+	}
+	if other.app != nil {
+		if l.app != nil {
+			l.err = fmt.Errorf("There can only be one APP, but %s has another", name)
+			return
+		}
+		l.app = other.app
+	}
+	for _, fs := range other.flagSets {
+		if l.findFlagSet(fs.Name) != nil {
+			l.err = fmt.Errorf("FLAGS %s is declared in %s and in this file", fs.Name, name)
+			return
+		}
+		l.flagSets = append(l.flagSets, fs)
+	}
+	l.literals = append(l.literals, other.literals...)
+	for _, b := range other.goBlocks {
+		if len(conds) > 0 {
+			b.Profiles = append(append([]string{}, conds...), b.Profiles...)
+		}
+		l.goBlocks = append(l.goBlocks, b)
+	}
+	for _, d := range other.datasources {
+		if len(conds) > 0 {
+			d.Profiles = append(append([]string{}, conds...), d.Profiles...)
+		}
+		l.datasources = append(l.datasources, d)
+	}
+	for _, r := range other.routes {
+		if len(conds) > 0 {
+			r.Profiles = append(append([]string{}, conds...), r.Profiles...)
+		}
+		l.routes = append(l.routes, r)
+	}
+	// The hooks are applied in the outermost file, to all of its routes.
+	for _, h := range other.hooks {
+		if len(conds) > 0 {
+			h.Commands.Profiles = append(append([]string{}, conds...), h.Commands.Profiles...)
+		}
+		l.hooks = append(l.hooks, h)
+	}
+}
+
+// share marks the file's Go declarations as declared by another file in
+// the package.
+func (l *handler) share() {
+	for _, k := range l.contexts {
+		k.Shared = true
+	}
+	for _, fs := range l.flagSets {
+		fs.Shared = true
+	}
+	for _, b := range l.goBlocks {
+		b.Shared = true
+	}
+	if l.app != nil {
+		l.app.Shared = true
+	}
+}
+
+// usedFiles is implemented by registries that know the files they USE.
+type usedFiles interface {
+	Uses() []string
+}
+
+// Uses returns the absolute paths of the files that a registry USEs, and of
+// the files that they USE.
+func Uses(reg Registry) []string {
+	if uf, ok := reg.(usedFiles); ok {
+		return uf.Uses()
+	}
+	return nil
+}
+
+// Uses returns the absolute paths of the files that the file USEs, and of
+// the files that they USE, sorted.
+func (l *handler) Uses() []string {
+	files := make([]string, 0, len(l.uses))
+	for f := range l.uses {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// addImport imports a package that generated code needs, unless it is
+// already imported.
+func (l *handler) addImport(imp string) {
+	if !l.hasImport(imp) {
+		l.imports = append(l.imports, imp)
+		l.importConds = append(l.importConds, nil)
+	}
+}
+
+// hasImport reports whether a package is imported outside of any IF block.
+func (l *handler) hasImport(imp string) bool {
+	for i, have := range l.imports {
+		if have == imp && len(l.importConds[i]) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// If opens a profile block. The next two strings must be "profile" and the
+// name of a profile.
+func (l *handler) If() {
+	if !l.complete() {
+		return
+	}
+	l.open(&block{})
+}
+
+// Namespace opens a NAMESPACE block, which ends any open ROUTE. The next
+// string is the namespace's name. The block ends at END or at the end of the
+// file.
+func (l *handler) Namespace() {
+	if !l.unannotated("NAMESPACE") || !l.ungrouped("NAMESPACE") {
+		return
+	}
+	if l.mode != TopMode && l.mode != ImportMode {
+		l.mode = ClosedMode
+	}
+	l.currentRoute = nil
+	l.withParam = nil
+	l.hook = nil
+	l.open(&block{ isNamespace: true })
+}
+
+// open pushes a new block, remembering where to return to at its END.
+func (l *handler) open(b *block) {
+	b.mode = l.mode
+	b.route = l.currentRoute
+	b.withParam = l.withParam
+	if r := l.currentRoute; r != nil {
+		b.command = r.currentCommand
+		if r.currentCommand != nil {
+			b.param = r.currentCommand.currentParam
+		}
+	}
+	l.cond = b
+	l.blocks = append(l.blocks, b)
+}
+
+// End closes the innermost IF, NAMESPACE or PARALLEL block.
+//
+// If the block started a new ROUTE, that route is closed as well. Otherwise
+// parsing resumes in whatever DOES or USING was open when the block began.
+func (l *handler) End() {
+	if !l.unannotated("END") {
+		return
+	}
+	if l.cond != nil {
+		l.err = fmt.Errorf("%s requires a name before END", l.cond.keyword())
+		return
+	}
+	if len(l.blocks) == 0 {
+		l.err = fmt.Errorf("END without a matching IF, NAMESPACE or PARALLEL")
+		return
+	}
+	b := l.blocks[len(l.blocks)-1]
+	l.blocks = l.blocks[:len(l.blocks)-1]
+
+	if b.group != nil {
+		l.endGroup(b.group)
+		return
+	}
+
+	if b.route != l.currentRoute {
+		l.mode = ClosedMode
+		l.currentRoute = nil
+		return
+	}
+
+	l.mode = b.mode
+	l.withParam = b.withParam
+	if r := l.currentRoute; r != nil {
+		r.currentCommand = b.command
+		if b.command != nil {
+			b.command.currentParam = b.param
+		}
+	}
+}
+
+// condition fills in the IF or NAMESPACE block that is currently being
+// declared.
+func (l *handler) condition(str string) {
+	if l.cond.isNamespace {
+		if !namespaceRe.MatchString(str) {
+			l.err = fmt.Errorf("Illegal namespace: %s", str)
+			return
+		}
+		if outer := l.namespace(); outer != "" {
+			str = outer + "." + str
+		}
+		l.cond.namespace = str
+		l.cond = nil
+		return
+	}
+	if !l.cond.started {
+		// "IF cxt:name" after a DOES is a condition on the DOES, not a
+		// block. Profile names cannot contain ':'.
+		if strings.Contains(str, ":") {
+			l.blocks = l.blocks[:len(l.blocks)-1]
+			l.cond = nil
+			l.when(str, false)
+			return
+		}
+		if str != "profile" {
+			l.err = fmt.Errorf("IF expects 'profile' or a source such as cxt:name, got %s", str)
+			return
+		}
+		l.cond.started = true
+		return
+	}
+	if !validProfile(str) {
+		l.err = fmt.Errorf("Illegal profile name: %s", str)
+		return
+	}
+	l.cond.profile = str
+	l.cond = nil
+}
+
+// profiles returns the conditions of all open IF blocks.
+func (l *handler) profiles() []string {
+	if l.cond != nil {
+		l.err = fmt.Errorf("%s requires a name", l.cond.keyword())
+		return nil
+	}
+	var p []string
+	for _, b := range l.blocks {
+		if !b.isNamespace && b.group == nil {
+			p = append(p, b.profile)
+		}
+	}
+	return p
+}
+
+// namespace returns the innermost open namespace, or "".
+func (l *handler) namespace() string {
+	for i := len(l.blocks) - 1; i >= 0; i-- {
+		if b := l.blocks[i]; b.isNamespace && b != l.cond {
+			return b.namespace
+		}
+	}
+	return ""
+}
+
+// resolveNames points INCLUDES, REROUTE and ON ERROR inside a namespace at the
+// namespace's own routes. An unqualified name is looked up in the innermost
+// namespace first, then in each enclosing one, and is left alone if none has
+// it.
+func (l *handler) resolveNames() {
+	names := map[string]bool{}
+	for _, r := range l.routes {
+		names[Unquote(r.Name)] = true
+	}
+	resolve := func(name *string, ns string) {
+		if *name == "" || ns == "" {
+			return
+		}
+		if found, ok := resolveName(Unquote(*name), ns, names); ok {
+			*name = asString(found)
+		}
+	}
+	for _, r := range l.routes {
+		resolve(&r.OnError, r.Namespace)
+		for _, c := range r.Commands {
+			if c.IsIncludes() {
+				resolve(&c.Name, c.Namespace)
+			} else {
+				resolve(&c.OnError, c.Namespace)
+				resolve(&c.Target, c.Namespace)
+			}
+			for _, step := range c.Steps {
+				resolve(&step.OnError, step.Namespace)
+			}
+		}
+	}
+}
+
+// resolveName finds the namespace-local route that name refers to.
+func resolveName(name, ns string, names map[string]bool) (string, bool) {
+	for ns != "" {
+		if names[ns + "." + name] {
+			return ns + "." + name, true
+		}
+		if i := strings.LastIndex(ns, "."); i >= 0 {
+			ns = ns[:i]
+		} else {
+			ns = ""
+		}
+	}
+	return "", false
+}
+
+func (b *block) keyword() string {
+	if b.isNamespace {
+		return "NAMESPACE"
+	} else if b.group != nil {
+		return "PARALLEL"
+	}
+	return "IF profile"
+}
+
+// Annotation queues an annotation for the next ROUTE or DOES. Annotations
+// other than @hidden, @nohooks and @nowith take one string.
+func (l *handler) Annotation(name string) {
+	if !l.complete() {
+		return
+	}
+	if l.annotation != nil {
+		l.err = fmt.Errorf("@%s requires a value", l.annotation.Name)
+		return
+	}
+	a := &Annotation{ Name: name }
+	l.pending = append(l.pending, a)
+	if name != "hidden" && name != "nohooks" && name != "nowith" {
+		l.annotation = a
+	}
+}
+
+// annotations returns and clears the queued annotations.
+func (l *handler) annotations() Annotations {
+	if l.annotation != nil {
+		l.err = fmt.Errorf("@%s requires a value", l.annotation.Name)
+		return nil
+	}
+	a := l.pending
+	l.pending = nil
+	return a
+}
+
+// unannotated reports whether no annotations are waiting. If any are, they
+// are in front of a keyword that cannot take them, which is an error. So is
+// an unfinished statement, such as a REROUTE without a route name.
+func (l *handler) unannotated(keyword string) bool {
+	if !l.complete() {
+		return false
+	}
+	if len(l.pending) == 0 {
+		return true
+	}
+	l.err = fmt.Errorf("@%s must come before a ROUTE or DOES, not %s", l.pending[0].Name, keyword)
+	return false
+}
+
+// complete reports whether the last statement has all of its parts. It
+// is checked before each keyword, and at the end of the file.
+func (l *handler) complete() bool {
+	return l.useDone() && l.clauseDone() && l.flowReady() && l.unlessDone() && l.loopDone() && l.modifierDone() && l.contextDone() && l.datasourceDone() && l.flagsDone() && l.goDone() && l.testDone()
+}
+
+// reading reports whether the next string belongs to a keyword that is
+// still being read, such as an IF, an annotation or an ON ERROR, rather
+// than to the current statement.
+func (l *handler) reading() bool {
+	return l.cond != nil || l.annotation != nil || l.clause != nil || l.unless || l.loop != nil || l.modifier != ""
+}
@@ -1,10 +1,17 @@
 package parser
 
 import (
+	"io"
+	"path/filepath"
 	"testing"
 	"strings"
 )
 
+// v2 reads doc as CODL 2.
+func v2(doc string) io.Reader {
+	return strings.NewReader("CODL 2\n" + doc)
+}
+
 func TestSimpleParse(t *testing.T) {
 	doc := `IMPORT foo`
 	input := strings.NewReader(doc)
@@ -166,3 +173,1358 @@ ROUTE route3 "Route Three"
 		t.Errorf("Expected 3rd command to be two, got %s", handy.routes[2].Commands[1].Name)
 	}
 }
+
+func TestParseProfiles(t *testing.T) {
+	doc := `
+IF profile dev
+	IMPORT example.com/devtools
+END
+
+ROUTE a "Always"
+	DOES «foo.Bar» bar
+		USING p1 "prod value"
+		IF profile dev USING p2 "dev value" END
+		USING p3 "always"
+	IF profile !prod
+		DOES «foo.Debug» debug
+	END
+	DOES «foo.Baz» baz
+
+IF profile dev
+ROUTE b "Dev only"
+	DOES «foo.Bar» bar
+END
+
+ROUTE c "After"
+`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	handy := h.(*handler)
+
+	if len(handy.importConds[0]) != 1 || handy.importConds[0][0] != "dev" {
+		t.Errorf("Expected import to be conditional on dev, got %v", handy.importConds[0])
+	}
+
+	if len(handy.routes) != 3 {
+		t.Fatalf("Expected 3 routes, got %d", len(handy.routes))
+	}
+
+	a := handy.routes[0]
+	if len(a.Commands) != 3 {
+		t.Fatalf("Expected 3 commands, got %d", len(a.Commands))
+	}
+	params := a.Commands[0].Params
+	if len(params) != 3 {
+		t.Fatalf("Expected USING after END to return to the DOES, got %d params", len(params))
+	}
+	if len(params[1].Profiles) != 1 || params[1].Profiles[0] != "dev" {
+		t.Errorf("Expected p2 to be in dev, got %v", params[1].Profiles)
+	}
+	if len(params[2].Profiles) != 0 {
+		t.Errorf("Expected p3 to be unconditional, got %v", params[2].Profiles)
+	}
+	if a.Commands[1].Profiles[0] != "!prod" {
+		t.Errorf("Expected debug to be in !prod, got %v", a.Commands[1].Profiles)
+	}
+	if len(a.Commands[2].Profiles) != 0 {
+		t.Errorf("Expected baz to be unconditional")
+	}
+
+	if handy.routes[1].Profiles[0] != "dev" {
+		t.Errorf("Expected route b to be in dev")
+	}
+	if len(handy.routes[2].Profiles) != 0 {
+		t.Errorf("Expected route c to be unconditional")
+	}
+}
+
+func TestParseProfileErrors(t *testing.T) {
+	docs := []string{
+		`IF profile dev ROUTE a`,
+		`ROUTE a END`,
+		`IF dev ROUTE a END`,
+		`IF profile ROUTE a END`,
+		`IF profile dev ROUTE a END DOES «foo»`,
+	}
+	for _, doc := range docs {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseAnnotations(t *testing.T) {
+	doc := `
+@tag api @owner "platform"
+@hidden
+ROUTE @update "Internal"
+	@deprecated "use cmd.New"
+	DOES «cmd.Old» old
+	DOES «cmd.New» new
+ROUTE plain "No annotations"`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	handy := h.(*handler)
+	r := handy.routes[0]
+	if r.Name != "`@update`" {
+		t.Errorf("Expected @update to be a route name, got %s", r.Name)
+	}
+	if len(r.Annotations) != 3 {
+		t.Fatalf("Expected 3 annotations, got %d", len(r.Annotations))
+	}
+	if tags := r.Annotations.Tags(); len(tags) != 1 || tags[0] != "api" {
+		t.Errorf("Expected tag api, got %v", tags)
+	}
+	if r.Annotations.Owner() != "platform" {
+		t.Errorf("Expected owner platform, got %s", r.Annotations.Owner())
+	}
+	if !r.Annotations.Hidden() {
+		t.Errorf("Expected route to be hidden")
+	}
+
+	if r.Commands[0].Annotations.Deprecated() != "use cmd.New" {
+		t.Errorf("Expected first command to be deprecated")
+	}
+	if len(r.Commands[1].Annotations) != 0 {
+		t.Errorf("Expected annotations to apply only to the next DOES")
+	}
+	if len(handy.routes[1].Annotations) != 0 {
+		t.Errorf("Expected annotations to apply only to the next ROUTE")
+	}
+}
+
+func TestParseAnnotationErrors(t *testing.T) {
+	docs := []string{
+		`ROUTE a @tag api USING x`,
+		`ROUTE a DOES «b» @tag api INCLUDES c`,
+		`@tag ROUTE a`,
+		`ROUTE a @hidden`,
+	}
+	for _, doc := range docs {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseFileUse(t *testing.T) {
+	h, err := ParseFile("testdata/use/app.codl")
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	handy := h.(*handler)
+
+	imports := []string{"`github.com/Masterminds/cookoo/cli`", "`example.com/health`", "`example.com/app`"}
+	if len(handy.imports) != len(imports) {
+		t.Fatalf("Expected imports %v, got %v", imports, handy.imports)
+	}
+	for i, imp := range imports {
+		if handy.imports[i] != imp {
+			t.Errorf("Expected import %s, got %s", imp, handy.imports[i])
+		}
+	}
+
+	names := []string{"`health`", "`version`", "`build`", "`debug`"}
+	if len(handy.routes) != len(names) {
+		t.Fatalf("Expected %d routes, got %d", len(names), len(handy.routes))
+	}
+	for i, name := range names {
+		if handy.routes[i].Name != name {
+			t.Errorf("Expected route %s, got %s", name, handy.routes[i].Name)
+		}
+	}
+	if p := handy.routes[3].Profiles; len(p) != 1 || p[0] != "dev" {
+		t.Errorf("Expected used route to be in the dev profile, got %v", p)
+	}
+}
+
+func TestParseFileUseTwice(t *testing.T) {
+	h, err := ParseFile("testdata/use/diamond.codl")
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	handy := h.(*handler)
+	names := []string{"`health`", "`version`", "`left`", "`right`"}
+	if len(handy.routes) != len(names) {
+		t.Fatalf("Expected %d routes, got %d", len(names), len(handy.routes))
+	}
+	for i, name := range names {
+		if handy.routes[i].Name != name {
+			t.Errorf("Expected route %s, got %s", name, handy.routes[i].Name)
+		}
+	}
+
+	uses := Uses(handy)
+	files := []string{"base.codl", "left.codl", "right.codl"}
+	if len(uses) != len(files) {
+		t.Fatalf("Expected %v to be used, got %v", files, uses)
+	}
+	for i, f := range files {
+		if !filepath.IsAbs(uses[i]) || filepath.Base(uses[i]) != f {
+			t.Errorf("Expected %s to be used, got %s", f, uses[i])
+		}
+	}
+}
+
+func TestParseUseHooks(t *testing.T) {
+	doc := `
+IF profile dev
+	USE testdata/use/common/auth.codl
+END
+ROUTE home "Home"
+	DOES web.Home home`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	handy := h.(*handler)
+
+	// The hook in the used file applies to the routes of both files, in
+	// the profile that the USE is in.
+	for _, r := range handy.routes {
+		if len(r.Commands) != 2 || r.Commands[0].Cmd != "auth.Check" {
+			t.Errorf("Expected %s to start with the used hook, got %v", r.Name, r.Commands)
+			continue
+		}
+		if p := r.Commands[0].Profiles; len(p) != 1 || p[0] != "dev" {
+			t.Errorf("Expected the hook command in %s to be in the dev profile, got %v", r.Name, p)
+		}
+	}
+}
+
+func TestParseFileUseCycle(t *testing.T) {
+	_, err := ParseFile("testdata/use/cycle_a.codl")
+	if err == nil {
+		t.Fatalf("Expected a USE cycle error")
+	}
+	expect := "USE cycle: cycle_a.codl -> cycle_b.codl -> cycle_a.codl"
+	if !strings.Contains(err.Error(), expect) {
+		t.Errorf("Expected %q in error, got %q", expect, err)
+	}
+	if !strings.Contains(err.Error(), "(used from testdata/use/cycle_a.codl)") {
+		t.Errorf("Expected the include chain in error, got %q", err)
+	}
+}
+
+func TestParseUseErrors(t *testing.T) {
+	docs := []string{
+		`USE testdata/use/nope.codl`,
+		`USE testdata/use/common/debug.codl testdata/use/common/base.codl`,
+		`ROUTE a USE testdata/use/common/debug.codl DOES «foo»`,
+		`ROUTE a USE testdata/use/common/debug.codl IMPORT foo`,
+		"CONTEXT token int \"A number\"\nUSE testdata/use/shared/base.codl",
+		"USE testdata/use/shared/base.codl\nCONTEXT token int \"A number\"",
+		"USE\nROUTE a \"b\"\n\tDOES «c» d",
+		"ROUTE a \"b\"\nUSE",
+	}
+	for _, doc := range docs {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseNamespaces(t *testing.T) {
+	doc := `
+ROUTE help "Global help"
+ROUTE login "Global login"
+
+NAMESPACE admin
+	ROUTE help "Admin help"
+	ROUTE index "Admin index"
+		INCLUDES help
+		INCLUDES login
+		INCLUDES admin.help
+	NAMESPACE users
+		ROUTE list "List users"
+			INCLUDES help
+	END
+	ROUTE after "After users"
+END
+
+ROUTE top "Top level"
+	INCLUDES help`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	handy := h.(*handler)
+
+	names := []string{"`help`", "`login`", "`admin.help`", "`admin.index`", "`admin.users.list`", "`admin.after`", "`top`"}
+	for i, name := range names {
+		if handy.routes[i].Name != name {
+			t.Errorf("Expected route %s, got %s", name, handy.routes[i].Name)
+		}
+	}
+
+	index := handy.routes[3].Commands
+	includes := []string{"`admin.help`", "`login`", "`admin.help`"}
+	for i, name := range includes {
+		if index[i].Name != name {
+			t.Errorf("Expected INCLUDES %s, got %s", name, index[i].Name)
+		}
+	}
+	if n := handy.routes[4].Commands[0].Name; n != "`admin.help`" {
+		t.Errorf("Expected nested namespace to find admin.help, got %s", n)
+	}
+	if n := handy.routes[6].Commands[0].Name; n != "`help`" {
+		t.Errorf("Expected top-level INCLUDES to stay global, got %s", n)
+	}
+}
+
+func TestParseFileNamespace(t *testing.T) {
+	// Without END, a NAMESPACE runs to the end of the file.
+	doc := `IMPORT foo NAMESPACE admin ROUTE help "Help" IF profile dev ROUTE debug "Debug" END`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	handy := h.(*handler)
+	if handy.routes[0].Name != "`admin.help`" || handy.routes[1].Name != "`admin.debug`" {
+		t.Errorf("Expected file-level namespace, got %s and %s", handy.routes[0].Name, handy.routes[1].Name)
+	}
+
+	bad := []string{
+		`NAMESPACE`,
+		`NAMESPACE admin USE foo.codl`,
+		`NAMESPACE "a b" ROUTE x`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseRouteDefaults(t *testing.T) {
+	doc := `
+ROUTE build "Build"
+	WITH flagset «buildFlags»
+	WITH dir "." FROM cxt:d
+	IF profile dev WITH verbose true END
+	DOES cli.ParseArgs args
+		USING subcommand true
+	DOES cmd.FindCodl files
+		USING dir "src"
+	INCLUDES other`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	r := h.(*handler).routes[0]
+
+	if len(r.Defaults) != 3 {
+		t.Fatalf("Expected 3 defaults, got %d", len(r.Defaults))
+	}
+	if r.Defaults[1].From[0] != "`cxt:d`" {
+		t.Errorf("Expected WITH to take a FROM")
+	}
+	if r.Defaults[2].DefaultVal != "true" || r.Defaults[2].Profiles[0] != "dev" {
+		t.Errorf("Expected typed, conditional default, got %v", r.Defaults[2])
+	}
+
+	args := r.Commands[0].Params
+	names := []string{"`subcommand`", "`flagset`", "`dir`", "`verbose`"}
+	if len(args) != len(names) {
+		t.Fatalf("Expected %d params, got %d", len(names), len(args))
+	}
+	for i, name := range names {
+		if args[i].Name != name {
+			t.Errorf("Expected param %s, got %s", name, args[i].Name)
+		}
+	}
+
+	files := r.Commands[1].Params
+	if len(files) != 3 || files[0].DefaultVal != "`src`" || len(files[0].From) != 0 {
+		t.Errorf("Expected USING to take precedence over WITH, got %v", files[0])
+	}
+
+	if len(r.Commands[2].Params) != 0 {
+		t.Errorf("Expected INCLUDES to be left alone")
+	}
+
+	// A USING in a profile only replaces the WITH in that profile.
+	doc = `
+ROUTE find "Find"
+	WITH dir "."
+	DOES cmd.Find files
+		IF profile dev USING dir "/dev" END
+	@nowith
+	DOES cmd.Show shown
+	IF profile test
+		DOES cmd.Find found
+			USING dir "/test"
+	END
+	DOES cmd.Find again
+		IF profile dev,test USING dir "/dt" END
+		IF profile !test USING dir "/nt" END`
+	h, err = Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	r = h.(*handler).routes[0]
+	dirs := func(c *Command, profile string) []string {
+		vals := []string{}
+		for _, u := range c.Params {
+			if u.Name == "`dir`" && MatchProfile(u.Profiles, profile) {
+				vals = append(vals, u.DefaultVal)
+			}
+		}
+		return vals
+	}
+	expects := []struct {
+		cmd int
+		profile string
+		dirs string
+	}{
+		{0, "", "`.`"},
+		{0, "prod", "`.`"},
+		{0, "dev", "`/dev`"},
+		{1, "", ""},
+		{2, "test", "`/test`"},
+		{3, "", "`/nt`"},
+		{3, "dev", "`/dt` `/nt`"},
+		{3, "test", "`/dt`"},
+		{3, "prod", "`/nt`"},
+	}
+	for _, e := range expects {
+		c := r.Commands[e.cmd]
+		if got := strings.Join(dirs(c, e.profile), " "); got != e.dirs {
+			t.Errorf("Expected %s to have dir %q for profile %q, got %q", c.Name, e.dirs, e.profile, got)
+		}
+	}
+	if n := len(r.Commands[2].Params); n != 1 {
+		t.Errorf("Expected no WITH for a DOES that is only in the USING's profile, got %d params", n)
+	}
+
+	bad := []string{
+		`WITH a b`,
+		`ROUTE a DOES «b» WITH c d`,
+		`ROUTE a DOES «b» USING c FROM cxt:c WITH d e`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseHooks(t *testing.T) {
+	doc := `
+BEFORE ROUTES
+	DOES auth.Check ok
+BEFORE ROUTES MATCHING admin.*
+	DOES auth.Admin admin
+AFTER ROUTES TAGGED web
+	DOES web.Flush flush
+
+ROUTE home "Home"
+	DOES web.Home home
+
+@tag web
+ROUTE admin.users "Users"
+	WITH realm admin
+	DOES web.Users users
+
+@nohooks
+ROUTE health "Health"
+	DOES web.Health health`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	handy := h.(*handler)
+
+	if len(handy.routes) != 3 || len(handy.Hooks()) != 3 {
+		t.Fatalf("Expected 3 routes and 3 hooks, got %d and %d", len(handy.routes), len(handy.Hooks()))
+	}
+
+	expects := [][]string{
+		{"auth.Check", "web.Home"},
+		{"auth.Check", "auth.Admin", "web.Users", "web.Flush"},
+		{"web.Health"},
+	}
+	for i, cmds := range expects {
+		got := handy.routes[i].Commands
+		if len(got) != len(cmds) {
+			t.Errorf("Expected %s to have %v, got %d commands", handy.routes[i].Name, cmds, len(got))
+			continue
+		}
+		for j, cmd := range cmds {
+			if got[j].Cmd != cmd {
+				t.Errorf("Expected %s command %d to be %s, got %s", handy.routes[i].Name, j, cmd, got[j].Cmd)
+			}
+		}
+	}
+
+	// Hook commands are copies, and get the route's WITH parameters.
+	users := handy.routes[1].Commands
+	if len(users[0].Params) != 1 || users[0].Params[0].Name != "`realm`" {
+		t.Errorf("Expected WITH to apply to hook commands")
+	}
+	if len(handy.routes[0].Commands[0].Params) != 0 {
+		t.Errorf("Expected hook commands to be copied for each route")
+	}
+
+	bad := []string{
+		`BEFORE DOES foo`,
+		`BEFORE ROUTES MATCHING DOES foo`,
+		`AFTER ROUTES UNLESS x`,
+		`NAMESPACE a BEFORE ROUTES`,
+		`AFTER ROUTES TAGGED`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseErrorPolicies(t *testing.T) {
+	doc := `
+ROUTE save "Save"
+	ON ERROR REROUTE "errors.show"
+	WITH id 0
+	DOES db.Save save
+		USING id FROM cxt:id
+		IGNORE ERRORS
+	DOES db.Load load
+	INCLUDES audit
+
+NAMESPACE errors
+	ROUTE show "Show"
+	ROUTE api "API"
+		DOES api.Call call ON ERROR REROUTE show
+		DOES api.Log log ON ERROR REROUTE nowhere
+END`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	routes := h.(*handler).routes
+
+	save := routes[0]
+	if save.OnError != "`errors.show`" {
+		t.Errorf("Expected route-level ON ERROR, got %q", save.OnError)
+	}
+	if c := save.Commands[0]; !c.IgnoreErrors || c.OnError != "" {
+		t.Errorf("Expected IGNORE ERRORS to override the route, got %v", c.ErrorPolicy)
+	}
+	if c := save.Commands[1]; c.OnError != "`errors.show`" {
+		t.Errorf("Expected DOES to get the route's ON ERROR, got %v", c.ErrorPolicy)
+	}
+	if c := save.Commands[2]; c.HasErrorPolicy() {
+		t.Errorf("Expected INCLUDES to be left alone")
+	}
+
+	api := routes[2]
+	if c := api.Commands[0]; c.OnError != "`errors.show`" {
+		t.Errorf("Expected ON ERROR to resolve in the namespace, got %s", c.OnError)
+	}
+	if c := api.Commands[1]; c.OnError != "`nowhere`" {
+		t.Errorf("Expected unknown ON ERROR to be left alone, got %s", c.OnError)
+	}
+
+	bad := []string{
+		`ON ERROR REROUTE x`,
+		`ROUTE a DOES «b» b ON ERROR`,
+		`ROUTE a DOES «b» b ON ERROR REROUTE`,
+		`ROUTE a DOES «b» b ON ERROR RETRY x`,
+		`ROUTE a DOES «b» b ON ERROR REROUTE «x»`,
+		`ROUTE a DOES «b» b IGNORE WARNINGS`,
+		`ROUTE a DOES «b» b IGNORE ERRORS ON ERROR REROUTE x`,
+		`ROUTE a DOES «b» b ON ERROR DOES «c» c`,
+		`ROUTE a INCLUDES b IGNORE ERRORS`,
+		`ROUTE a DOES «b» b INCLUDES c ON ERROR REROUTE x`,
+		`BEFORE ROUTES IGNORE ERRORS`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseFlow(t *testing.T) {
+	doc := `
+ROUTE old "Moved"
+	WITH id 0
+	REROUTE new
+
+NAMESPACE admin
+	ROUTE new "New"
+	ROUTE index "Index"
+		DOES auth.Check ok
+		IF profile prod STOP END
+		DOES admin.Index index
+		REROUTE new
+END`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	routes := h.(*handler).routes
+
+	old := routes[0].Commands
+	if len(old) != 1 || !old[0].IsReroute() || old[0].Target != "`new`" {
+		t.Fatalf("Expected a REROUTE to new, got %v", old)
+	}
+	if len(old[0].Params) != 0 {
+		t.Errorf("Expected WITH to skip REROUTE")
+	}
+
+	index := routes[2].Commands
+	if len(index) != 4 {
+		t.Fatalf("Expected 4 commands, got %d", len(index))
+	}
+	if !index[1].IsStop() || index[1].Profiles[0] != "prod" {
+		t.Errorf("Expected a conditional STOP, got %v", index[1])
+	}
+	if index[3].Target != "`admin.new`" {
+		t.Errorf("Expected REROUTE to resolve in the namespace, got %s", index[3].Target)
+	}
+
+	bad := []string{
+		`REROUTE a`,
+		`ROUTE a REROUTE`,
+		`ROUTE a REROUTE DOES «b» b`,
+		`ROUTE a REROUTE b c`,
+		`ROUTE a REROUTE «b»`,
+		`ROUTE a STOP b`,
+		`ROUTE a STOP USING b`,
+		`ROUTE a STOP IGNORE ERRORS`,
+		`ROUTE a DOES «b» b ON ERROR REROUTE REROUTE c`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseConditions(t *testing.T) {
+	doc := `
+ROUTE build "Build"
+	DOES cli.ShowHelp help IF cxt:h
+		USING show true
+	DOES cmd.Translate created
+		IF profile dev USING verbose true END
+		UNLESS query:dryRun
+	DOES cmd.Log log`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	cmds := h.(*handler).routes[0].Commands
+
+	if cmds[0].Condition != "`cxt:h`" || cmds[0].Unless {
+		t.Errorf("Expected IF cxt:h, got %q", cmds[0].Condition)
+	}
+	if len(cmds[0].Params) != 1 {
+		t.Errorf("Expected USING after IF to belong to the DOES")
+	}
+	if cmds[1].Condition != "`query:dryRun`" || !cmds[1].Unless {
+		t.Errorf("Expected UNLESS query:dryRun, got %q", cmds[1].Condition)
+	}
+	if p := cmds[1].Params; len(p) != 1 || p[0].Profiles[0] != "dev" {
+		t.Errorf("Expected IF profile to still open a block")
+	}
+	if cmds[2].Condition != "" {
+		t.Errorf("Expected no condition, got %q", cmds[2].Condition)
+	}
+
+	bad := []string{
+		`IF cxt:h`,
+		`ROUTE a IF cxt:h`,
+		`ROUTE a DOES «b» b UNLESS`,
+		`ROUTE a DOES «b» b UNLESS h`,
+		`ROUTE a DOES «b» b UNLESS «cxt:h»`,
+		`ROUTE a DOES «b» b IF cxt:h UNLESS cxt:i`,
+		`ROUTE a DOES «b» b UNLESS DOES «c» c`,
+		`ROUTE a INCLUDES b IF cxt:h`,
+		`ROUTE a WITH b c IF cxt:h`,
+		`ROUTE a STOP UNLESS cxt:h`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseForEach(t *testing.T) {
+	doc := `
+ROUTE build "Build"
+	DOES cmd.TranslateOne out FOREACH file IN cxt:files
+		USING skipEmpty true
+	DOES cmd.Lint lint
+		FOREACH f IN cxt:files PARALLEL 4`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	cmds := h.(*handler).routes[0].Commands
+
+	loop := cmds[0].ForEach
+	if loop == nil || loop.Var != "`file`" || loop.Source != "`cxt:files`" || loop.Parallel != 0 {
+		t.Errorf("Expected FOREACH file IN cxt:files, got %v", loop)
+	}
+	if len(cmds[0].Params) != 1 {
+		t.Errorf("Expected USING after FOREACH to belong to the DOES")
+	}
+	if loop := cmds[1].ForEach; loop == nil || loop.Parallel != 4 {
+		t.Errorf("Expected PARALLEL 4, got %v", loop)
+	}
+
+	bad := []string{
+		`ROUTE a FOREACH f IN cxt:files`,
+		`ROUTE a DOES «b» b FOREACH`,
+		`ROUTE a DOES «b» b FOREACH f`,
+		`ROUTE a DOES «b» b FOREACH f OF cxt:files`,
+		`ROUTE a DOES «b» b FOREACH f IN files`,
+		`ROUTE a DOES «b» b FOREACH f IN USING c`,
+		`ROUTE a DOES «b» b FOREACH f IN cxt:a FOREACH g IN cxt:b`,
+		`ROUTE a DOES «b» b PARALLEL 2`,
+		`ROUTE a DOES «b» b FOREACH f IN cxt:a PARALLEL`,
+		`ROUTE a DOES «b» b FOREACH f IN cxt:a PARALLEL 0`,
+		`ROUTE a DOES «b» b FOREACH f IN cxt:a PARALLEL many`,
+		`ROUTE a DOES «b» b FOREACH «f» IN cxt:a`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseParallel(t *testing.T) {
+	doc := `
+ROUTE dashboard "Dashboard"
+	WITH user FROM cxt:user
+	DOES auth.Check ok
+	PARALLEL fetch
+		DOES db.Orders orders
+			USING limit 10
+		IF profile dev
+			DOES db.Debug debug
+		END
+		DOES cache.Stats stats IGNORE ERRORS
+	END
+	PARALLEL
+		DOES a.A a FOREACH x IN cxt:xs PARALLEL 2
+		DOES b.B b
+	END
+	DOES web.Render page
+	DOES c.C c FOREACH x IN cxt:xs PARALLEL
+		DOES d.D d
+	END`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	cmds := h.(*handler).routes[0].Commands
+	if len(cmds) != 6 {
+		t.Fatalf("Expected 6 commands, got %d", len(cmds))
+	}
+
+	fetch := cmds[1]
+	if !fetch.IsParallel() || fetch.Name != "`fetch`" || len(fetch.Steps) != 3 {
+		t.Fatalf("Expected a PARALLEL block named fetch with 3 steps, got %v", fetch)
+	}
+	if fetch.Steps[1].Profiles[0] != "dev" || len(fetch.Profiles) != 0 {
+		t.Errorf("Expected IF profile to apply to the step only")
+	}
+	if p := fetch.Steps[0].Params; len(p) != 2 || p[1].Name != "`user`" {
+		t.Errorf("Expected WITH to apply to steps, got %v", p)
+	}
+	if !fetch.Steps[2].IgnoreErrors {
+		t.Errorf("Expected IGNORE ERRORS on the step")
+	}
+
+	if cmds[2].Name != "`@parallel`" || cmds[2].Steps[0].ForEach.Parallel != 2 {
+		t.Errorf("Expected an unnamed block with a FOREACH inside")
+	}
+	if cmds[3].Cmd != "web.Render" {
+		t.Errorf("Expected the route to go on after END, got %s", cmds[3].Cmd)
+	}
+	if cmds[4].ForEach.Parallel != 0 || !cmds[5].IsParallel() {
+		t.Errorf("Expected PARALLEL DOES after FOREACH to start a block")
+	}
+
+	dev := ForProfile(h.(Registry), "").Routes()[0].Commands[1]
+	if len(dev.Steps) != 2 {
+		t.Errorf("Expected ForProfile to filter steps, got %d", len(dev.Steps))
+	}
+
+	bad := []string{
+		`PARALLEL DOES a a END`,
+		`ROUTE a PARALLEL END`,
+		`ROUTE a PARALLEL DOES «b» b`,
+		`ROUTE a PARALLEL DOES «b» b DOES «c» b END`,
+		`ROUTE a PARALLEL DOES «b» b INCLUDES c END`,
+		`ROUTE a PARALLEL DOES «b» b STOP END`,
+		`ROUTE a PARALLEL DOES «b» b ROUTE c`,
+		`ROUTE a PARALLEL PARALLEL DOES «b» b END END`,
+		`ROUTE a PARALLEL x y DOES «b» b END`,
+		`ROUTE a PARALLEL DOES «b» b END x`,
+		`ROUTE a PARALLEL DOES «b» b END USING c`,
+		`ROUTE a DOES «b» b FOREACH x IN cxt:xs PARALLEL 0`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseRetry(t *testing.T) {
+	doc := `
+ROUTE users "Users"
+	DOES db.Query rows TIMEOUT 2s RETRY 3 BACKOFF 100ms
+		USING q "SELECT 1"
+	DOES db.Ping ping
+		RETRY 1
+	DOES cache.Get hit TIMEOUT 1m30s`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	handy := h.(*handler)
+	cmds := handy.routes[0].Commands
+
+	if c := cmds[0]; c.Timeout != "2*time.Second" || c.Retries != 3 || c.Backoff != "100*time.Millisecond" {
+		t.Errorf("Expected TIMEOUT, RETRY and BACKOFF, got %q %d %q", c.Timeout, c.Retries, c.Backoff)
+	}
+	if c := cmds[1]; c.Retries != 1 || c.Timeout != "" || c.Backoff != "" {
+		t.Errorf("Expected RETRY only, got %q %d %q", c.Timeout, c.Retries, c.Backoff)
+	}
+	if cmds[2].Timeout != "1*time.Minute + 30*time.Second" {
+		t.Errorf("Expected a compound duration, got %q", cmds[2].Timeout)
+	}
+	if !handy.hasImport("`time`") {
+		t.Errorf("Expected time to be imported")
+	}
+
+	bad := []string{
+		`ROUTE a TIMEOUT 2s`,
+		`ROUTE a DOES «b» b TIMEOUT`,
+		`ROUTE a DOES «b» b TIMEOUT 2`,
+		`ROUTE a DOES «b» b TIMEOUT 0s`,
+		`ROUTE a DOES «b» b TIMEOUT «2s»`,
+		`ROUTE a DOES «b» b TIMEOUT 1s TIMEOUT 2s`,
+		`ROUTE a DOES «b» b RETRY 0`,
+		`ROUTE a DOES «b» b RETRY x`,
+		`ROUTE a DOES «b» b RETRY USING c`,
+		`ROUTE a DOES «b» b BACKOFF 1s`,
+		`ROUTE a DOES «b» b RETRY 2 BACKOFF 2`,
+		`ROUTE a PARALLEL DOES «b» b END RETRY 2`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseInlineIncludes(t *testing.T) {
+	doc := `
+ROUTE common.auth "Authenticate"
+	WITH realm "users"
+	ON ERROR REROUTE @401
+	DOES auth.Basic user
+		USING realm
+		USING header FROM header:Authorization
+	DOES auth.Audit audit
+
+ROUTE admin "Admin"
+	IF profile prod
+		INCLUDES common.auth WITH realm "admin" WITH header FROM header:X-Admin
+	END
+	DOES admin.Index index
+
+ROUTE other "Other"
+	INCLUDES common.auth`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	routes := h.(*handler).routes
+
+	cmds := routes[1].Commands
+	if len(cmds) != 3 {
+		t.Fatalf("Expected 3 commands after inlining, got %d", len(cmds))
+	}
+	user := cmds[0]
+	if user.IsIncludes() || user.Name != "`user`" || user.Inlined != "common.auth" {
+		t.Errorf("Expected the inlined user command, got %q from %q", user.Name, user.Inlined)
+	}
+	if user.Params[0].Name != "`realm`" || user.Params[0].DefaultVal != "`admin`" {
+		t.Errorf("Expected realm to be overridden, got %q", user.Params[0].DefaultVal)
+	}
+	if from := user.Params[1].From; len(from) != 1 || from[0] != "`header:X-Admin`" {
+		t.Errorf("Expected header to be overridden, got %v", from)
+	}
+	if user.OnError != "`@401`" {
+		t.Errorf("Expected the included route's error policy, got %q", user.OnError)
+	}
+	// The WITH default that audit gets from common.auth is overridden too.
+	if p := cmds[1].Params; len(p) != 1 || p[0].Name != "`realm`" || p[0].DefaultVal != "`admin`" {
+		t.Errorf("Expected audit to get an overridden realm, got %v", p)
+	}
+	if p := cmds[1].Profiles; len(p) != 1 || p[0] != "prod" {
+		t.Errorf("Expected inlined commands to keep the INCLUDES' profiles, got %v", p)
+	}
+	if cmds[2].Inlined != "" {
+		t.Errorf("Expected the route's own DOES not to be inlined")
+	}
+
+	// The original route and a plain INCLUDES are left alone.
+	if p := routes[0].Commands[0].Params[0]; p.DefaultVal != "" {
+		t.Errorf("Expected common.auth to be unchanged, got %q", p.DefaultVal)
+	}
+	if c := routes[2].Commands[0]; !c.IsIncludes() {
+		t.Errorf("Expected a plain INCLUDES to stay an INCLUDES")
+	}
+
+	bad := []string{
+		`ROUTE a DOES «b» b USING x ROUTE c INCLUDES a WITH y 1`,
+		`ROUTE c INCLUDES nope WITH y 1`,
+		`ROUTE c INCLUDES WITH y 1`,
+		`ROUTE a INCLUDES c WITH x 1 ROUTE c INCLUDES a WITH x 1`,
+		`ROUTE a DOES «b» b USING x ROUTE c DOES «b» b INCLUDES a WITH x 1`,
+		`ROUTE a DOES «b» b USING x ROUTE c INCLUDES a IF profile dev WITH x 1 END`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseHTTPRoutes(t *testing.T) {
+	doc := `
+ROUTE GET "/users/{id}/posts/{post}" "A user's post"
+	WITH user FROM path:id
+	DOES show.Post post
+		USING post FROM query:post path:post
+	DOES show.Log log IF path:id
+ROUTE POST "/users" "Create a user"
+ROUTE GET "Not a path"
+NAMESPACE admin
+	ROUTE DELETE "/users/*"
+END`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	routes := h.(*handler).routes
+
+	r := routes[0]
+	if r.Name != "`GET /users/*/posts/*`" || r.Method != "GET" || r.Path != "/users/{id}/posts/{post}" {
+		t.Errorf("Unexpected HTTP route %s %s %s", r.Name, r.Method, r.Path)
+	}
+	if r.Description != "`A user's post`" {
+		t.Errorf("Expected a description, got %s", r.Description)
+	}
+	if len(r.PathParams) != 2 || r.PathParams[0] != "id" || r.PathParams[1] != "post" {
+		t.Errorf("Expected placeholders id and post, got %v", r.PathParams)
+	}
+	post := r.Commands[0]
+	if from := post.Params[0].From; from[0] != "`query:post`" || from[1] != "`path:4`" {
+		t.Errorf("Expected path:post to become path:4, got %v", from)
+	}
+	if from := post.Params[1].From; from[0] != "`path:2`" {
+		t.Errorf("Expected the WITH's path:id to become path:2, got %v", from)
+	}
+	if c := r.Commands[1].Condition; c != "`path:2`" {
+		t.Errorf("Expected IF path:id to become path:2, got %s", c)
+	}
+
+	if routes[1].Name != "`POST /users`" {
+		t.Errorf("Expected POST /users, got %s", routes[1].Name)
+	}
+	if r := routes[2]; r.IsHTTP() || r.Name != "`GET`" || r.Description != "`Not a path`" {
+		t.Errorf("Expected a plain route named GET, got %s %s", r.Name, r.Description)
+	}
+	if r := routes[3]; r.Name != "`DELETE /users/*`" || r.Namespace != "admin" {
+		t.Errorf("Expected HTTP route names not to be namespaced, got %s", r.Name)
+	}
+
+	bad := []string{
+		`ROUTE GTE "/users"`,
+		`ROUTE GET "/users/{id}/{id}"`,
+		`ROUTE GET "/users//x"`,
+		`ROUTE GET "/users/{id"`,
+		`ROUTE GET "/users/x{id}"`,
+		`ROUTE GET "/users" "desc" "more"`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	doc := `
+IMPORT time
+CONTEXT files []string "The CODL files"
+CONTEXT runner.Args «map[string]string»
+CONTEXT retries int64 "How often to retry" DEFAULT 3
+CONTEXT wait time.Duration DEFAULT 2s
+CONTEXT greeting string DEFAULT "hello"
+ROUTE a "A"
+	DOES «x» x`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	handy := h.(*handler)
+	keys := handy.Contexts()
+	if len(keys) != 5 {
+		t.Fatalf("Expected 5 CONTEXT keys, got %d", len(keys))
+	}
+	if k := keys[0]; k.Name != "`files`" || k.Type != "[]string" || k.Description != "`The CODL files`" || k.Default != "" {
+		t.Errorf("Unexpected key %v", k)
+	}
+	if k := keys[1]; k.Type != "map[string]string" || k.Func() != "RunnerArgs" {
+		t.Errorf("Expected a literal type and RunnerArgs, got %s %s", k.Type, k.Func())
+	}
+	if k := keys[2]; k.Default != "3" || k.Description != "`How often to retry`" || conversion(k) != "int64(3)" {
+		t.Errorf("Expected a typed DEFAULT, got %q", k.Default)
+	}
+	if k := keys[3]; k.Default != "2*time.Second" {
+		t.Errorf("Expected a duration DEFAULT, got %q", k.Default)
+	}
+	if k := keys[4]; k.Default != "`hello`" {
+		t.Errorf("Expected a string DEFAULT, got %q", k.Default)
+	}
+	if len(handy.routes) != 1 || len(handy.routes[0].Commands) != 1 {
+		t.Errorf("Expected the ROUTE after CONTEXT to parse normally")
+	}
+
+	bad := []string{
+		`CONTEXT files`,
+		`CONTEXT «files» []string`,
+		`CONTEXT 1files []string`,
+		`CONTEXT files []string DEFAULT`,
+		`CONTEXT files DEFAULT x`,
+		`CONTEXT files []string "a" "b"`,
+		`CONTEXT files []string DEFAULT a b`,
+		`CONTEXT a.b int CONTEXT a_b int`,
+		`IF profile dev CONTEXT a int END`,
+		`ROUTE a DOES «x» x DEFAULT 1`,
+		`CONTEXT a int DOES «x» x`,
+		`@tag x CONTEXT a int`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseDatasource(t *testing.T) {
+	doc := `
+IMPORT example.com/config
+DATASOURCE cfg «config.NewSource()»
+IF profile dev
+	DATASOURCE db «db.Mock()»
+END
+DATASOURCE db db.Open()
+ROUTE a "A"
+	DOES «x» x
+		USING port FROM cfg:port`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	handy := h.(*handler)
+	ds := handy.Datasources()
+	if len(ds) != 3 {
+		t.Fatalf("Expected 3 datasources, got %d", len(ds))
+	}
+	if d := ds[0]; d.Name != "`cfg`" || d.Source != "config.NewSource()" {
+		t.Errorf("Unexpected datasource %s %s", d.Name, d.Source)
+	}
+	if d := ds[1]; len(d.Profiles) != 1 || d.Profiles[0] != "dev" {
+		t.Errorf("Expected the dev profile, got %v", d.Profiles)
+	}
+	if d := ds[2]; d.Source != "db.Open()" {
+		t.Errorf("Expected a bare word source, got %s", d.Source)
+	}
+	if len(handy.routes) != 1 {
+		t.Errorf("Expected the ROUTE after DATASOURCE to parse normally")
+	}
+
+	bad := []string{
+		`DATASOURCE cfg`,
+		`DATASOURCE «cfg» x`,
+		`DATASOURCE cxt x`,
+		`DATASOURCE a:b x`,
+		`DATASOURCE cfg a b`,
+		`DATASOURCE cfg a DATASOURCE cfg b`,
+		`DATASOURCE cfg a DOES «x» x`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseApp(t *testing.T) {
+	doc := `
+APP tool "A tool" "Does things." MAIN
+FLAGS run
+	v bool false "Verbose"
+	n int 3 "Count"
+	wait duration 2s "Wait"
+	name string "" "Name"
+	ratio float64 1 "Ratio"
+SUBCOMMAND run "Runs it"
+	DOES «x» x
+		USING v FROM cxt:v
+SUBCOMMAND again "Runs again" FLAGS run
+	DOES «y» y`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	handy := h.(*handler)
+	app := handy.Application()
+	if app == nil || app.Name != "`tool`" || app.Summary != "`A tool`" || app.Description != "`Does things.`" || !app.Main {
+		t.Fatalf("Unexpected APP %v", app)
+	}
+
+	sets := handy.FlagSets()
+	if len(sets) != 2 || sets[0].Name != "run" || sets[1].Name != "tool" {
+		t.Fatalf("Expected flag sets run and tool, got %v", sets)
+	}
+	expects := []string{"h bool false", "v bool false", "n int 3", "wait duration 2*time.Second", `name string ""`, "ratio float64 1"}
+	if len(sets[0].Flags) != len(expects) {
+		t.Fatalf("Expected %d flags, got %d", len(expects), len(sets[0].Flags))
+	}
+	for i, expect := range expects {
+		f := sets[0].Flags[i]
+		if got := Unquote(f.Name) + " " + f.Type + " " + f.Default; got != expect {
+			t.Errorf("Expected flag %q, got %q", expect, got)
+		}
+	}
+
+	for _, r := range handy.routes {
+		if !r.Subcommand || len(r.Commands) != 3 {
+			t.Fatalf("Expected SUBCOMMAND %s to have 3 commands", r.Name)
+		}
+		parse, help := r.Commands[0], r.Commands[1]
+		if parse.Cmd != "cli.ParseArgs" || parse.Params[2].DefaultVal != "runFlags" {
+			t.Errorf("Expected %s to parse runFlags, got %s %v", r.Name, parse.Cmd, parse.Params[2])
+		}
+		if help.Cmd != "cli.ShowHelp" || help.Params[1].DefaultVal != r.Description {
+			t.Errorf("Expected %s to show help, got %s %v", r.Name, help.Cmd, help.Params[1])
+		}
+	}
+
+	bad := []string{
+		`APP tool`,
+		`APP tool "A" APP other "B"`,
+		`APP tool "A" "B" "C"`,
+		`APP tool "A" MAIN "B"`,
+		`FLAGS`,
+		`FLAGS run v`,
+		`FLAGS run v bool false`,
+		`FLAGS run v byte 1 "V"`,
+		`FLAGS run v bool 1 "V"`,
+		`FLAGS run n uint -1 "N"`,
+		`FLAGS run v bool false "V" v bool true "V"`,
+		`FLAGS run FLAGS run`,
+		`IF profile dev FLAGS run END`,
+		`SUBCOMMAND run "Run"`,
+		`FLAGS run SUBCOMMAND run "Run" FLAGS`,
+		`FLAGS run SUBCOMMAND again "Run" FLAGS walk`,
+		`FLAGS run SUBCOMMAND again "Run" FLAGS run FLAGS run`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseGo(t *testing.T) {
+	doc := `
+IMPORT strings
+GO «
+import (
+	"strings"
+	"strconv"
+	str "strings"
+)
+
+// upper is used by the routes.
+func upper(s string) string { return strings.ToUpper(s) + strconv.Itoa(len(str.TrimSpace(s))) }
+»
+IF profile dev
+	GO «import "os"
+var debug = os.Getenv("DEBUG")»
+END
+ROUTE a "A"
+	DOES «x» x
+		USING s «upper("a")»`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	handy := h.(*handler)
+	blocks := handy.GoBlocks()
+	if len(blocks) != 2 {
+		t.Fatalf("Expected 2 GO blocks, got %d", len(blocks))
+	}
+	expect := "// upper is used by the routes.\nfunc upper(s string) string { return strings.ToUpper(s) + strconv.Itoa(len(str.TrimSpace(s))) }"
+	if blocks[0].Code != expect {
+		t.Errorf("Expected the imports to be removed, got %q", blocks[0].Code)
+	}
+	if b := blocks[1]; b.Code != `var debug = os.Getenv("DEBUG")` || len(b.Profiles) != 1 || b.Profiles[0] != "dev" {
+		t.Errorf("Unexpected dev block %q %v", b.Code, b.Profiles)
+	}
+
+	imports := strings.Join(handy.Imports(), " ")
+	if imports != "`strings` `strconv` str `strings` `os`" {
+		t.Errorf("Expected the imports to be merged, got %s", imports)
+	}
+	if conds := handy.importProfiles(); len(conds[3]) != 1 || conds[3][0] != "dev" {
+		t.Errorf("Expected os to be imported for dev, got %v", conds)
+	}
+	if len(handy.routes) != 1 {
+		t.Errorf("Expected the ROUTE after GO to parse normally")
+	}
+
+	_, err = Parse(v2("GO «\nvar a = 1\nfunc b( {}»"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected an error on line 3, got %v", err)
+	}
+
+	bad := []string{
+		`GO`,
+		`GO "var a = 1"`,
+		`GO «var a = 1» «var b = 2»`,
+		`GO «package foo»`,
+		`GO «a := 1»`,
+		`GO GO «var a = 1»`,
+		`ROUTE a "A" DOES «x» x GO`,
+		`ROUTE a "A" GO «var y = 1» STOP`,
+		`ROUTE a "A" GO «var y = 1» REROUTE a`,
+		`ROUTE a "A" GO «var y = 1» PARALLEL`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+}
+
+func TestParseTests(t *testing.T) {
+	doc := `
+ROUTE build "Build"
+	DOES cmd.FindCodl files
+	DOES cmd.Translate created
+TEST "build finds files" ROUTE build GIVEN cxt:d "testdata" MOCK cmd.Translate RETURNS «[]string{}» EXPECT cxt:files LEN 2
+TEST "build, again"
+	ROUTE build
+	GIVEN cxt:n 3
+	MOCK cmd.FindCodl
+	EXPECT cxt:created true`
+
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	tests := h.(*handler).Tests()
+	if len(tests) != 2 {
+		t.Fatalf("Expected 2 tests, got %d", len(tests))
+	}
+
+	a := tests[0]
+	if a.Func() != "TestBuildFindsFiles" || a.Route != "`build`" {
+		t.Errorf("Unexpected test %s of %s", a.Func(), a.Route)
+	}
+	if len(a.Given) != 1 || a.Given[0].Key != "`d`" || a.Given[0].Value != "`testdata`" {
+		t.Errorf("Unexpected GIVEN %v", a.Given)
+	}
+	if len(a.Mocks) != 1 || a.Mocks[0].Cmd != "cmd.Translate" || a.Mocks[0].Returns != "[]string{}" {
+		t.Errorf("Unexpected MOCK %v", a.Mocks)
+	}
+	if len(a.Expect) != 1 || !a.Expect[0].Len || a.Expect[0].Key != "`files`" || a.Expect[0].Value != "2" {
+		t.Errorf("Unexpected EXPECT %v", a.Expect)
+	}
+
+	b := tests[1]
+	if b.Func() != "TestBuildAgain" {
+		t.Errorf("Expected TestBuildAgain, got %s", b.Func())
+	}
+	if b.Given[0].Value != "3" || b.Mocks[0].Returns != "" || b.Expect[0].Len || b.Expect[0].Value != "true" {
+		t.Errorf("Expected typed values and a MOCK without RETURNS, got %v %v %v", b.Given[0], b.Mocks[0], b.Expect[0])
+	}
+
+	bad := []string{
+		`TEST`,
+		`TEST "a"`,
+		`ROUTE a TEST "a" ROUTE`,
+		`ROUTE a TEST "a" ROUTE b`,
+		`ROUTE a TEST «a» ROUTE a`,
+		`ROUTE a TEST "a" ROUTE a GIVEN`,
+		`ROUTE a TEST "a" ROUTE a GIVEN cxt:d`,
+		`ROUTE a TEST "a" ROUTE a GIVEN d "x"`,
+		`ROUTE a DOES «x» x TEST "a" ROUTE a MOCK «x» RETURNS`,
+		`ROUTE a DOES «x» x TEST "a" ROUTE a MOCK «y»`,
+		`ROUTE a TEST "a" ROUTE a EXPECT cxt:d LEN`,
+		`ROUTE a TEST "a" ROUTE a EXPECT cxt:d LEN x`,
+		`ROUTE a TEST "a" ROUTE a "extra"`,
+		`ROUTE a TEST "a b" ROUTE a TEST "a, b" ROUTE a`,
+		`ROUTE a GIVEN cxt:d "x"`,
+		`ROUTE a TEST "a" GIVEN cxt:d "x"`,
+		`ROUTE a IF profile dev TEST "a" ROUTE a END`,
+		`SUBCOMMAND a FLAGS a TEST "a" SUBCOMMAND a`,
+	}
+	for _, doc := range bad {
+		if _, err := Parse(v2(doc)); err == nil {
+			t.Errorf("Expected error parsing %q", doc)
+		}
+	}
+
+	follow := []string{
+		`ROUTE a TEST "t" ROUTE a STOP`,
+		`ROUTE a TEST "t" ROUTE a PARALLEL`,
+		`ROUTE a TEST "t" ROUTE a EXPECT cxt:y 1 REROUTE a`,
+	}
+	for _, doc := range follow {
+		_, err := Parse(v2(doc))
+		if err == nil || !strings.Contains(err.Error(), "cannot follow a TEST") {
+			t.Errorf("Expected a TEST error parsing %q, got %v", doc, err)
+		}
+	}
+}
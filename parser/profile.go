@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A profile name may be negated with '!', and several names may be joined
+// with commas: "dev", "!prod", "dev,test".
+var profileRe = regexp.MustCompile(`^!?[A-Za-z0-9_.]+(,[A-Za-z0-9_.]+)*$`)
+
+func validProfile(name string) bool {
+	return profileRe.MatchString(name)
+}
+
+// MatchProfile reports whether a set of IF conditions holds for a profile.
+//
+// All conditions must hold. The empty profile is the default build, and
+// only satisfies negated conditions.
+func MatchProfile(conds []string, profile string) bool {
+	for _, c := range conds {
+		neg := strings.HasPrefix(c, "!")
+		found := false
+		for _, name := range strings.Split(strings.TrimPrefix(c, "!"), ",") {
+			if name == profile {
+				found = true
+				break
+			}
+		}
+		if found == neg {
+			return false
+		}
+	}
+	return true
+}
+
+// Profiles lists every profile name mentioned in a registry, sorted.
+func Profiles(reg Registry) []string {
+	seen := map[string]bool{}
+	add := func(conds []string) {
+		for _, c := range conds {
+			for _, name := range strings.Split(strings.TrimPrefix(c, "!"), ",") {
+				seen[name] = true
+			}
+		}
+	}
+	if ci, ok := reg.(conditionalImports); ok {
+		for _, conds := range ci.importProfiles() {
+			add(conds)
+		}
+	}
+	var addCommands func(cmds []*Command)
+	addCommands = func(cmds []*Command) {
+		for _, c := range cmds {
+			add(c.Profiles)
+			for _, u := range c.Params {
+				add(u.Profiles)
+			}
+			addCommands(c.Steps)
+		}
+	}
+	for _, r := range reg.Routes() {
+		add(r.Profiles)
+		addCommands(r.Commands)
+	}
+	for _, d := range reg.Datasources() {
+		add(d.Profiles)
+	}
+	for _, b := range reg.GoBlocks() {
+		add(b.Profiles)
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForProfile returns a view of a registry that contains only the imports,
+// datasources, GO blocks, routes, commands and parameters whose IF
+// conditions hold for the given profile.
+func ForProfile(reg Registry, profile string) Registry {
+	return &profileRegistry{Registry: reg, profile: profile}
+}
+
+// conditionalImports is implemented by registries that track IF conditions
+// on their imports.
+type conditionalImports interface {
+	importProfiles() [][]string
+}
+
+type profileRegistry struct {
+	Registry
+	profile string
+}
+
+func (p *profileRegistry) Imports() []string {
+	all := p.Registry.Imports()
+	ci, ok := p.Registry.(conditionalImports)
+	if !ok {
+		return all
+	}
+	conds := ci.importProfiles()
+	imports := []string{}
+	for i, imp := range all {
+		if i < len(conds) && !MatchProfile(conds[i], p.profile) {
+			continue
+		}
+		imports = append(imports, imp)
+	}
+	return imports
+}
+
+func (p *profileRegistry) Datasources() []*Datasource {
+	datasources := []*Datasource{}
+	for _, d := range p.Registry.Datasources() {
+		if MatchProfile(d.Profiles, p.profile) {
+			datasources = append(datasources, d)
+		}
+	}
+	return datasources
+}
+
+func (p *profileRegistry) GoBlocks() []*GoBlock {
+	blocks := []*GoBlock{}
+	for _, b := range p.Registry.GoBlocks() {
+		if MatchProfile(b.Profiles, p.profile) {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+func (p *profileRegistry) Routes() []*Route {
+	routes := []*Route{}
+	for _, r := range p.Registry.Routes() {
+		if !MatchProfile(r.Profiles, p.profile) {
+			continue
+		}
+		nr := *r
+		nr.Commands = p.commands(r.Commands)
+		routes = append(routes, &nr)
+	}
+	return routes
+}
+
+// commands filters a list of commands, along with their parameters and the
+// steps of any PARALLEL blocks. A PARALLEL block that loses all of its steps
+// is dropped.
+func (p *profileRegistry) commands(cmds []*Command) []*Command {
+	filtered := []*Command{}
+	for _, c := range cmds {
+		if !MatchProfile(c.Profiles, p.profile) {
+			continue
+		}
+		nc := *c
+		nc.Params = []*Using{}
+		for _, u := range c.Params {
+			if MatchProfile(u.Profiles, p.profile) {
+				nc.Params = append(nc.Params, u)
+			}
+		}
+		if c.IsParallel() {
+			nc.Steps = p.commands(c.Steps)
+			if len(nc.Steps) == 0 {
+				continue
+			}
+		}
+		filtered = append(filtered, &nc)
+	}
+	return filtered
+}
@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestMatchProfile(t *testing.T) {
+	tests := []struct {
+		conds []string
+		profile string
+		expect bool
+	}{
+		{nil, "", true},
+		{nil, "dev", true},
+		{[]string{"dev"}, "dev", true},
+		{[]string{"dev"}, "", false},
+		{[]string{"dev"}, "prod", false},
+		{[]string{"!prod"}, "", true},
+		{[]string{"!prod"}, "prod", false},
+		{[]string{"dev,test"}, "test", true},
+		{[]string{"!dev,test"}, "test", false},
+		{[]string{"dev", "!prod"}, "dev", true},
+	}
+
+	for _, tt := range tests {
+		if MatchProfile(tt.conds, tt.profile) != tt.expect {
+			t.Errorf("Expected %v for %v with profile %q", tt.expect, tt.conds, tt.profile)
+		}
+	}
+}
+
+func TestForProfile(t *testing.T) {
+	doc := `
+IMPORT example.com/always
+IF profile dev IMPORT example.com/devtools END
+
+ROUTE a "A"
+	DOES «foo.Bar» bar
+		IF profile dev USING debug «true» END
+	IF profile dev DOES «devtools.Dump» dump END
+IF profile prod
+	ROUTE b "B"
+END`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	reg := h.(Registry)
+
+	profiles := Profiles(reg)
+	if len(profiles) != 2 || profiles[0] != "dev" || profiles[1] != "prod" {
+		t.Errorf("Expected [dev prod], got %v", profiles)
+	}
+
+	dev := ForProfile(reg, "dev")
+	if len(dev.Imports()) != 2 {
+		t.Errorf("Expected 2 dev imports, got %v", dev.Imports())
+	}
+	if len(dev.Routes()) != 1 || len(dev.Routes()[0].Commands) != 2 {
+		t.Errorf("Expected dev to have route a with 2 commands")
+	}
+	if len(dev.Routes()[0].Commands[0].Params) != 1 {
+		t.Errorf("Expected dev to have the debug param")
+	}
+
+	def := ForProfile(reg, "")
+	if len(def.Imports()) != 1 {
+		t.Errorf("Expected 1 default import, got %v", def.Imports())
+	}
+	routes := def.Routes()
+	if len(routes) != 1 || len(routes[0].Commands) != 1 || len(routes[0].Commands[0].Params) != 0 {
+		t.Errorf("Expected default profile to drop all dev blocks")
+	}
+
+	// The original registry is untouched.
+	if len(reg.Routes()[0].Commands) != 2 {
+		t.Errorf("ForProfile modified the underlying registry")
+	}
+
+	if len(ForProfile(reg, "prod").Routes()) != 2 {
+		t.Errorf("Expected prod to have both routes")
+	}
+}
@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Timeout starts a TIMEOUT on the current DOES. The next word is a duration.
+func (l *handler) Timeout() {
+	l.modify("TIMEOUT")
+}
+
+// Retry starts a RETRY on the current DOES. The next word is a number.
+func (l *handler) Retry() {
+	l.modify("RETRY")
+}
+
+// Backoff starts a BACKOFF on the current DOES. The next word is a
+// duration.
+func (l *handler) Backoff() {
+	l.modify("BACKOFF")
+}
+
+func (l *handler) modify(keyword string) {
+	if !l.unannotated(keyword) {
+		return
+	}
+	c := l.currentDoes()
+	if c == nil {
+		l.err = fmt.Errorf("%s must come after a DOES", keyword)
+		return
+	}
+	var set bool
+	switch keyword {
+	case "TIMEOUT":
+		set = c.Timeout != ""
+	case "RETRY":
+		set = c.Retries > 0
+	case "BACKOFF":
+		if c.Retries == 0 {
+			l.err = fmt.Errorf("BACKOFF must come after RETRY")
+			return
+		}
+		set = c.Backoff != ""
+	}
+	if set {
+		l.err = fmt.Errorf("DOES %s can only have one %s", c.Name, keyword)
+		return
+	}
+	l.modifier = keyword
+}
+
+// modifierWord handles the value of a TIMEOUT, RETRY or BACKOFF.
+func (l *handler) modifierWord(word string) {
+	c := l.currentDoes()
+	keyword := l.modifier
+	l.modifier = ""
+
+	if keyword == "RETRY" {
+		n, err := strconv.Atoi(word)
+		if err != nil || n < 1 {
+			l.err = fmt.Errorf("RETRY takes a number greater than 0, not %s", word)
+			return
+		}
+		c.Retries = n
+		return
+	}
+
+	code, typ, err := scalar(word)
+	if err != nil {
+		l.err = fmt.Errorf("%s: %s", keyword, err)
+		return
+	}
+	if d, err := time.ParseDuration(word); typ != typeDuration || err != nil || d <= 0 {
+		l.err = fmt.Errorf("%s takes a duration such as 2s, not %s", keyword, word)
+		return
+	}
+	l.addImport(asString("time"))
+	if keyword == "TIMEOUT" {
+		c.Timeout = code
+	} else {
+		c.Backoff = code
+	}
+}
+
+// modifierDone reports whether a TIMEOUT, RETRY or BACKOFF has its value.
+// If not, that is an error.
+func (l *handler) modifierDone() bool {
+	if l.modifier == "" {
+		return true
+	}
+	l.err = fmt.Errorf("%s requires a value", l.modifier)
+	return false
+}
@@ -1,39 +1,158 @@
 package parser
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/Masterminds/sprig"
 	"io"
+	"strconv"
+	"strings"
 	"text/template"
 )
 
-const bodyTpl = `package {{.Package}}
+// bodyTpl is the code of a generated file, and fileTpl is the whole file,
+// with the support package imported only if the code uses it.
+const bodyTpl = `{{range .Registry.GoBlocks}}{{if not .Shared}}
+{{.Code}}
+{{end}}{{end}}{{range .Registry.FlagSets}}{{if not .Shared}}
+var {{.Var}} = func() *flag.FlagSet {
+	flags := flag.NewFlagSet({{goString .Name}}, flag.PanicOnError)
+{{range .Flags}}	flags.{{.Func}}({{.Name}}, {{.Default}}, {{.Help}})
+{{end}}	return flags
+}()
+{{end}}{{end}}
+func {{.Name | title }}Routes(reg *cookoo.Registry) {
+	{{range .Registry.Routes}}{{template "route" .}}
+	{{end}}
+}
+{{with .Registry.Datasources}}
+// {{$.Name | title }}Datasources adds each DATASOURCE to cxt.
+func {{$.Name | title }}Datasources(cxt cookoo.Context) {
+{{range .}}	cxt.AddDatasource({{.Name}}, {{.Source}})
+{{end}}}
+{{end}}{{with .Registry.Application}}
+// {{$.Name | title }}Run registers the routes and runs the subcommand that the
+// command line names.
+func {{$.Name | title }}Run(reg *cookoo.Registry, router *cookoo.Router, cxt cookoo.Context) error {
+	cxt.Put("router", router)
+{{if $.Registry.Datasources}}	{{$.Name | title }}Datasources(cxt)
+{{end}}{{if $.Registry.Contexts}}	{{$.Name | title }}Context(cxt)
+{{end}}	{{$.Name | title }}Routes(reg)
+	return cli.New(reg, router, cxt).Help({{.Summary}}, {{or .Description .Summary}}, {{.FlagsVar}}).RunSubcommand()
+}
+{{if and .Main (not .Shared)}}
+func main() {
+	reg, router, cxt := cookoo.Cookoo()
+	if err := {{$.Name | title }}Run(reg, router, cxt); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+{{end}}{{end}}{{with .Registry.Routes}}
+// {{$.Name | title }}RouteMeta describes each route in {{$.Name | title }}Routes.
+var {{$.Name | title }}RouteMeta = map[string]support.RouteMeta{
+{{range . | lastRoutes}}	{{.Name}}: { {{- if .Description}}Description: {{.Description}}, {{end}}{{template "meta" .Annotations}}{{if .Commands | annotated}}
+		Commands: map[string]support.CommandMeta{
+{{range .Commands}}{{if .Annotations}}			{{.Name}}: { {{- template "meta" .Annotations}}},
+{{end}}{{end}}		},
+	{{end}}},
+{{end}}}
+{{end}}{{with .Registry.Contexts}}
+// {{$.Name | title }}Context puts the DEFAULT of each CONTEXT key into cxt.
+func {{$.Name | title }}Context(cxt cookoo.Context) {
+{{range .}}{{if .Default}}	cxt.Put({{.Name}}, {{conversion .}})
+{{end}}{{end}}}
+{{range .}}{{if not .Shared}}
+// Get{{.Func}} returns the {{unquote .Name}} context value{{with .Description}}, {{comment .}}{{end}}.
+// It returns the zero value if the key is missing or has another type.
+func Get{{.Func}}(c cookoo.Context) {{.Type}} {
+	v, _ := c.Get({{.Name}}, nil).({{.Type}})
+	return v
+}
+
+// Set{{.Func}} sets the {{unquote .Name}} context value.
+func Set{{.Func}}(c cookoo.Context, v {{.Type}}) {
+	c.Put({{.Name}}, v)
+}
+{{end}}{{end}}{{end}}`
+
+const fileTpl = `{{if .Constraint}}//go:build {{.Constraint}}
+
+{{end}}package {{.Package}}
 
 // This file is auto-generated by Codl.
 
 import (
 	"github.com/Masterminds/cookoo"
-	{{range .Registry.Imports}}{{.}}
+	{{if .Support}}"github.com/Masterminds/codl/support"
+	{{end}}{{range .Imports}}{{.}}
 	{{end}}
 )
+{{.Body}}`
 
-func {{.Name | title }}Routes(reg *cookoo.Registry) {
-	{{range .Registry.Routes}}reg.Route({{.Name}}, {{.Description}}){{range .Commands}}.
-{{if .IsIncludes }}	Includes({{.Name}})
-{{else}}	Does({{.Cmd}}, {{.Name}}){{range .Params}}.
-			Using({{.Name}}){{if .DefaultVal}}.WithDefault({{.DefaultVal}}){{end}}{{if .From}}.From({{.From | join ", "}}){{end}}{{end}}{{end}}{{end}}
+const routeTpl = `reg.Route({{.Name}}, {{.Description}}){{if .Annotations.IsDeprecated}}.
+	Does(support.Deprecated({{.Name}}, {{goString .Annotations.Deprecated}}), `+"`@deprecated`"+`){{end}}{{range .Commands}}.
+{{with .Inlined}}	// inlined from {{.}}
+{{end}}{{if .IsIncludes }}	Includes({{.Name}})
+{{else}}	Does({{command .}}, {{.Name}}){{range .Params}}.
+			Using({{.Name}}){{if .DefaultVal}}.WithDefault({{.DefaultVal}}){{end}}{{if .From}}.From({{.From | join ", "}}){{end}}{{end}}{{end}}{{end}}`
+
+// testsTpl is the body of a test file, and testFileTpl is the whole file,
+// with only the imports that the body uses.
+const testsTpl = `{{range .Tests}}
+// {{.Func}} runs the TEST {{comment .Name}}.
+func {{.Func}}(t *testing.T) {
+	reg, router, cxt := cookoo.Cookoo()
+{{if $.Registry.Datasources}}	{{$.Name | title }}Datasources(cxt)
+{{end}}{{if $.Registry.Contexts}}	{{$.Name | title }}Context(cxt)
+{{end}}	{{$.Name | title }}Routes(reg)
+{{range .Mocked}}	{{template "route" .}}
+{{end}}{{with .Flags}}	support.ResetFlags({{.Var}})
+{{end}}{{with .Args}}	cxt.Put("runner.Args", []string{ {{- . | join ", "}}})
+{{end}}{{range .Given}}	cxt.Put({{.Key}}, {{.Value}})
+{{end}}
+	if err := router.HandleRequest({{.Route}}, cxt, false); err != nil {
+		t.Fatalf("Route %s failed: %s", {{.Route}}, err)
+	}
+{{range .Expect}}	if err := support.{{if .Len}}CheckLen{{else}}CheckValue{{end}}(cxt, {{.Key}}, {{.Value}}); err != nil {
+		t.Error(err)
+	}
+{{end}}}
+{{end}}`
+
+const testFileTpl = `package {{.Package}}
+
+// This file is auto-generated by Codl from the TEST statements in {{.Name}}.codl.
+
+import (
+	"testing"
+	"github.com/Masterminds/cookoo"
+	{{range .Imports}}{{.}}
 	{{end}}
-}
-`
+)
+{{.Body}}`
+
+const metaTpl = `{{with .Tags}}Tags: {{goStrings .}}, {{end -}}
+{{with .Owner}}Owner: {{goString .}}, {{end -}}
+{{if .IsDeprecated}}Deprecated: {{goString .Deprecated}}, {{end -}}
+{{if .Hidden}}Hidden: true, {{end -}}`
 
 type Registry interface {
 	Routes() []*Route
 	Imports() []string
+	Contexts() []*ContextKey
+	Datasources() []*Datasource
+	FlagSets() []*FlagSet
+	Application() *App
+	GoBlocks() []*GoBlock
+	Tests() []*TestSpec
 }
 
 type serializerContext struct {
 	Registry Registry
 	Package string
 	Name string
+	Constraint string
 }
 
 type Serializer struct {
@@ -42,6 +161,7 @@ type Serializer struct {
 	tpl *template.Template
 	name string
 	packageName string
+	constraint string
 }
 
 // NewSerializer creates a new serializer.
@@ -55,16 +175,184 @@ func NewSerializer(name, packname string, out io.Writer, reg Registry) *Serializ
 	return s
 }
 
+// BuildConstraint sets a build constraint expression, such as "dev && !prod",
+// that is written as a //go:build line at the top of the generated file.
+func (s *Serializer) BuildConstraint(expr string) {
+	s.constraint = expr
+}
+
 func (s *Serializer) Write() error {
+	if app := s.reg.Application(); app != nil && app.Main && s.packageName != "main" {
+		return fmt.Errorf("APP %s MAIN must be in package main, not %s", Unquote(app.Name), s.packageName)
+	}
 	cxt := &serializerContext {
 		Name: s.name,
 		Registry: s.reg,
 		Package: s.packageName,
+		Constraint: s.constraint,
+	}
+	var body bytes.Buffer
+	if err := s.tpl.Execute(&body, cxt); err != nil {
+		return err
+	}
+
+	// Import support only if the code uses it, so that files that do not
+	// need it do not depend on it. The imports of GO blocks that another
+	// file declares are also left out, unless other code uses them. If the
+	// code does not parse, such as for a GO block with a mistake in it, the
+	// compiler reports that instead.
+	support := asString("github.com/Masterminds/codl/support")
+	optional := map[string]bool{ support: true }
+	for _, b := range s.reg.GoBlocks() {
+		if b.Shared {
+			for _, imp := range b.Imports {
+				optional[imp] = true
+			}
+		}
 	}
-	return s.tpl.Execute(s.out, cxt)
+	candidates := []string{}
+	for imp := range optional {
+		candidates = append(candidates, imp)
+	}
+	used, err := usedImports("package " + s.packageName + "\n" + body.String(), candidates)
+	if err != nil {
+		used = candidates
+	}
+	keep := map[string]bool{}
+	for _, imp := range used {
+		keep[imp] = true
+	}
+	imports := []string{}
+	for _, imp := range s.reg.Imports() {
+		if imp != support && (!optional[imp] || keep[imp]) {
+			imports = append(imports, imp)
+		}
+	}
+	return s.tpl.ExecuteTemplate(s.out, "file", map[string]interface{}{
+		"Package": s.packageName,
+		"Constraint": s.constraint,
+		"Support": keep[support],
+		"Imports": imports,
+		"Body": body.String(),
+	})
 }
 
 func (s *Serializer) compile() {
-	s.tpl = template.Must(template.New("body").Funcs(sprig.TxtFuncMap()).Parse(bodyTpl))
+	funcs := sprig.TxtFuncMap()
+	funcs["goString"] = strconv.Quote
+	funcs["goStrings"] = goStrings
+	funcs["annotated"] = annotated
+	funcs["command"] = command
+	funcs["conversion"] = conversion
+	funcs["unquote"] = Unquote
+	funcs["comment"] = comment
+	funcs["lastRoutes"] = lastRoutes
+
+	s.tpl = template.Must(template.New("body").Funcs(funcs).Parse(bodyTpl))
+	template.Must(s.tpl.New("file").Parse(fileTpl))
+	template.Must(s.tpl.New("meta").Parse(metaTpl))
+	template.Must(s.tpl.New("route").Parse(routeTpl))
+	template.Must(s.tpl.New("tests").Parse(testsTpl))
+	template.Must(s.tpl.New("testFile").Parse(testFileTpl))
+}
+
+// goStrings formats a list of strings as a Go []string literal.
+func goStrings(list []string) string {
+	quoted := make([]string, len(list))
+	for i, str := range list {
+		quoted[i] = strconv.Quote(str)
+	}
+	return "[]string{" + strings.Join(quoted, ", ") + "}"
+}
+
+// comment flattens a quoted string onto one line of a Go comment.
+func comment(str string) string {
+	return strings.Join(strings.Fields(Unquote(str)), " ")
+}
+
+// lastRoutes leaves out each route that a later route of the same name
+// replaces, as it does when both are registered.
+func lastRoutes(routes []*Route) []*Route {
+	last := map[string]int{}
+	for i, r := range routes {
+		last[r.Name] = i
+	}
+	list := []*Route{}
+	for i, r := range routes {
+		if last[r.Name] == i {
+			list = append(list, r)
+		}
+	}
+	return list
 }
 
+// annotated reports whether any of the commands has annotations.
+func annotated(cmds []*Command) bool {
+	for _, c := range cmds {
+		if len(c.Annotations) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// command returns the Go code for a DOES's command, wrapped in the support
+// functions that its clauses call for. From the inside out, they are
+// TIMEOUT, RETRY, FOREACH, the error clauses, and IF or UNLESS.
+func command(c *Command) string {
+	switch {
+	case c.IsReroute():
+		return "support.Reroute(" + c.Target + ")"
+	case c.IsStop():
+		return "support.Stop()"
+	}
+	code := c.Cmd
+	if c.IsParallel() {
+		code = parallel(c)
+	}
+	if c.Timeout != "" {
+		code = "support.Timeout(" + code + ", " + c.Timeout + ")"
+	}
+	if c.Retries > 0 {
+		backoff := c.Backoff
+		if backoff == "" {
+			backoff = "0"
+		}
+		code = fmt.Sprintf("support.Retry(%s, %s, %d, %s)", code, c.Name, c.Retries, backoff)
+	}
+	if l := c.ForEach; l != nil {
+		code = fmt.Sprintf("support.ForEach(%s, %s, %s, %d)", code, l.Var, l.Source, l.Parallel)
+	}
+	switch {
+	case c.IgnoreErrors:
+		code = "support.IgnoreErrors(" + code + ")"
+	case c.OnError != "":
+		code = "support.OnError(" + code + ", " + c.OnError + ")"
+	}
+	switch {
+	case c.Condition != "" && c.Unless:
+		code = "support.Unless(" + code + ", " + c.Condition + ")"
+	case c.Condition != "":
+		code = "support.When(" + code + ", " + c.Condition + ")"
+	}
+	return code
+}
+
+// parallel returns the Go code for a PARALLEL block.
+func parallel(g *Command) string {
+	code := "support.Parallel("
+	for _, step := range g.Steps {
+		code += "\n\t\tsupport.NewStep(" + command(step) + ", " + step.Name + ")"
+		for _, u := range step.Params {
+			code += ".\n\t\t\tUsing(" + u.Name + ")"
+			if u.DefaultVal != "" {
+				code += ".WithDefault(" + u.DefaultVal + ")"
+			}
+			if len(u.From) > 0 {
+				code += ".From(" + strings.Join(u.From, ", ") + ")"
+			}
+		}
+		code += ","
+	}
+	return code + "\n\t)"
+}
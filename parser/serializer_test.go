@@ -1,6 +1,13 @@
 package parser
 
 import (
+	"bytes"
+	"go/ast"
+	gparser "go/parser"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io/ioutil"
 	"os"
 	"testing"
 	"strings"
@@ -31,3 +38,649 @@ func TestSerialize(t *testing.T) {
 		t.Errorf("Failed to serialize: %s", err)
 	}
 }
+
+// A CODL 1 file generates the same routes that it did before CODL 2.
+// testdata/migrate/app.v1.go was generated then, so it is not updated.
+func TestSerializeVersion1(t *testing.T) {
+	h, err := ParseFile("testdata/migrate/app.codl")
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	var out bytes.Buffer
+	if err := NewSerializer("app", "codl", &out, h.(Registry)).Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+	golden, err := ioutil.ReadFile("testdata/migrate/app.v1.go")
+	if err != nil {
+		t.Fatalf("Failed to read the golden file: %s", err)
+	}
+
+	expect := string(golden)
+	routes := expect[strings.Index(expect, "func AppRoutes"):]
+	routes = routes[:strings.Index(routes, "\n}\n") + 3]
+	if !strings.Contains(out.String(), routes) {
+		t.Errorf("Expected the routes:\n%s\nGot:\n%s", routes, out.String())
+	}
+	imports := expect[strings.Index(expect, "import ("):strings.Index(expect, "\n)\n")]
+	for _, line := range strings.Split(imports, "\n")[1:] {
+		if !strings.Contains(out.String(), line + "\n") {
+			t.Errorf("Expected the import %s in:\n%s", strings.TrimSpace(line), out.String())
+		}
+	}
+}
+
+func TestSerializeBuildConstraint(t *testing.T) {
+	h, err := Parse(strings.NewReader(`ROUTE a "A"`))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	ser.BuildConstraint("dev && !prod")
+	if err := ser.Write(); err != nil {
+		t.Errorf("Failed to serialize: %s", err)
+	}
+
+	if !strings.HasPrefix(out.String(), "//go:build dev && !prod\n\npackage serializertest") {
+		t.Errorf("Expected a build constraint, got:\n%s", out.String())
+	}
+}
+
+func TestSerializeSupportImport(t *testing.T) {
+	h, err := Parse(v2(`CONTEXT n int "A number"`))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+	if strings.Contains(out.String(), "codl/support") || strings.Contains(out.String(), "RouteMeta") {
+		t.Errorf("Expected no support import or RouteMeta without routes:\n%s", out.String())
+	}
+
+	h, err = Parse(v2(`ROUTE a "A" DOES «foo.Bar» bar`))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	out.Reset()
+	ser = NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+	if !strings.Contains(out.String(), "\t\"github.com/Masterminds/codl/support\"\n") {
+		t.Errorf("Expected a support import for RouteMeta:\n%s", out.String())
+	}
+}
+
+func TestSerializeSharedDeclarations(t *testing.T) {
+	serialize := func(fname string) string {
+		h, err := ParseFile(fname)
+		if err != nil {
+			t.Fatalf("Surprise! Error: %s", err)
+		}
+		var out bytes.Buffer
+		if err := NewSerializer("test", "serializertest", &out, h.(Registry)).Write(); err != nil {
+			t.Fatalf("Failed to serialize %s: %s", fname, err)
+		}
+		return out.String()
+	}
+
+	decls := []string{"func shout(", "\t`strings`\n", "func GetToken(", "var baseFlags ="}
+
+	// The used file in the same directory declares these itself.
+	app := serialize("testdata/use/shared/app.codl")
+	for _, decl := range decls {
+		if strings.Contains(app, decl) {
+			t.Errorf("Expected %q to be left to the used file:\n%s", decl, app)
+		}
+	}
+	if !strings.Contains(app, "reg.Route(`ping`, `Ping`)") {
+		t.Errorf("Expected the used routes:\n%s", app)
+	}
+
+	base := serialize("testdata/use/shared/base.codl")
+	for _, decl := range decls {
+		if !strings.Contains(base, decl) {
+			t.Errorf("Expected %q in the used file:\n%s", decl, base)
+		}
+	}
+}
+
+func TestSerializeRouteMeta(t *testing.T) {
+	doc := `
+@tag api @tag users @owner "team-users"
+ROUTE users "List users"
+	@deprecated "use users.v2"
+	DOES «foo.List» list
+	DOES «foo.Render» render
+@hidden
+ROUTE @internal ""`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	expects := []string{
+		"var TestRouteMeta = map[string]support.RouteMeta{",
+		"`users`: {Description: `List users`, Tags: []string{\"api\", \"users\"}, Owner: \"team-users\", ",
+		"`list`: {Deprecated: \"use users.v2\", },",
+		"`@internal`: {Description: ``, Hidden: true, },",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(out.String(), expect) {
+			t.Errorf("Expected output to contain %q:\n%s", expect, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "`render`:") {
+		t.Errorf("Expected unannotated commands to be left out")
+	}
+}
+
+func TestSerializeRouteMetaRepeated(t *testing.T) {
+	doc := `
+ROUTE a "First"
+	DOES «foo.Bar» bar
+ROUTE b "B"
+	DOES «foo.Bar» bar
+ROUTE a "Second"
+	DOES «foo.Baz» baz`
+	h, err := Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	if strings.Count(out.String(), "`a`: {") != 1 || !strings.Contains(out.String(), "`a`: {Description: `Second`, ") {
+		t.Errorf("Expected one RouteMeta entry for the last route a:\n%s", out.String())
+	}
+}
+
+func TestSerializeDeprecatedRoute(t *testing.T) {
+	doc := `
+@deprecated "use new"
+ROUTE old "Old"
+	DOES «foo.Bar» bar`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	expect := "reg.Route(`old`, `Old`).\n\tDoes(support.Deprecated(`old`, \"use new\"), `@deprecated`).\n\tDoes(foo.Bar, `bar`)"
+	if !strings.Contains(out.String(), expect) {
+		t.Errorf("Expected deprecation command first:\n%s", out.String())
+	}
+}
+
+func TestSerializeDeprecatedInlined(t *testing.T) {
+	doc := `
+@deprecated "use new"
+ROUTE old "Old"
+	DOES «foo.Bar» bar
+		USING p «1»
+ROUTE a "A"
+	INCLUDES old WITH p «2»`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	expect := "reg.Route(`a`, `A`).\n\t// inlined from old\n\tDoes(support.Deprecated(`old`, \"use new\"), `@deprecated`).\n\t// inlined from old\n\tDoes(foo.Bar, `bar`).\n\t\t\tUsing(`p`).WithDefault(2)"
+	if !strings.Contains(out.String(), expect) {
+		t.Errorf("Expected the deprecation command before the inlined commands:\n%s", out.String())
+	}
+}
+
+func TestSerializeErrorPolicy(t *testing.T) {
+	doc := `
+ROUTE save "Save" ON ERROR REROUTE errors.show
+	DOES «db.Save» save
+	DOES «cache.Flush» flush IGNORE ERRORS
+	INCLUDES audit`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	expects := []string{
+		"Does(support.OnError(db.Save, `errors.show`), `save`)",
+		"Does(support.IgnoreErrors(cache.Flush), `flush`)",
+		"Includes(`audit`)",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(out.String(), expect) {
+			t.Errorf("Expected %s in:\n%s", expect, out.String())
+		}
+	}
+}
+
+func TestSerializeFlow(t *testing.T) {
+	doc := `
+ROUTE old "Old"
+	IF profile dev STOP END
+	REROUTE new`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	expect := "Does(support.Stop(), `@stop`).\n\tDoes(support.Reroute(`new`), `@reroute`)"
+	if !strings.Contains(out.String(), expect) {
+		t.Errorf("Expected STOP and REROUTE commands:\n%s", out.String())
+	}
+}
+
+func TestSerializeConditions(t *testing.T) {
+	doc := `
+ROUTE build "Build"
+	DOES «cli.ShowHelp» help IF cxt:h
+	DOES «cmd.Translate» created UNLESS cxt:dryRun ON ERROR REROUTE oops`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	expects := []string{
+		"Does(support.When(cli.ShowHelp, `cxt:h`), `help`)",
+		"Does(support.Unless(support.OnError(cmd.Translate, `oops`), `cxt:dryRun`), `created`)",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(out.String(), expect) {
+			t.Errorf("Expected %s in:\n%s", expect, out.String())
+		}
+	}
+}
+
+func TestSerializeForEach(t *testing.T) {
+	doc := `
+ROUTE build "Build"
+	DOES «cmd.TranslateOne» out FOREACH file IN cxt:files PARALLEL 4 IGNORE ERRORS`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	expect := "Does(support.IgnoreErrors(support.ForEach(cmd.TranslateOne, `file`, `cxt:files`, 4)), `out`)"
+	if !strings.Contains(out.String(), expect) {
+		t.Errorf("Expected %s in:\n%s", expect, out.String())
+	}
+}
+
+func TestSerializeParallel(t *testing.T) {
+	doc := `
+ROUTE dashboard "Dashboard" IGNORE ERRORS
+	PARALLEL fetch
+		DOES «db.Orders» orders
+			USING limit 10 FROM query:limit
+		DOES «cache.Stats» stats
+	END`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	expect := "Does(support.IgnoreErrors(support.Parallel(\n" +
+		"\t\tsupport.NewStep(db.Orders, `orders`).\n" +
+		"\t\t\tUsing(`limit`).WithDefault(10).From(`query:limit`),\n" +
+		"\t\tsupport.NewStep(cache.Stats, `stats`),\n" +
+		"\t)), `fetch`)"
+	if !strings.Contains(out.String(), expect) {
+		t.Errorf("Expected %s in:\n%s", expect, out.String())
+	}
+}
+
+func TestSerializeRetry(t *testing.T) {
+	doc := `
+ROUTE users "Users"
+	DOES «db.Query» rows TIMEOUT 2s RETRY 3 BACKOFF 100ms ON ERROR REROUTE oops
+	DOES «db.Ping» ping RETRY 1`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	expects := []string{
+		"Does(support.OnError(support.Retry(support.Timeout(db.Query, 2*time.Second), `rows`, 3, 100*time.Millisecond), `oops`), `rows`)",
+		"Does(support.Retry(db.Ping, `ping`, 1, 0), `ping`)",
+		"`time`",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(out.String(), expect) {
+			t.Errorf("Expected %s in:\n%s", expect, out.String())
+		}
+	}
+}
+
+func TestSerializeInlineIncludes(t *testing.T) {
+	doc := `
+ROUTE common.auth "Authenticate"
+	DOES «auth.Basic» user
+		USING realm "users"
+ROUTE admin "Admin"
+	INCLUDES common.auth WITH realm "admin"`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	expect := "reg.Route(`admin`, `Admin`).\n\t// inlined from common.auth\n\tDoes(auth.Basic, `user`).\n\t\t\tUsing(`realm`).WithDefault(`admin`)"
+	if !strings.Contains(out.String(), expect) {
+		t.Errorf("Expected %s in:\n%s", expect, out.String())
+	}
+	if strings.Contains(out.String(), "Includes(`common.auth`)") {
+		t.Errorf("Expected the INCLUDES to be inlined:\n%s", out.String())
+	}
+}
+
+func TestSerializeContext(t *testing.T) {
+	doc := `
+CONTEXT files []string "The CODL
+  files"
+CONTEXT retries int64 DEFAULT 3
+ROUTE a "A"`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, h.(Registry))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	expects := []string{
+		"func TestContext(cxt cookoo.Context) {\n\tcxt.Put(`retries`, int64(3))\n}",
+		"// GetFiles returns the files context value, The CODL files.\n",
+		"func GetFiles(c cookoo.Context) []string {\n\tv, _ := c.Get(`files`, nil).([]string)\n\treturn v\n}",
+		"func SetRetries(c cookoo.Context, v int64) {\n\tc.Put(`retries`, v)\n}",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(out.String(), expect) {
+			t.Errorf("Expected %s in:\n%s", expect, out.String())
+		}
+	}
+
+	// A file with no CONTEXT has no accessors.
+	h, _ = Parse(v2(`ROUTE a "A"`))
+	out.Reset()
+	NewSerializer("test", "serializertest", &out, h.(Registry)).Write()
+	if strings.Contains(out.String(), "TestContext") {
+		t.Errorf("Expected no context function in:\n%s", out.String())
+	}
+}
+
+func TestSerializeDatasources(t *testing.T) {
+	doc := `
+DATASOURCE cfg «config.NewSource()»
+IF profile dev
+	DATASOURCE db «db.Mock()»
+END
+ROUTE a "A"`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	ser := NewSerializer("test", "serializertest", &out, ForProfile(h.(Registry), ""))
+	if err := ser.Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+	expect := "func TestDatasources(cxt cookoo.Context) {\n\tcxt.AddDatasource(`cfg`, config.NewSource())\n}"
+	if !strings.Contains(out.String(), expect) {
+		t.Errorf("Expected %s in:\n%s", expect, out.String())
+	}
+
+	out.Reset()
+	NewSerializer("test", "serializertest", &out, ForProfile(h.(Registry), "dev")).Write()
+	if !strings.Contains(out.String(), "cxt.AddDatasource(`db`, db.Mock())") {
+		t.Errorf("Expected the dev datasource in:\n%s", out.String())
+	}
+}
+
+func TestSerializeApp(t *testing.T) {
+	doc := `
+APP tool "A tool" MAIN
+FLAGS run
+	v bool false "Verbose"
+SUBCOMMAND run "Runs it"
+	DOES «x» x`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := NewSerializer("test", "serializertest", &out, h.(Registry)).Write(); err == nil {
+		t.Errorf("Expected an error for MAIN outside of package main")
+	}
+
+	out.Reset()
+	if err := NewSerializer("test", "main", &out, h.(Registry)).Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+	expects := []string{
+		"var runFlags = func() *flag.FlagSet {\n\tflags := flag.NewFlagSet(\"run\", flag.PanicOnError)\n\tflags.Bool(`h`, false, `Show help text and exit.`)\n\tflags.Bool(`v`, false, `Verbose`)\n\treturn flags\n}()",
+		"var toolFlags = func() *flag.FlagSet {",
+		"Does(cli.ParseArgs, `@args`).",
+		"Using(`flagset`).WithDefault(runFlags).",
+		"Does(cli.ShowHelp, `@help`).",
+		"func TestRun(reg *cookoo.Registry, router *cookoo.Router, cxt cookoo.Context) error {",
+		"return cli.New(reg, router, cxt).Help(`A tool`, `A tool`, toolFlags).RunSubcommand()",
+		"if err := TestRun(reg, router, cxt); err != nil {",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(out.String(), expect) {
+			t.Errorf("Expected %s in:\n%s", expect, out.String())
+		}
+	}
+}
+
+func TestSerializeGo(t *testing.T) {
+	doc := `
+GO «
+import "strings"
+
+func upper(s string) string { return strings.ToUpper(s) }»
+ROUTE a "A"
+	DOES «x» x
+		USING s «upper("a")»`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := NewSerializer("test", "serializertest", &out, h.(Registry)).Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+	expect := "\t`strings`\n\t\n)\n\nfunc upper(s string) string { return strings.ToUpper(s) }\n\nfunc TestRoutes("
+	if !strings.Contains(out.String(), expect) {
+		t.Errorf("Expected %q in:\n%s", expect, out.String())
+	}
+}
+
+// A GO block may import the packages that the generated file imports too.
+func TestSerializeGoImports(t *testing.T) {
+	doc := `
+GO «
+import (
+	"github.com/Masterminds/cookoo"
+	"github.com/Masterminds/codl/support"
+)
+
+// stop is a command that stops the route.
+var stop cookoo.Command = support.Stop()»
+ROUTE a "A"
+	DOES stop s`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := NewSerializer("test", "serializertest", &out, h.(Registry)).Write(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+	fset := token.NewFileSet()
+	f, err := gparser.ParseFile(fset, "test.go", out.String(), 0)
+	if err != nil {
+		t.Fatalf("Failed to parse the generated code: %s\n%s", err, out.String())
+	}
+	conf := types.Config{ Importer: importer.ForCompiler(fset, "source", nil) }
+	if _, err := conf.Check("serializertest", fset, []*ast.File{f}, nil); err != nil {
+		t.Errorf("Failed to compile the generated code: %s\n%s", err, out.String())
+	}
+}
+
+func TestSerializeTests(t *testing.T) {
+	doc := `
+IMPORT
+	github.com/Masterminds/codl/cmd
+	github.com/Masterminds/cookoo/web
+	gopkg.in/fsnotify.v1
+FLAGS build
+	d string "." "Dir"
+SUBCOMMAND build "Build"
+	DOES cmd.FindCodl files
+		USING dir FROM cxt:d
+	DOES cmd.Translate created
+		USING files FROM cxt:files
+ROUTE flush "Flush"
+	DOES web.Flush flushed
+	DOES fsnotify.Watch watched
+TEST "build finds files" ROUTE build GIVEN cxt:d "testdata" MOCK cmd.Translate RETURNS «[]string{}» EXPECT cxt:files LEN 2
+TEST "flush" ROUTE flush MOCK fsnotify.Watch EXPECT cxt:watched «nil»`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := NewSerializer("test", "serializertest", &out, h.(Registry)).WriteTests(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+	expects := []string{
+		"package serializertest\n",
+		"import (\n\t\"testing\"\n\t\"github.com/Masterminds/cookoo\"\n\t`github.com/Masterminds/codl/support`\n\t`github.com/Masterminds/codl/cmd`\n\t`github.com/Masterminds/cookoo/web`\n\t`github.com/Masterminds/cookoo/cli`\n\t\n)",
+		"func TestBuildFindsFiles(t *testing.T) {\n\treg, router, cxt := cookoo.Cookoo()\n\tTestRoutes(reg)\n\treg.Route(`build`, `Build`).",
+		"\tDoes(support.Mock([]string{}), `created`).\n\t\t\tUsing(`files`).From(`cxt:files`)\n",
+		"\tsupport.ResetFlags(buildFlags)\n\tcxt.Put(\"runner.Args\", []string{`build`, support.FlagArg(`d`, `testdata`)})\n\tcxt.Put(`d`, `testdata`)\n",
+		"router.HandleRequest(`build`, cxt, false)",
+		"support.CheckLen(cxt, `files`, 2)",
+		"\tDoes(support.Mock(nil), `watched`)\n",
+		"support.CheckValue(cxt, `watched`, nil)",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(out.String(), expect) {
+			t.Errorf("Expected %q in:\n%s", expect, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "fsnotify") {
+		t.Errorf("Expected the unused fsnotify import to be left out of:\n%s", out.String())
+	}
+}
+
+func TestSerializeTestsIncludes(t *testing.T) {
+	doc := `
+ROUTE top "Top"
+	INCLUDES middle
+ROUTE other "Other"
+	DOES «x.Other» other
+ROUTE middle "Middle"
+	INCLUDES leaf
+ROUTE leaf "Leaf"
+	DOES «x.Leaf» leaf
+TEST "top" ROUTE top MOCK «x.Leaf» RETURNS 1`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := NewSerializer("test", "serializertest", &out, h.(Registry)).WriteTests(); err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	// The routes that include the mocked one are registered again after it,
+	// so that they copy the mock.
+	expect := "\tTestRoutes(reg)\n" +
+		"\treg.Route(`leaf`, `Leaf`).\n\tDoes(support.Mock(1), `leaf`)\n" +
+		"\treg.Route(`middle`, `Middle`).\n\tIncludes(`leaf`)\n\n" +
+		"\treg.Route(`top`, `Top`).\n\tIncludes(`middle`)\n\n"
+	if !strings.Contains(out.String(), expect) {
+		t.Errorf("Expected %q in:\n%s", expect, out.String())
+	}
+	if strings.Contains(out.String(), "`other`") {
+		t.Errorf("Expected only the routes with the mock to be registered again:\n%s", out.String())
+	}
+}
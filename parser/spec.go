@@ -0,0 +1,473 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	gparser "go/parser"
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// TestSpec is a TEST statement, which runs a route with values put in the
+// context and some of its commands mocked, and checks the context after.
+type TestSpec struct {
+	// Name and Route are quoted, like other strings.
+	Name, Route string
+	Given []*Given
+	Mocks []*Mock
+	Expect []*Expectation
+}
+
+// Given is a GIVEN clause. Key is the quoted context key, and Value is Go
+// code.
+type Given struct {
+	Key, Value string
+}
+
+// Mock is a MOCK clause. Cmd is the command to replace, as it is written in
+// its DOES, and Returns is Go code for the value the stub returns.
+type Mock struct {
+	Cmd, Returns string
+	returning bool
+}
+
+// Expectation is an EXPECT clause. Key is the quoted context key. Value is
+// Go code for the expected value, or for the length if Len is true.
+type Expectation struct {
+	Key, Value string
+	Len bool
+	lenWord bool
+}
+
+// Func is the name of the test function: "build finds files" becomes
+// "TestBuildFindsFiles".
+func (t *TestSpec) Func() string {
+	name := "Test"
+	for _, word := range strings.FieldsFunc(Unquote(t.Name), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		name += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return name
+}
+
+// mocks returns the stub for each mocked command.
+func (t *TestSpec) mocks() map[string]string {
+	stubs := map[string]string{}
+	for _, m := range t.Mocks {
+		returns := m.Returns
+		if returns == "" {
+			returns = "nil"
+		}
+		stubs[m.Cmd] = "support.Mock(" + returns + ")"
+	}
+	return stubs
+}
+
+// Test starts a TEST statement. It takes a name, then ROUTE and the name of
+// the route to run, then any number of GIVEN, MOCK and EXPECT clauses. It
+// ends any open ROUTE.
+func (l *handler) Test() {
+	if !l.unannotated("TEST") || !l.ungrouped("TEST") {
+		return
+	}
+	if len(l.profiles()) > 0 {
+		l.err = fmt.Errorf("TEST cannot be inside an IF profile block")
+		return
+	}
+	l.closeRoute(TestMode)
+	l.test = &TestSpec{}
+	l.tests = append(l.tests, l.test)
+	l.testClause = ""
+}
+
+// Given starts a GIVEN clause, which puts a value into the context before
+// the route runs: GIVEN cxt:d "testdata".
+func (l *handler) Given() {
+	l.startTestClause("GIVEN")
+}
+
+// Mock starts a MOCK clause, which replaces a command with a stub that
+// returns a value: MOCK cmd.Translate RETURNS «[]string{}».
+func (l *handler) Mock() {
+	l.startTestClause("MOCK")
+}
+
+// Expect starts an EXPECT clause, which checks a context value after the
+// route runs: EXPECT cxt:files LEN 2, or EXPECT cxt:name "value".
+func (l *handler) Expect() {
+	l.startTestClause("EXPECT")
+}
+
+func (l *handler) startTestClause(keyword string) {
+	if !l.unannotated(keyword) {
+		return
+	}
+	if l.mode != TestMode || l.test.Route == "" {
+		l.err = fmt.Errorf("%s must come after TEST and its ROUTE", keyword)
+		return
+	}
+	l.testClause = keyword
+	switch keyword {
+	case "GIVEN":
+		l.test.Given = append(l.test.Given, &Given{})
+	case "MOCK":
+		l.test.Mocks = append(l.test.Mocks, &Mock{})
+	case "EXPECT":
+		l.test.Expect = append(l.test.Expect, &Expectation{})
+	}
+}
+
+// testRoute handles ROUTE in a TEST, which names the route to run.
+func (l *handler) testRoute() {
+	l.testClause = "ROUTE"
+}
+
+// testWord handles a string or literal in a TEST. code is true if the word
+// is already Go code, rather than a string to be quoted.
+func (l *handler) testWord(str string, code bool) {
+	t := l.test
+	value := str
+	if !code {
+		value = asString(str)
+	}
+
+	switch l.testClause {
+	case "":
+		if t.Name == "" && !code {
+			t.Name = asString(str)
+			for _, other := range l.tests {
+				if other != t && other.Func() == t.Func() {
+					l.err = fmt.Errorf("TEST %s and %s would have the same function name", Unquote(other.Name), str)
+				}
+			}
+			return
+		}
+		l.err = fmt.Errorf("TEST takes a name, a ROUTE, and GIVEN, MOCK and EXPECT clauses. No place for %s", str)
+	case "ROUTE":
+		if code {
+			l.err = fmt.Errorf("ROUTE in a TEST takes a route name, not a literal: %s", str)
+			return
+		}
+		t.Route = asString(str)
+		l.testClause = ""
+	case "GIVEN":
+		g := t.Given[len(t.Given) - 1]
+		switch {
+		case g.Key == "":
+			g.Key = l.testKey("GIVEN", str, code)
+		case g.Value == "":
+			g.Value = value
+			l.testClause = ""
+		}
+	case "MOCK":
+		m := t.Mocks[len(t.Mocks) - 1]
+		switch {
+		case m.Cmd == "":
+			m.Cmd = str
+		case !m.returning && str == "RETURNS" && !code:
+			m.returning = true
+		case m.returning && m.Returns == "":
+			m.Returns = value
+			l.testClause = ""
+		default:
+			l.err = fmt.Errorf("MOCK takes a command and RETURNS with a value. No place for %s", str)
+		}
+	case "EXPECT":
+		e := t.Expect[len(t.Expect) - 1]
+		switch {
+		case e.Key == "":
+			e.Key = l.testKey("EXPECT", str, code)
+		case !e.lenWord && str == "LEN" && !code:
+			e.lenWord = true
+		case e.lenWord:
+			if n, typ, _ := scalar(str); typ != typeInt || strings.HasPrefix(n, "-") {
+				l.err = fmt.Errorf("LEN takes a length, not %s", str)
+				return
+			}
+			e.Len = true
+			e.Value = str
+			l.testClause = ""
+		default:
+			e.Value = value
+			l.testClause = ""
+		}
+	}
+}
+
+// testValueNext reports whether the next word in a TEST is a value, which
+// may be a typed literal.
+func (l *handler) testValueNext() bool {
+	t := l.test
+	switch l.testClause {
+	case "GIVEN":
+		return t.Given[len(t.Given) - 1].Key != ""
+	case "MOCK":
+		return t.Mocks[len(t.Mocks) - 1].returning
+	case "EXPECT":
+		return t.Expect[len(t.Expect) - 1].Key != ""
+	}
+	return false
+}
+
+// testKey returns the quoted context key of a GIVEN or EXPECT source, which
+// must start with "cxt:".
+func (l *handler) testKey(keyword, str string, code bool) string {
+	if code || !strings.HasPrefix(str, "cxt:") || len(str) == len("cxt:") {
+		l.err = fmt.Errorf("%s takes a context key such as cxt:name, not %s", keyword, str)
+		return ""
+	}
+	return asString(strings.TrimPrefix(str, "cxt:"))
+}
+
+// testDone reports whether the last TEST has its name and route, and its
+// last clause is complete. If not, that is an error.
+func (l *handler) testDone() bool {
+	t := l.test
+	if l.mode != TestMode || t == nil {
+		return true
+	}
+	switch {
+	case t.Name == "":
+		l.err = fmt.Errorf("TEST requires a name")
+	case t.Route == "":
+		l.err = fmt.Errorf("TEST %s requires a ROUTE and the name of a route", Unquote(t.Name))
+	case l.testClause == "MOCK" && t.Mocks[len(t.Mocks) - 1].Cmd == "":
+		l.err = fmt.Errorf("MOCK requires a command")
+	case l.testClause == "MOCK" && t.Mocks[len(t.Mocks) - 1].returning:
+		l.err = fmt.Errorf("RETURNS requires a value")
+	case l.testClause == "GIVEN":
+		l.err = fmt.Errorf("GIVEN requires a context key and a value")
+	case l.testClause == "EXPECT":
+		l.err = fmt.Errorf("EXPECT requires a context key, and a value or LEN and a length")
+	default:
+		return true
+	}
+	return false
+}
+
+// resolveTests checks that each TEST runs a known route, and that each
+// command it mocks is run by some DOES.
+func (l *handler) resolveTests() error {
+	for _, t := range l.tests {
+		if l.findRoute(Unquote(t.Route)) == nil {
+			return fmt.Errorf("TEST %s runs unknown route %s", Unquote(t.Name), Unquote(t.Route))
+		}
+		for _, m := range t.Mocks {
+			if !l.runs(m.Cmd) {
+				return fmt.Errorf("TEST %s mocks %s, but no DOES runs it", Unquote(t.Name), m.Cmd)
+			}
+		}
+	}
+	return nil
+}
+
+func (l *handler) findRoute(name string) *Route {
+	for _, r := range l.routes {
+		if Unquote(r.Name) == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// runs reports whether any route runs cmd.
+func (l *handler) runs(cmd string) bool {
+	for _, r := range l.routes {
+		for _, c := range r.Commands {
+			for _, c := range append([]*Command{c}, c.Steps...) {
+				if c.Cmd == cmd {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Tests returns the TEST statements.
+func (l *handler) Tests() []*TestSpec {
+	return l.tests
+}
+
+// testCase is a TEST as the test template needs it.
+type testCase struct {
+	*TestSpec
+	// Mocked are the routes with mocked commands, which are registered again
+	// with stubs in their place, and the routes that include them.
+	Mocked []*Route
+	// Flags is the flag set of a SUBCOMMAND, and Args are the command line
+	// arguments it parses.
+	Flags *FlagSet
+	Args []string
+}
+
+// testCases returns the tests whose routes are in the registry.
+func testCases(reg Registry) []*testCase {
+	cases := []*testCase{}
+	for _, t := range reg.Tests() {
+		var route *Route
+		for _, r := range reg.Routes() {
+			if r.Name == t.Route {
+				route = r
+			}
+		}
+		if route == nil {
+			continue
+		}
+
+		tc := &testCase{ TestSpec: t, Mocked: mockedRoutes(reg.Routes(), t.mocks()) }
+		if route.Subcommand {
+			name := route.Flags
+			if name == "" {
+				name = Unquote(route.Name)
+			}
+			for _, fs := range reg.FlagSets() {
+				if fs.Name == name {
+					tc.Flags = fs
+				}
+			}
+			tc.Args = []string{route.Name}
+			for _, g := range t.Given {
+				if tc.Flags != nil && tc.Flags.has(Unquote(g.Key)) {
+					tc.Args = append(tc.Args, "support.FlagArg(" + g.Key + ", " + g.Value + ")")
+				}
+			}
+		}
+		cases = append(cases, tc)
+	}
+	return cases
+}
+
+// mockedRoutes returns the routes that run a mocked command, with stubs in
+// place, and the routes that include them. cookoo copies the commands of an
+// included route when a route is registered, so each route comes after the
+// routes that it includes.
+func mockedRoutes(routes []*Route, stubs map[string]string) []*Route {
+	routes = lastRoutes(routes)
+	byName := map[string]*Route{}
+	for _, r := range routes {
+		byName[r.Name] = r
+	}
+
+	list := []*Route{}
+	changed := map[string]bool{}
+	seen := map[string]bool{}
+	var visit func(r *Route) bool
+	visit = func(r *Route) bool {
+		if seen[r.Name] {
+			return changed[r.Name]
+		}
+		seen[r.Name] = true
+		includes := false
+		for _, c := range r.Commands {
+			if inc, ok := byName[c.Name]; ok && c.IsIncludes() && visit(inc) {
+				includes = true
+			}
+		}
+		if nr, ok := mocked(r, stubs); ok || includes {
+			changed[r.Name] = true
+			list = append(list, nr)
+		}
+		return changed[r.Name]
+	}
+	for _, r := range routes {
+		visit(r)
+	}
+	return list
+}
+
+// mocked returns a copy of a route with stubs in place of the commands
+// that they mock. It reports false if the route runs none of them.
+func mocked(r *Route, stubs map[string]string) (*Route, bool) {
+	found := false
+	nr := *r
+	nr.Commands = make([]*Command, len(r.Commands))
+	for i, c := range r.Commands {
+		nc := c.copy()
+		for _, c := range append([]*Command{nc}, nc.Steps...) {
+			if stub, ok := stubs[c.Cmd]; ok && !c.IsIncludes() {
+				c.Cmd = stub
+				found = true
+			}
+		}
+		nr.Commands[i] = nc
+	}
+	return &nr, found
+}
+
+var versionSuffixRe = regexp.MustCompile(`[.-]v[0-9]+$`)
+
+// importName returns the name that an import is used by: its alias, or a
+// guess from its path. "gopkg.in/fsnotify.v1" is fsnotify.
+func importName(imp string) string {
+	if i := strings.Index(imp, " "); i > 0 {
+		return imp[:i]
+	}
+	path := Unquote(imp)
+	name := path[strings.LastIndex(path, "/") + 1:]
+	name = versionSuffixRe.ReplaceAllString(name, "")
+	name = strings.TrimPrefix(strings.TrimSuffix(name, "-go"), "go-")
+	return strings.Replace(name, "-", "", -1)
+}
+
+// usedImports returns the imports that Go code refers to. A package is
+// used if its name is on the left of a selector and is not declared in the
+// code itself.
+func usedImports(code string, imports []string) ([]string, error) {
+	fset := token.NewFileSet()
+	f, err := gparser.ParseFile(fset, "", code, 0)
+	if err != nil {
+		return nil, err
+	}
+	unresolved := map[*ast.Ident]bool{}
+	for _, id := range f.Unresolved {
+		unresolved[id] = true
+	}
+	names := map[string]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && unresolved[id] {
+				names[id.Name] = true
+			}
+		}
+		return true
+	})
+
+	used := []string{}
+	for _, imp := range imports {
+		if names[importName(imp)] {
+			used = append(used, imp)
+		}
+	}
+	return used, nil
+}
+
+// WriteTests writes a Go test file for the TEST statements. Each test
+// registers the routes, registers again the routes that run a mocked
+// command with a stub in its place, runs its route, and checks the context.
+func (s *Serializer) WriteTests() error {
+	var body bytes.Buffer
+	if err := s.tpl.ExecuteTemplate(&body, "tests", map[string]interface{}{
+		"Name": s.name,
+		"Registry": s.reg,
+		"Tests": testCases(s.reg),
+	}); err != nil {
+		return err
+	}
+
+	// Only the imports that the tests use, or the file will not compile.
+	imports := append([]string{asString("github.com/Masterminds/codl/support")}, s.reg.Imports()...)
+	used, err := usedImports("package " + s.packageName + "\n" + body.String(), imports)
+	if err != nil {
+		return fmt.Errorf("Generated tests are not valid Go: %s", err)
+	}
+	return s.tpl.ExecuteTemplate(s.out, "testFile", map[string]interface{}{
+		"Name": s.name,
+		"Package": s.packageName,
+		"Imports": used,
+		"Body": body.String(),
+	})
+}
@@ -0,0 +1,318 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	gparser "go/parser"
+	"go/ast"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigFile is the name of the file that configures the CODL files in its
+// directory.
+const ConfigFile = "codl.json"
+
+// Config is the configuration of the CODL files in one directory:
+//
+// 	{"strict": true, "allow": ["time.Second", "config.*"]}
+type Config struct {
+	// Strict turns on strict mode. See CheckStrict.
+	Strict bool `json:"strict"`
+	// Allow lists the names that code literals may use in strict mode. A
+	// name is an identifier, "pkg.Name", or "pkg.*" for all of a package.
+	Allow []string `json:"allow"`
+}
+
+// LoadConfig reads the config in a directory. A directory without one has
+// an empty config.
+func LoadConfig(dir string) (*Config, error) {
+	fname := filepath.Join(dir, ConfigFile)
+	data, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("Could not read %s: %s", fname, err)
+	}
+	return cfg, nil
+}
+
+// Kinds of code literals.
+const (
+	// LiteralCode is Go code, such as a USING default or a DATASOURCE.
+	LiteralCode = iota
+	// LiteralCommand is a DOES command.
+	LiteralCommand
+	// LiteralString is a string with a backtick in it. The backtick ends
+	// the Go string that it is written into.
+	LiteralString
+	// LiteralType is a CONTEXT type.
+	LiteralType
+)
+
+// CodeLiteral is code that a CODL file puts into the generated Go code
+// unaltered.
+type CodeLiteral struct {
+	Kind int
+	Code string
+	// Bare is true if the code is written as a bare word or string, rather
+	// than as a literal: «...» or `...`.
+	Bare bool
+	// File is the file the literal is in. Route is the route it is in, if
+	// any, and Keyword is the statement it belongs to, such as USING.
+	File, Route, Keyword string
+	// Index is the literal's place among the literals in File, from 0.
+	Index int
+}
+
+func (c *CodeLiteral) String() string {
+	if c.Route == "" {
+		return fmt.Sprintf("%s: %s %s", c.File, c.Keyword, c.code())
+	}
+	return fmt.Sprintf("%s: route %s: %s %s", c.File, c.Route, c.Keyword, c.code())
+}
+
+// code writes the code as it is written in CODL, on one line.
+func (c *CodeLiteral) code() string {
+	code := c.Code
+	if i := strings.Index(code, "\n"); i >= 0 {
+		code = strings.TrimSpace(code[:i]) + " ..."
+	}
+	if !c.Bare {
+		return "«" + code + "»"
+	} else if c.Kind == LiteralString {
+		return fmt.Sprintf("%q", code)
+	}
+	return code
+}
+
+// codeLiterals is implemented by registries that track code literals.
+type codeLiterals interface {
+	Literals() []*CodeLiteral
+}
+
+// Literals returns the code literals in a registry, including those in the
+// files that it USEs.
+func Literals(reg Registry) []*CodeLiteral {
+	if cl, ok := reg.(codeLiterals); ok {
+		return cl.Literals()
+	}
+	return nil
+}
+
+// CheckStrict checks a file in strict mode, where code literals may not
+// run arbitrary code. Each literal that breaks one of these rules is an
+// error:
+//
+// 	- A code literal may only be a constant, a name in the allow list, or
+// 	  an expression of them with operators, such as «10 * time.Second».
+// 	- A DOES command must be a function in an imported package, such as
+// 	  cli.ParseArgs, or a name in the allow list.
+// 	- A CONTEXT type may only use types from imported packages.
+// 	- There may be no GO blocks, and no strings with backticks.
+func CheckStrict(file string, reg Registry, allow []string) []*Diagnostic {
+	imports := importNames(reg)
+	diags := []*Diagnostic{}
+	for _, lit := range Literals(reg) {
+		if err := strictLiteral(lit, imports, allow); err != nil {
+			diags = append(diags, &Diagnostic{
+				File: file,
+				Route: lit.Route,
+				Message: err.Error(),
+			})
+		}
+	}
+	return diags
+}
+
+// StrictError returns why strict mode does not allow a literal, or nil.
+func StrictError(lit *CodeLiteral, reg Registry, allow []string) error {
+	return strictLiteral(lit, importNames(reg), allow)
+}
+
+func importNames(reg Registry) map[string]bool {
+	names := map[string]bool{}
+	for _, imp := range reg.Imports() {
+		names[importName(imp)] = true
+	}
+	return names
+}
+
+func strictLiteral(lit *CodeLiteral, imports map[string]bool, allow []string) error {
+	switch {
+	case lit.Kind == LiteralString:
+		return fmt.Errorf("%s has a string with a backtick, which strict mode does not allow: %q", lit.Keyword, lit.Code)
+	case lit.Keyword == "GO":
+		return fmt.Errorf("GO blocks are not allowed in strict mode")
+	case lit.Kind == LiteralCommand:
+		if err := strictCommand(lit.Code, imports, allow); err != nil {
+			return fmt.Errorf("DOES %s is not allowed in strict mode: %s", lit.code(), err)
+		}
+	case lit.Kind == LiteralType:
+		if err := strictType(lit.Code, imports, allow); err != nil {
+			return fmt.Errorf("%s type %s is not allowed in strict mode: %s", lit.Keyword, lit.code(), err)
+		}
+	default:
+		if err := strictCode(lit.Code, allow); err != nil {
+			return fmt.Errorf("%s %s is not allowed in strict mode: %s", lit.Keyword, lit.code(), err)
+		}
+	}
+	return nil
+}
+
+// strictCommand checks that a command is a function in an imported package.
+func strictCommand(cmd string, imports map[string]bool, allow []string) error {
+	expr, err := gparser.ParseExpr(cmd)
+	if err != nil {
+		return fmt.Errorf("it is not a Go name")
+	}
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if allowed(e.Name, allow) {
+			return nil
+		}
+		return fmt.Errorf("%s is not in an imported package", e.Name)
+	case *ast.SelectorExpr:
+		pkg, ok := e.X.(*ast.Ident)
+		if !ok {
+			break
+		}
+		if imports[pkg.Name] || allowed(pkg.Name + "." + e.Sel.Name, allow) {
+			return nil
+		}
+		return fmt.Errorf("there is no IMPORT for %s", pkg.Name)
+	}
+	return fmt.Errorf("it is not a function in an imported package")
+}
+
+// strictCode checks that code is a constant expression of allowed names.
+func strictCode(code string, allow []string) error {
+	expr, err := gparser.ParseExpr(code)
+	if err != nil {
+		return fmt.Errorf("it is not a Go expression")
+	}
+
+	var found error
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if found != nil {
+			return false
+		}
+		switch e := n.(type) {
+		case *ast.BasicLit, *ast.ParenExpr, *ast.UnaryExpr, *ast.BinaryExpr:
+			return true
+		case *ast.Ident:
+			if e.Name != "true" && e.Name != "false" && e.Name != "nil" && !allowed(e.Name, allow) {
+				found = fmt.Errorf("%s is not in the allow list", e.Name)
+			}
+		case *ast.SelectorExpr:
+			name := code
+			if pkg, ok := e.X.(*ast.Ident); ok {
+				name = pkg.Name + "." + e.Sel.Name
+			}
+			if !allowed(name, allow) {
+				found = fmt.Errorf("%s is not in the allow list", name)
+			}
+		case *ast.CallExpr:
+			found = fmt.Errorf("calls are not allowed")
+		case *ast.FuncLit:
+			found = fmt.Errorf("functions are not allowed")
+		case nil:
+		default:
+			found = fmt.Errorf("only constants, operators and names in the allow list may be used")
+		}
+		return false
+	})
+	return found
+}
+
+// strictType checks that a type names no package that is not imported, and
+// has no code in it.
+func strictType(typ string, imports map[string]bool, allow []string) error {
+	expr, err := gparser.ParseExpr(typ)
+	if err != nil {
+		return fmt.Errorf("it is not a Go type")
+	}
+
+	var found error
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if found != nil {
+			return false
+		}
+		switch e := n.(type) {
+		case *ast.SelectorExpr:
+			pkg, ok := e.X.(*ast.Ident)
+			if !ok || !(imports[pkg.Name] || allowed(pkg.Name + "." + e.Sel.Name, allow)) {
+				found = fmt.Errorf("it is not a type in an imported package")
+			}
+			return false
+		case *ast.CallExpr, *ast.FuncLit, *ast.CompositeLit:
+			found = fmt.Errorf("it has code in it")
+		}
+		return true
+	})
+	return found
+}
+
+// allowed reports whether a name is in the allow list, itself or by its
+// package: "pkg.*".
+func allowed(name string, allow []string) bool {
+	for _, a := range allow {
+		if a == name {
+			return true
+		}
+		if pkg := strings.TrimSuffix(a, ".*"); pkg != a && strings.HasPrefix(name, pkg + ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// code records a code literal, or a string that goes into the generated code
+// unaltered, for strict mode.
+func (l *handler) code(kind int, code string, bare bool) {
+	lit := &CodeLiteral{ Kind: kind, Code: code, Bare: bare, File: l.filename, Keyword: modeKeywords[l.mode] }
+	if l.mode == HookMode && l.hook != nil {
+		lit.Keyword = l.hook.keyword()
+	}
+	if l.currentRoute != nil {
+		lit.Route = Unquote(l.currentRoute.Name)
+	}
+	for _, other := range l.literals {
+		if other.File == l.filename {
+			lit.Index++
+		}
+	}
+	l.literals = append(l.literals, lit)
+}
+
+// modeKeywords names the statement that each mode reads.
+var modeKeywords = map[int]string{
+	ImportMode: "IMPORT",
+	RouteMode: "ROUTE",
+	IncludeMode: "INCLUDES",
+	UsingMode: "USING",
+	DoesMode: "DOES",
+	FromMode: "FROM",
+	UseMode: "USE",
+	WithMode: "WITH",
+	FlowMode: "REROUTE",
+	GroupMode: "PARALLEL",
+	ContextMode: "CONTEXT",
+	DefaultMode: "DEFAULT",
+	DatasourceMode: "DATASOURCE",
+	AppMode: "APP",
+	FlagsMode: "FLAGS",
+	GoMode: "GO",
+	TestMode: "TEST",
+}
+
+// Literals returns the code literals in the file, and in the files it USEs.
+func (l *handler) Literals() []*CodeLiteral {
+	return l.literals
+}
@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckStrict(t *testing.T) {
+	doc := `
+IMPORT
+  github.com/Masterminds/cookoo/cli
+  time
+  database/sql
+
+CONTEXT timeout time.Duration "How long to wait"
+CONTEXT db *sql.DB "The database"
+CONTEXT cache *redis.Client "The cache"
+  DEFAULT «nil»
+
+DATASOURCE config config.Load
+
+ROUTE ok "Allowed"
+  DOES cli.ParseArgs args
+    USING period «10 * time.Second»
+    USING retries «3»
+    USING name «"default"»
+    USING verbose «!false»
+    USING limit «config.MaxSize + 1»
+  DOES «cli.ShowHelp» help
+
+ROUTE bad "Not allowed"
+  DOES os.Exit exit
+    USING code «run()»
+    USING list «[]string{}»
+    USING f «func() int { return 1 }»
+    USING secret «env.Secret»
+  DOES "fmt.Println(1)" print
+  DOES local.Thing thing
+    USING name "a` + "`" + `b"
+`
+	h, err := Parse(v2(doc))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	reg := h.(Registry)
+
+	diags := CheckStrict("a.codl", reg, []string{"time.Second", "config.*", "local.Thing"})
+	got := []string{}
+	for _, d := range diags {
+		if d.Warning {
+			t.Errorf("Expected an error, got %s", d)
+		}
+		got = append(got, d.String())
+	}
+	expect := []string{
+		"a.codl: error: CONTEXT type *redis.Client is not allowed in strict mode: it is not a type in an imported package",
+		"a.codl: route bad: error: DOES os.Exit is not allowed in strict mode: there is no IMPORT for os",
+		"a.codl: route bad: error: USING «run()» is not allowed in strict mode: calls are not allowed",
+		"a.codl: route bad: error: USING «[]string{}» is not allowed in strict mode: only constants, operators and names in the allow list may be used",
+		"a.codl: route bad: error: USING «func() int { return 1 }» is not allowed in strict mode: functions are not allowed",
+		"a.codl: route bad: error: USING «env.Secret» is not allowed in strict mode: env.Secret is not in the allow list",
+		"a.codl: route bad: error: DOES fmt.Println(1) is not allowed in strict mode: it is not a function in an imported package",
+		"a.codl: route bad: error: USING has a string with a backtick, which strict mode does not allow: \"a`b\"",
+	}
+	if len(got) != len(expect) {
+		t.Fatalf("Expected %d diagnostics, got %d:\n%s", len(expect), len(got), strings.Join(got, "\n"))
+	}
+	for i := range expect {
+		if got[i] != expect[i] {
+			t.Errorf("Expected %q, got %q", expect[i], got[i])
+		}
+	}
+}
+
+func TestCheckStrictGo(t *testing.T) {
+	h, err := Parse(v2("GO «func helper() {}»\nCONTEXT n int \"A number\" DEFAULT 10s"))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	// The DEFAULT is generated from a typed literal, so it is not checked.
+	diags := CheckStrict("a.codl", h.(Registry), nil)
+	if len(diags) != 1 || diags[0].Message != "GO blocks are not allowed in strict mode" {
+		t.Errorf("Expected an error for the GO block, got %v", diags)
+	}
+}
+
+func TestLiteralsUsed(t *testing.T) {
+	h, err := ParseFile("testdata/use/shared/app.codl")
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+	h2, err := Parse(v2("ROUTE a \"A\" DOES «x.Y» y DOES «x.Y» z"))
+	if err != nil {
+		t.Fatalf("Surprise! Error: %s", err)
+	}
+
+	// Identical literals in one file have their own indexes, and those
+	// from a used file keep the indexes they have in it.
+	lits := Literals(h2.(Registry))
+	if len(lits) != 2 || lits[0].Index != 0 || lits[1].Index != 1 {
+		t.Errorf("Expected two literals with indexes 0 and 1, got %v", lits)
+	}
+	for i, lit := range Literals(h.(Registry)) {
+		if lit.File != "testdata/use/shared/base.codl" || lit.Index != i {
+			t.Errorf("Expected literal %d of base.codl, got %s at %d", i, lit, lit.Index)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig("testdata/strict")
+	if err != nil {
+		t.Fatalf("Failed to load the config: %s", err)
+	}
+	if !cfg.Strict || len(cfg.Allow) != 2 || cfg.Allow[1] != "config.*" {
+		t.Errorf("Unexpected config: %v", cfg)
+	}
+
+	cfg, err = LoadConfig("testdata")
+	if err != nil {
+		t.Fatalf("Failed to load an empty config: %s", err)
+	}
+	if cfg.Strict || len(cfg.Allow) != 0 {
+		t.Errorf("Expected an empty config, got %v", cfg)
+	}
+}
@@ -0,0 +1,33 @@
+# bash completion for tool. This file is auto-generated by Codl.
+
+_tool() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	local prev=${COMP_WORDS[COMP_CWORD-1]}
+	local cmd="" i
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		-*) ;;
+		*) cmd=${COMP_WORDS[i]}; break ;;
+		esac
+	done
+
+	case $cmd in
+	"")
+		COMPREPLY=($(compgen -W "-h -v build rebuild version" -- "$cur"))
+		;;
+	build)
+		case $prev in
+		-d) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+		esac
+		COMPREPLY=($(compgen -W "-h -d -tags" -- "$cur"))
+		;;
+	rebuild)
+		case $prev in
+		-d) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+		esac
+		COMPREPLY=($(compgen -W "-h -d -tags" -- "$cur"))
+		;;
+	esac
+}
+
+complete -F _tool tool
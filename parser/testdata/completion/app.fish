@@ -0,0 +1,14 @@
+# fish completion for tool. This file is auto-generated by Codl.
+
+complete -c tool -f
+complete -c tool -n __fish_use_subcommand -o h -d 'Show help text and exit.'
+complete -c tool -n __fish_use_subcommand -o v -d 'Show more output.'
+complete -c tool -n __fish_use_subcommand -a build -d 'Build a directory'
+complete -c tool -n __fish_use_subcommand -a rebuild -d 'Build it again, don\'t wait'
+complete -c tool -n __fish_use_subcommand -a version -d 'Print the version'
+complete -c tool -n '__fish_seen_subcommand_from build' -o h -d 'Show help text and exit.'
+complete -c tool -n '__fish_seen_subcommand_from build' -o d -r -F -d 'The directory to build.'
+complete -c tool -n '__fish_seen_subcommand_from build' -o tags -d 'Use build [tags].'
+complete -c tool -n '__fish_seen_subcommand_from rebuild' -o h -d 'Show help text and exit.'
+complete -c tool -n '__fish_seen_subcommand_from rebuild' -o d -r -F -d 'The directory to build.'
+complete -c tool -n '__fish_seen_subcommand_from rebuild' -o tags -d 'Use build [tags].'
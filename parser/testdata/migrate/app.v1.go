@@ -0,0 +1,34 @@
+package codl
+
+// This file is auto-generated by Codl.
+
+import (
+	"github.com/Masterminds/cookoo"
+	`github.com/Masterminds/cookoo/cli`
+	
+)
+
+func AppRoutes(reg *cookoo.Registry) {
+	reg.Route(`run`, `Reads "files" from C:data.`).
+	Does(cli.ShowHelp, `help`).
+			Using(`summary`).WithDefault(`Don't panicn`).
+			Using(`word`).WithDefault(`CODL`).
+			Using(`path`).WithDefault(`http://example.com//IMPORT//x`).
+			Using(`list`).WithDefault(`[a,"b\c"]`).
+			Using(`n`).WithDefault(`42`).
+			Using(`wait`).WithDefault(`5s`).
+			Using(`items`).WithDefault(`[x]`).
+			Using(`STOP`).WithDefault(`x`).
+			Using(`on`).WithDefault(`true`).From(`cxt:on`).
+	Does(cli.ShowHelp, `END`).
+	Does(cli.ShowHelp, `TIMEOUT`).
+			Using(`m`).WithDefault(`{a:1}`)
+	reg.Route(`IF`, `b`).
+	Does(cli.ShowHelp, `IMPORT//x`).
+	Includes(`run`)
+
+	reg.Route(`GET`, `/users`).
+	Does(cli.ShowHelp, `@hidden`).
+			Using(`with`).WithDefault(`1e400`)
+	
+}
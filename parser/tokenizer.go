@@ -7,12 +7,14 @@ import (
 	"bytes"
 	"unicode"
 	"strings"
+	"strconv"
 )
 
 type EventHandler interface {
 	Error(error)
 	Literal(string)
 	Strval(string)
+	Bareword(string)
 
 	Import()
 	Includes()
@@ -20,12 +22,69 @@ type EventHandler interface {
 	Using()
 	Does()
 	From()
+	If()
+	End()
+	Annotation(string)
+	Use()
+	Namespace()
+	With()
+	Before()
+	After()
+	On()
+	Ignore()
+	Reroute()
+	Stop()
+	Unless()
+	ForEach()
+	Parallel()
+	Timeout()
+	Retry()
+	Backoff()
+	Context()
+	Default()
+	Datasource()
+	App()
+	Flags()
+	Subcommand()
+	Go()
+	Test()
+	Given()
+	Mock()
+	Expect()
 }
 
+// Versions of the CODL syntax. A file selects one with a "CODL 2" pragma
+// before anything else. Without it, a file is CODL 1.
+//
+// CODL 2 differs from CODL 1 in these ways:
+//
+// 	- A backslash in a quoted string starts an escape: \\, \", \', \n, \t
+// 	  or \r. Any other escape is an error. CODL 1 drops every backslash and
+// 	  keeps the character after it.
+// 	- A comment may directly follow a keyword: "IMPORT// comment". In CODL 1,
+// 	  "IMPORT//" is a bare word.
+// 	- CODL is a keyword. In CODL 1, it is a bare word after the first token.
+// 	- Only IMPORT, INCLUDES, ROUTE, USING, FROM and DOES are keywords in
+// 	  CODL 1. Every other keyword, annotations, lists and maps, typed
+// 	  literals and HTTP routes need CODL 2. In CODL 1, those words are bare
+// 	  words, so "USING n 42" has the string default "42".
+const (
+	Version1 = 1
+	Version2 = 2
+)
+
 type Tokenizer struct {
 	input *bufio.Reader
 	lastErr error
 	event EventHandler
+	version int
+	// tokens counts the tokens read so far.
+	tokens int
+}
+
+// Version returns the version of CODL that is being read.
+func (z *Tokenizer) Version() int {
+	return z.version
 }
 
 func (z *Tokenizer) Next() {
@@ -37,6 +96,7 @@ func (z *Tokenizer) Next() {
 		z.err(err)
 		return
 	}
+	z.tokens++
 
 	switch b {
 	case '`':
@@ -47,6 +107,14 @@ func (z *Tokenizer) Next() {
 		z.dquote()
 	case '\'':
 		z.squote()
+	case '[', '{':
+		if z.version < Version2 {
+			z.word(b)
+		} else if b == '[' {
+			z.bracketed(b, ']')
+		} else {
+			z.bracketed(b, '}')
+		}
 	//case ' ', '\t', '\n', '\r', '\v', '\f', 0x85 /* NEL */, 0xA0 /* NBSP */:
 		// consume whitespace.
 	default:
@@ -104,6 +172,9 @@ func (z *Tokenizer) squote() {
 }
 
 func (z *Tokenizer) readUntil(delim rune) (string, error) {
+	if z.version >= Version2 {
+		return z.readEscaped(delim)
+	}
 	r, _, err := z.input.ReadRune()
 	skipNext := false
 	var b bytes.Buffer
@@ -122,16 +193,86 @@ func (z *Tokenizer) readUntil(delim rune) (string, error) {
 	return b.String(), err
 }
 
+// readEscaped reads a CODL 2 string up to the delimiter, replacing escapes.
+func (z *Tokenizer) readEscaped(delim rune) (string, error) {
+	var b bytes.Buffer
+	for {
+		r, _, err := z.input.ReadRune()
+		if err != nil {
+			return b.String(), err
+		}
+		if r == delim {
+			return b.String(), nil
+		}
+		if r != '\\' {
+			b.WriteRune(r)
+			continue
+		}
+		if r, _, err = z.input.ReadRune(); err != nil {
+			return b.String(), err
+		}
+		switch r {
+		case '\\', '"', '\'':
+			b.WriteRune(r)
+		case 'n':
+			b.WriteRune('\n')
+		case 't':
+			b.WriteRune('\t')
+		case 'r':
+			b.WriteRune('\r')
+		default:
+			return b.String(), fmt.Errorf("Unknown escape \\%c in %c%s", r, delim, b.String())
+		}
+	}
+}
+
 var (
 	mport = "MPORT"
 	nclude = "NCLUDES"
 	oute = "OUTE"
 	sing = "SING"
+	se = "SE"
 	rom = "ROM"
 	oes = "OES"
+	iF = "F"
+	nd = "ND"
+	amespace = "AMESPACE"
+	ith = "ITH"
+	efore = "EFORE"
+	fter = "FTER"
+	n = "N"
+	gnore = "GNORE"
+	eroute = "EROUTE"
+	top = "TOP"
+	nless = "NLESS"
+	oreach = "OREACH"
+	arallel = "ARALLEL"
+	imeout = "IMEOUT"
+	etry = "ETRY"
+	ackoff = "ACKOFF"
+	ontext = "ONTEXT"
+	efault = "EFAULT"
+	atasource = "ATASOURCE"
+	pp = "PP"
+	lags = "LAGS"
+	ubcommand = "UBCOMMAND"
+	gO = "O"
+	est = "EST"
+	iven = "IVEN"
+	ock = "OCK"
+	xpect = "XPECT"
+	odl = "ODL"
+
+	// Annotation names, without the leading '@'. Any other word that starts
+	// with '@' is a bare word.
+	annotations = []string{"tag", "owner", "deprecated", "hidden", "nohooks", "nowith"}
 )
 
 func (z *Tokenizer) word(b rune) {
+	if z.version < Version2 {
+		z.word1(b)
+		return
+	}
 
 	switch b {
 	case 'I':
@@ -141,37 +282,184 @@ func (z *Tokenizer) word(b rune) {
 		} else if z.peekMatch(nclude) {
 			z.include()
 			return
+		} else if z.peekMatch(iF) {
+			z.ifBlock()
+			return
+		} else if z.peekMatch(gnore) {
+			z.ignore()
+			return
 		}
 		//z.input.UnreadRune()
 		z.bareword([]rune{b})
 		return
-	case 'R': // ROUTE
+	case 'R': // ROUTE, REROUTE, RETRY
 		if z.peekMatch(oute) {
 			z.route()
 			return
+		} else if z.peekMatch(eroute) {
+			z.reroute()
+			return
+		} else if z.peekMatch(etry) {
+			z.retry()
+			return
 		}
 
 		z.bareword([]rune{b})
 		return
 
-	case 'U': // USING
+	case 'U': // USING, USE, UNLESS
 		if z.peekMatch(sing) {
 			z.using()
 			return
+		} else if z.peekMatch(se) {
+			z.use()
+			return
+		} else if z.peekMatch(nless) {
+			z.unless()
+			return
 		}
 		z.bareword([]rune{b})
 		return
-	case 'D': // DOES
+	case 'D': // DOES, DEFAULT, DATASOURCE
 		if z.peekMatch(oes) {
 			z.does()
 			return
+		} else if z.peekMatch(efault) {
+			z.defaultValue()
+			return
+		} else if z.peekMatch(atasource) {
+			z.datasource()
+			return
 		}
 		z.bareword([]rune{b})
 		return
-	case 'F': // FROM
+	case 'F': // FROM, FOREACH, FLAGS
 		if z.peekMatch(rom) {
 			z.from()
 			return
+		} else if z.peekMatch(oreach) {
+			z.forEach()
+			return
+		} else if z.peekMatch(lags) {
+			z.flags()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'E': // END, EXPECT
+		if z.peekMatch(nd) {
+			z.end()
+			return
+		} else if z.peekMatch(xpect) {
+			z.expect()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'B': // BEFORE, BACKOFF
+		if z.peekMatch(efore) {
+			z.before()
+			return
+		} else if z.peekMatch(ackoff) {
+			z.backoff()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'A': // AFTER, APP
+		if z.peekMatch(fter) {
+			z.after()
+			return
+		} else if z.peekMatch(pp) {
+			z.app()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'W': // WITH
+		if z.peekMatch(ith) {
+			z.with()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'P': // PARALLEL
+		if z.peekMatch(arallel) {
+			z.parallel()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'T': // TIMEOUT, TEST
+		if z.peekMatch(imeout) {
+			z.timeout()
+			return
+		} else if z.peekMatch(est) {
+			z.test()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'S': // STOP, SUBCOMMAND
+		if z.peekMatch(top) {
+			z.stop()
+			return
+		} else if z.peekMatch(ubcommand) {
+			z.subcommand()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'O': // ON
+		if z.peekMatch(n) {
+			z.on()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'G': // GO, GIVEN
+		if z.peekMatch(gO) {
+			z.goBlock()
+			return
+		} else if z.peekMatch(iven) {
+			z.given()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'C': // CODL, CONTEXT
+		if z.tokens == 1 && z.peekMatch(odl) {
+			z.pragma()
+			return
+		} else if z.version >= Version2 && z.peekMatch(odl) {
+			z.err(fmt.Errorf("CODL must come before anything else in a file"))
+			return
+		} else if z.peekMatch(ontext) {
+			z.context()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'M': // MOCK
+		if z.peekMatch(ock) {
+			z.mock()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case 'N': // NAMESPACE
+		if z.peekMatch(amespace) {
+			z.namespace()
+			return
+		}
+		z.bareword([]rune{b})
+		return
+	case '@': // @tag, @hidden, etc.
+		for _, name := range annotations {
+			if z.peekMatch(name) {
+				z.event.Annotation(name)
+				return
+			}
 		}
 		z.bareword([]rune{b})
 		return
@@ -180,15 +468,58 @@ func (z *Tokenizer) word(b rune) {
 	}
 }
 
+// word1 reads a word in CODL 1, which only has the first six keywords and
+// the CODL pragma.
+func (z *Tokenizer) word1(b rune) {
+	switch b {
+	case 'I':
+		if z.peekMatch(mport) {
+			z.imports()
+			return
+		} else if z.peekMatch(nclude) {
+			z.include()
+			return
+		}
+	case 'R':
+		if z.peekMatch(oute) {
+			z.route()
+			return
+		}
+	case 'U':
+		if z.peekMatch(sing) {
+			z.using()
+			return
+		}
+	case 'D':
+		if z.peekMatch(oes) {
+			z.does()
+			return
+		}
+	case 'F':
+		if z.peekMatch(rom) {
+			z.from()
+			return
+		}
+	case 'C':
+		if z.tokens == 1 && z.peekMatch(odl) {
+			z.pragma()
+			return
+		}
+	}
+	z.bareword([]rune{b})
+}
+
 func (z *Tokenizer) peekMatch(word string) bool {
 	size := len(word)
-	p, err := z.input.Peek(size + 1)
+	p, err := z.input.Peek(size + 2)
 	if err != nil && err != io.EOF {
 		fmt.Printf("Received peek error. Please report: %s\n", err)
 	}
 	matches := len(p) >= size && string(p[0:size]) == word
 
-	if len(p) == size + 1 && !unicode.IsSpace(rune(p[size])) {
+	// In CODL 2, a comment may follow a keyword without a space.
+	comment := z.version >= Version2 && len(p) == size + 2 && string(p[size:]) == "//"
+	if len(p) > size && !unicode.IsSpace(rune(p[size])) && !comment {
 		return false
 	}
 
@@ -214,12 +545,12 @@ func (z *Tokenizer) bareword(prepend []rune) {
 	for {
 		if err != nil {
 			if len(buf) > 0 {
-				z.event.Strval(string(buf))
+				z.event.Bareword(string(buf))
 			}
 			z.event.Error(err)
 			return
 		} else if unicode.IsSpace(r) {
-			z.event.Strval(string(buf))
+			z.event.Bareword(string(buf))
 			// And consume the space?
 			return
 		}
@@ -227,7 +558,34 @@ func (z *Tokenizer) bareword(prepend []rune) {
 		r, _, err = z.input.ReadRune()
 	}
 
-	z.event.Strval(string(buf))
+	z.event.Bareword(string(buf))
+}
+
+// bracketed reads a list ("[a, b]") or map ("{a: b}") as one bare word,
+// including any spaces up to the closing bracket. Anything directly after
+// the bracket is part of the same word, so "[]string" is one word.
+func (z *Tokenizer) bracketed(open, close rune) {
+	buf := []rune{open}
+	var quote rune
+	for {
+		r, _, err := z.input.ReadRune()
+		if err != nil {
+			z.err(fmt.Errorf("Missing '%c' after %s", close, string(buf)))
+			return
+		}
+		buf = append(buf, r)
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == close:
+			z.bareword(buf)
+			return
+		}
+	}
 }
 
 func (z *Tokenizer) consumeSpace() bool {
@@ -282,13 +640,145 @@ func (z *Tokenizer) route() {
 	z.event.Route()
 }
 
+func (z *Tokenizer) before() {
+	z.event.Before()
+}
+
+func (z *Tokenizer) after() {
+	z.event.After()
+}
+
+func (z *Tokenizer) with() {
+	z.event.With()
+}
+
+func (z *Tokenizer) namespace() {
+	z.event.Namespace()
+}
+
+func (z *Tokenizer) use() {
+	z.event.Use()
+}
+
+func (z *Tokenizer) ifBlock() {
+	z.event.If()
+}
+
+func (z *Tokenizer) end() {
+	z.event.End()
+}
+
+
+// pragma reads the version after CODL, which selects how the rest of the
+// file is read.
+func (z *Tokenizer) pragma() {
+	z.consumeSpace()
+	var buf []rune
+	for {
+		r, _, err := z.input.ReadRune()
+		if err != nil || unicode.IsSpace(r) {
+			break
+		}
+		buf = append(buf, r)
+	}
+	v, err := strconv.Atoi(string(buf))
+	if err != nil || v < Version1 || v > Version2 {
+		z.err(fmt.Errorf("Unknown version CODL %s. Use CODL 1 or CODL 2", string(buf)))
+		return
+	}
+	z.version = v
+}
 
 func NewTokenizer(input io.Reader, e EventHandler) *Tokenizer {
 	z := Tokenizer{
 		input: bufio.NewReader(input),
 		event: e,
+		version: Version1,
 	}
 
 	return &z
 }
 
+func (z *Tokenizer) on() {
+	z.event.On()
+}
+
+func (z *Tokenizer) ignore() {
+	z.event.Ignore()
+}
+
+func (z *Tokenizer) reroute() {
+	z.event.Reroute()
+}
+
+func (z *Tokenizer) stop() {
+	z.event.Stop()
+}
+
+func (z *Tokenizer) unless() {
+	z.event.Unless()
+}
+
+func (z *Tokenizer) forEach() {
+	z.event.ForEach()
+}
+
+func (z *Tokenizer) parallel() {
+	z.event.Parallel()
+}
+
+func (z *Tokenizer) timeout() {
+	z.event.Timeout()
+}
+
+func (z *Tokenizer) retry() {
+	z.event.Retry()
+}
+
+func (z *Tokenizer) backoff() {
+	z.event.Backoff()
+}
+
+func (z *Tokenizer) context() {
+	z.event.Context()
+}
+
+func (z *Tokenizer) defaultValue() {
+	z.event.Default()
+}
+
+func (z *Tokenizer) datasource() {
+	z.event.Datasource()
+}
+
+func (z *Tokenizer) app() {
+	z.event.App()
+}
+
+func (z *Tokenizer) flags() {
+	z.event.Flags()
+}
+
+func (z *Tokenizer) subcommand() {
+	z.event.Subcommand()
+}
+
+func (z *Tokenizer) goBlock() {
+	z.event.Go()
+}
+
+func (z *Tokenizer) test() {
+	z.event.Test()
+}
+
+func (z *Tokenizer) given() {
+	z.event.Given()
+}
+
+func (z *Tokenizer) mock() {
+	z.event.Mock()
+}
+
+func (z *Tokenizer) expect() {
+	z.event.Expect()
+}
@@ -89,16 +89,55 @@ func TestKeywords(t *testing.T) {
 		"DOES": "_DOES",
 		"FROM": "_FROM",
 		"        FROM": "_FROM",
+		"USE": "_USE",
+		"NAMESPACE": "_NAMESPACE",
+		"WITH": "_WITH",
+		"BEFORE": "_BEFORE",
+		"AFTER": "_AFTER",
+		"ON": "_ON",
+		"IGNORE": "_IGNORE",
+		"REROUTE": "_REROUTE",
+		"STOP": "_STOP",
+		"UNLESS": "_UNLESS",
+		"FOREACH": "_FOREACH",
+		"PARALLEL": "_PARALLEL",
+		"TIMEOUT": "_TIMEOUT",
+		"RETRY": "_RETRY",
+		"BACKOFF": "_BACKOFF",
+		"CONTEXT": "_CONTEXT",
+		"DEFAULT": "_DEFAULT",
+		"DATASOURCE": "_DATASOURCE",
+		"APP": "_APP",
+		"FLAGS": "_FLAGS",
+		"SUBCOMMAND": "_SUBCOMMAND",
+		"GO": "_GO",
+		"GOTO": "GOTO", // This should be interpreted as a string.
+		"TEST": "_TEST",
+		"GIVEN": "_GIVEN",
+		"MOCK": "_MOCK",
+		"EXPECT": "_EXPECT",
+		"MOCKS": "MOCKS", // This should be interpreted as a string.
+		"IF": "_IF",
+		"END": "_END",
 		"IMPORTs": "IMPORTs", // This should be interpreted as a string.
 		"FROMs": "FROMs", // This should be interpreted as a string.
 		"DOE": "DOE", // This should be interpreted as a string.
 		"ROUTER": "ROUTER", // This should be interpreted as a string.
+		"@tag": "_@tag",
+		"@hidden": "_@hidden",
+		"@update": "@update", // This should be interpreted as a string.
+		"USER": "USER", // This should be interpreted as a string.
+		"IFS": "IFS", // This should be interpreted as a string.
+		"ENDS": "ENDS", // This should be interpreted as a string.
+		"ONE": "ONE", // This should be interpreted as a string.
+		"STOPS": "STOPS", // This should be interpreted as a string.
 	}
 
 	for input, output := range expectMap {
 		r := strings.NewReader(input)
 		l := new(ListenerFixture)
 		z := NewTokenizer(r, l)
+		z.version = Version2
 		z.Next()
 
 		if output != l.last {
@@ -108,6 +147,57 @@ func TestKeywords(t *testing.T) {
 
 }
 
+func TestVersions(t *testing.T) {
+	tests := []struct {
+		input, last, err string
+		version int
+	}{
+		{`"a\\b\"c"`, `ab"c`, "", Version1},
+		{`CODL 1 "a\nb"`, "anb", "", Version1},
+		{`CODL 2 "a\\b\"c"`, `a\b"c`, "", Version2},
+		{`CODL 2 'a\nb\'c'`, "a\nb'c", "", Version2},
+		{`CODL 2 "a\qb"`, "", "Unknown escape", Version2},
+		{"CODL 3", "", "Unknown version", Version1},
+		{"ROUTE CODL", "CODL", "", Version1},
+		{"CODL 2 ROUTE CODL", "_ROUTE", "CODL must come before", Version2},
+		{"IMPORT//x", "IMPORT//x", "", Version1},
+		{"CODL 2 IMPORT//x", "_IMPORT", "", Version2},
+		{"CODL 2 @hidden//x", "_@hidden", "", Version2},
+		{`CODL 2 [a, "b c", d]`, `[a, "b c", d]`, "", Version2},
+		{`CODL 2 {a: 1, b: "]"}`, `{a: 1, b: "]"}`, "", Version2},
+		{"CODL 2 []string", "[]string", "", Version2},
+		{"CODL 2 USE", "_USE", "", Version2},
+		{"[a, b]", "b]", "", Version1},
+		{"{a: 1}", "1}", "", Version1},
+		{"USE", "USE", "", Version1},
+		{"ROUTE IF", "IF", "", Version1},
+		{"DOES x END", "END", "", Version1},
+		{"USING STOP", "STOP", "", Version1},
+		{"@hidden", "@hidden", "", Version1},
+		{"CODL 1 WITH", "WITH", "", Version1},
+	}
+
+	for _, tt := range tests {
+		l := new(ListenerFixture)
+		z := NewTokenizer(strings.NewReader(tt.input), l)
+		for z.lastErr == nil {
+			z.Next()
+		}
+
+		if tt.err == "" && z.lastErr != io.EOF {
+			t.Errorf("Unexpected error in %s: %s", tt.input, z.lastErr)
+		} else if tt.err != "" && !strings.Contains(z.lastErr.Error(), tt.err) {
+			t.Errorf("Expected an error with %q in %s, got %s", tt.err, tt.input, z.lastErr)
+		}
+		if l.last != tt.last {
+			t.Errorf("Expected %q from %s, got %q", tt.last, tt.input, l.last)
+		}
+		if z.Version() != tt.version {
+			t.Errorf("Expected CODL %d from %s, got %d", tt.version, tt.input, z.Version())
+		}
+	}
+}
+
 type ListenerFixture struct {
 	last string
 	err error
@@ -122,6 +212,9 @@ func (l *ListenerFixture) Literal(str string){
 func (l *ListenerFixture) Strval(str string){
 	l.last = str
 }
+func (l *ListenerFixture) Bareword(str string){
+	l.last = str
+}
 func (l *ListenerFixture) Import(){
 	l.last = "_IMPORT"
 }
@@ -140,3 +233,90 @@ func (l *ListenerFixture) Does(){
 func (l *ListenerFixture) From(){
 	l.last = "_FROM"
 }
+func (l *ListenerFixture) If(){
+	l.last = "_IF"
+}
+func (l *ListenerFixture) End(){
+	l.last = "_END"
+}
+func (l *ListenerFixture) Annotation(name string){
+	l.last = "_@" + name
+}
+func (l *ListenerFixture) Use(){
+	l.last = "_USE"
+}
+func (l *ListenerFixture) Namespace(){
+	l.last = "_NAMESPACE"
+}
+func (l *ListenerFixture) With(){
+	l.last = "_WITH"
+}
+func (l *ListenerFixture) Before(){
+	l.last = "_BEFORE"
+}
+func (l *ListenerFixture) After(){
+	l.last = "_AFTER"
+}
+func (l *ListenerFixture) On(){
+	l.last = "_ON"
+}
+func (l *ListenerFixture) Ignore(){
+	l.last = "_IGNORE"
+}
+func (l *ListenerFixture) Reroute(){
+	l.last = "_REROUTE"
+}
+func (l *ListenerFixture) Stop(){
+	l.last = "_STOP"
+}
+func (l *ListenerFixture) Unless(){
+	l.last = "_UNLESS"
+}
+func (l *ListenerFixture) ForEach(){
+	l.last = "_FOREACH"
+}
+func (l *ListenerFixture) Parallel(){
+	l.last = "_PARALLEL"
+}
+func (l *ListenerFixture) Timeout(){
+	l.last = "_TIMEOUT"
+}
+func (l *ListenerFixture) Retry(){
+	l.last = "_RETRY"
+}
+func (l *ListenerFixture) Backoff(){
+	l.last = "_BACKOFF"
+}
+func (l *ListenerFixture) Context(){
+	l.last = "_CONTEXT"
+}
+func (l *ListenerFixture) Default(){
+	l.last = "_DEFAULT"
+}
+func (l *ListenerFixture) Datasource(){
+	l.last = "_DATASOURCE"
+}
+func (l *ListenerFixture) App(){
+	l.last = "_APP"
+}
+func (l *ListenerFixture) Flags(){
+	l.last = "_FLAGS"
+}
+func (l *ListenerFixture) Subcommand(){
+	l.last = "_SUBCOMMAND"
+}
+func (l *ListenerFixture) Go(){
+	l.last = "_GO"
+}
+func (l *ListenerFixture) Test(){
+	l.last = "_TEST"
+}
+func (l *ListenerFixture) Given(){
+	l.last = "_GIVEN"
+}
+func (l *ListenerFixture) Mock(){
+	l.last = "_MOCK"
+}
+func (l *ListenerFixture) Expect(){
+	l.last = "_EXPECT"
+}
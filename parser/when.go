@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unless starts an UNLESS condition on the current DOES. The next string is
+// the source to test.
+func (l *handler) Unless() {
+	if !l.unannotated("UNLESS") {
+		return
+	}
+	if l.currentDoes() == nil {
+		l.err = fmt.Errorf("UNLESS must come after a DOES")
+		return
+	}
+	l.unless = true
+}
+
+// when makes the current DOES depend on a source, such as "cxt:h". It is
+// called for "IF cxt:h" and "UNLESS cxt:h".
+func (l *handler) when(source string, unless bool) {
+	keyword := "IF"
+	if unless {
+		keyword = "UNLESS"
+	}
+	c := l.currentDoes()
+	if c == nil {
+		l.err = fmt.Errorf("%s %s must come after a DOES", keyword, source)
+		return
+	}
+	if !strings.Contains(source, ":") {
+		l.err = fmt.Errorf("%s takes a source such as cxt:name, not %s", keyword, source)
+		return
+	}
+	if c.Condition != "" {
+		l.err = fmt.Errorf("DOES %s can only have one IF or UNLESS", c.Name)
+		return
+	}
+	c.Condition = asString(source)
+	c.Unless = unless
+}
+
+// currentDoes returns the DOES that a modifier, such as an IF or UNLESS
+// condition, applies to here, or nil.
+func (l *handler) currentDoes() *Command {
+	if l.currentRoute == nil || l.withParam != nil {
+		return nil
+	}
+	switch l.mode {
+	case DoesMode, UsingMode, FromMode:
+		return l.currentRoute.currentCommand
+	}
+	return nil
+}
+
+// unlessDone reports whether an UNLESS has its source. If it does not, that
+// is an error.
+func (l *handler) unlessDone() bool {
+	if !l.unless {
+		return true
+	}
+	l.err = fmt.Errorf("UNLESS requires a source such as cxt:name")
+	return false
+}
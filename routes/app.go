@@ -4,43 +4,204 @@ package routes
 
 import (
 	"github.com/Masterminds/cookoo"
+	"github.com/Masterminds/codl/support"
 	`github.com/Masterminds/cookoo/cli`
 	`github.com/Masterminds/codl/cmd`
+	`flag`
 	
 )
 
+var buildFlags = func() *flag.FlagSet {
+	flags := flag.NewFlagSet("build", flag.PanicOnError)
+	flags.Bool(`h`, false, `Show build help`)
+	flags.String(`d`, ".", `The directory to look for CODL files.`)
+	flags.String(`profile`, "", `Build only the IF blocks for this profile.`)
+	flags.Bool(`tags`, false, `Write one file per profile, selected with Go build tags.`)
+	flags.Bool(`strict`, false, `Allow only constants and allowed names in code literals.`)
+	return flags
+}()
+
+var openapiFlags = func() *flag.FlagSet {
+	flags := flag.NewFlagSet("openapi", flag.PanicOnError)
+	flags.Bool(`h`, false, `Show openapi help`)
+	flags.String(`d`, ".", `The directory to look for CODL files.`)
+	flags.String(`format`, "json", `The output format: json or yaml.`)
+	flags.String(`title`, "API", `The API's title.`)
+	flags.String(`apiversion`, "1.0.0", `The API's version.`)
+	flags.String(`o`, "", `The file to write. The default is stdout.`)
+	return flags
+}()
+
+var completionFlags = func() *flag.FlagSet {
+	flags := flag.NewFlagSet("completion", flag.PanicOnError)
+	flags.Bool(`h`, false, `Show completion help`)
+	flags.String(`d`, ".", `The directory to look for CODL files.`)
+	flags.String(`name`, "", `The program to complete. The default is the APP's name.`)
+	flags.String(`o`, "", `The file to write. The default is stdout.`)
+	return flags
+}()
+
+var migrateFlags = func() *flag.FlagSet {
+	flags := flag.NewFlagSet("migrate", flag.PanicOnError)
+	flags.Bool(`h`, false, `Show migrate help`)
+	flags.String(`d`, ".", `The directory to look for CODL files.`)
+	return flags
+}()
+
+var auditFlags = func() *flag.FlagSet {
+	flags := flag.NewFlagSet("audit", flag.PanicOnError)
+	flags.Bool(`h`, false, `Show audit help`)
+	flags.String(`d`, ".", `The directory to search for CODL files.`)
+	return flags
+}()
+
+var codlFlags = func() *flag.FlagSet {
+	flags := flag.NewFlagSet("codl", flag.PanicOnError)
+	flags.Bool(`h`, false, `Show help text and exit.`)
+	return flags
+}()
+
 func AppRoutes(reg *cookoo.Registry) {
 	reg.Route(`@update`, `Updates all given CODL files`).
 	Does(cmd.Translate, `created`).
 			Using(`files`).From(`cxt:files`).
-			Using(`skipEmpty`).WithDefault(true)
+			Using(`skipEmpty`).WithDefault(true).
+			Using(`profile`).From(`cxt:profile`).
+			Using(`tags`).From(`cxt:tags`).
+			Using(`strict`).From(`cxt:strict`)
 	reg.Route(`build`, `Build all CODL files in the given directory`).
-	Does(cli.ParseArgs, `build.Args`).
+	Does(cli.ParseArgs, `@args`).
 			Using(`subcommand`).WithDefault(true).
 			Using(`args`).From(`cxt:runner.Args`).
 			Using(`flagset`).WithDefault(buildFlags).
-	Does(cli.ShowHelp, `help`).
+	Does(cli.ShowHelp, `@help`).
 			Using(`show`).From(`cxt:h`).
-			Using(`summary`).WithDefault(`Transform CODL files into Go source.`).
+			Using(`summary`).WithDefault(`Build all CODL files in the given directory`).
 			Using(`flags`).WithDefault(buildFlags).
 	Does(cmd.FindCodl, `files`).
 			Using(`dir`).From(`cxt:d`).
 	Does(cmd.Translate, `created`).
 			Using(`files`).From(`cxt:files`).
-			Using(`skipEmpty`).WithDefault(true)
+			Using(`skipEmpty`).WithDefault(true).
+			Using(`profile`).From(`cxt:profile`).
+			Using(`tags`).From(`cxt:tags`).
+			Using(`strict`).From(`cxt:strict`)
 	reg.Route(`watch`, `Watch all files in a directory for changes.`).
-	Does(cli.ParseArgs, `build.Args`).
+	Does(cli.ParseArgs, `@args`).
 			Using(`subcommand`).WithDefault(true).
 			Using(`args`).From(`cxt:runner.Args`).
 			Using(`flagset`).WithDefault(buildFlags).
-	Does(cli.ShowHelp, `help`).
+	Does(cli.ShowHelp, `@help`).
 			Using(`show`).From(`cxt:h`).
-			Using(`summary`).WithDefault(`Watch CODL files and transform them to Go when they are modified.`).
+			Using(`summary`).WithDefault(`Watch all files in a directory for changes.`).
 			Using(`flags`).WithDefault(buildFlags).
 	Does(cmd.Watch, `watch`).
 			Using(`dir`).From(`cxt:d`)
+	reg.Route(`openapi`, `Write an OpenAPI document for the HTTP routes in a directory`).
+	Does(cli.ParseArgs, `@args`).
+			Using(`subcommand`).WithDefault(true).
+			Using(`args`).From(`cxt:runner.Args`).
+			Using(`flagset`).WithDefault(openapiFlags).
+	Does(cli.ShowHelp, `@help`).
+			Using(`show`).From(`cxt:h`).
+			Using(`summary`).WithDefault(`Write an OpenAPI document for the HTTP routes in a directory`).
+			Using(`flags`).WithDefault(openapiFlags).
+	Does(cmd.FindCodl, `files`).
+			Using(`dir`).From(`cxt:d`).
+	Does(cmd.OpenAPI, `doc`).
+			Using(`files`).From(`cxt:files`).
+			Using(`format`).From(`cxt:format`).
+			Using(`title`).From(`cxt:title`).
+			Using(`version`).From(`cxt:apiversion`).
+			Using(`out`).From(`cxt:o`)
+	reg.Route(`completion`, `Write a shell completion script: bash, zsh or fish`).
+	Does(cli.ParseArgs, `@args`).
+			Using(`subcommand`).WithDefault(true).
+			Using(`args`).From(`cxt:runner.Args`).
+			Using(`flagset`).WithDefault(completionFlags).
+	Does(cli.ShowHelp, `@help`).
+			Using(`show`).From(`cxt:h`).
+			Using(`summary`).WithDefault(`Write a shell completion script: bash, zsh or fish`).
+			Using(`flags`).WithDefault(completionFlags).
+	Does(cmd.Positional, `shell`).
+			Using(`args`).From(`cxt:@args`).
+			Using(`flagset`).WithDefault(completionFlags).
+	Does(cmd.FindCodl, `files`).
+			Using(`dir`).From(`cxt:d`).
+	Does(cmd.Completion, `script`).
+			Using(`files`).From(`cxt:files`).
+			Using(`args`).From(`cxt:shell`).
+			Using(`name`).From(`cxt:name`).
+			Using(`out`).From(`cxt:o`)
+	reg.Route(`migrate`, `Rewrite the CODL 1 files in a directory as CODL 2`).
+	Does(cli.ParseArgs, `@args`).
+			Using(`subcommand`).WithDefault(true).
+			Using(`args`).From(`cxt:runner.Args`).
+			Using(`flagset`).WithDefault(migrateFlags).
+	Does(cli.ShowHelp, `@help`).
+			Using(`show`).From(`cxt:h`).
+			Using(`summary`).WithDefault(`Rewrite the CODL 1 files in a directory as CODL 2`).
+			Using(`flags`).WithDefault(migrateFlags).
+	Does(cmd.FindCodl, `files`).
+			Using(`dir`).From(`cxt:d`).
+	Does(cmd.Migrate, `migrated`).
+			Using(`files`).From(`cxt:files`)
+	reg.Route(`audit`, `List the code literals in a tree of CODL files`).
+	Does(cli.ParseArgs, `@args`).
+			Using(`subcommand`).WithDefault(true).
+			Using(`args`).From(`cxt:runner.Args`).
+			Using(`flagset`).WithDefault(auditFlags).
+	Does(cli.ShowHelp, `@help`).
+			Using(`show`).From(`cxt:h`).
+			Using(`summary`).WithDefault(`List the code literals in a tree of CODL files`).
+			Using(`flags`).WithDefault(auditFlags).
+	Does(cmd.Audit, `literals`).
+			Using(`dir`).From(`cxt:d`)
 	reg.Route(`version`, `Print version and exit`).
 	Does(cmd.Version, `ver`).
 			Using(`version`).From(`cxt:version`)
 	
 }
+
+// AppRun registers the routes and runs the subcommand that the
+// command line names.
+func AppRun(reg *cookoo.Registry, router *cookoo.Router, cxt cookoo.Context) error {
+	cxt.Put("router", router)
+	AppRoutes(reg)
+	return cli.New(reg, router, cxt).Help(`Converts CODL files to Go source code`, `The CODL transformer transform CODL files into Go source code.
+
+Commands:
+
+- help: Show help text and exit.
+- build: Convert ".codl" files to ".go" files.
+- watch: Watch all .codl files in a directory for changes, and transform them.
+- openapi: Write an OpenAPI document for the HTTP routes in a directory.
+- completion: Write a bash, zsh or fish completion script.
+- migrate: Rewrite CODL 1 files in a directory as CODL 2.
+- audit: List the code literals in a tree of CODL files.
+
+Examples:
+
+$ codl build -d routes/           # Convert all .codl files in routes/
+$ codl watch -d routes/           # Watch routes/ for changed .codl files.
+$ codl openapi -d routes/         # Write an OpenAPI document to stdout.
+$ codl completion bash -d routes/ # Write a bash completion script.
+$ codl migrate -d routes/         # Rewrite routes/*.codl as CODL 2.
+$ codl build -strict -d routes/   # Build only if the code literals are safe.
+$ codl audit -d .                 # List every code literal in a tree.
+$ codl -h                         # Show global help.
+$ codl watch -h                   # Show help for the 'codl watch' command.
+`, codlFlags).RunSubcommand()
+}
+
+// AppRouteMeta describes each route in AppRoutes.
+var AppRouteMeta = map[string]support.RouteMeta{
+	`@update`: {Description: `Updates all given CODL files`, Hidden: true, },
+	`build`: {Description: `Build all CODL files in the given directory`, },
+	`watch`: {Description: `Watch all files in a directory for changes.`, },
+	`openapi`: {Description: `Write an OpenAPI document for the HTTP routes in a directory`, },
+	`completion`: {Description: `Write a shell completion script: bash, zsh or fish`, },
+	`migrate`: {Description: `Rewrite the CODL 1 files in a directory as CODL 2`, },
+	`audit`: {Description: `List the code literals in a tree of CODL files`, },
+	`version`: {Description: `Print version and exit`, },
+}
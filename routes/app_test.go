@@ -0,0 +1,52 @@
+package routes
+
+// This file is auto-generated by Codl from the TEST statements in app.codl.
+
+import (
+	"testing"
+	"github.com/Masterminds/cookoo"
+	`github.com/Masterminds/codl/support`
+	`github.com/Masterminds/cookoo/cli`
+	`github.com/Masterminds/codl/cmd`
+	
+)
+
+// TestBuildFindsFiles runs the TEST build finds files.
+func TestBuildFindsFiles(t *testing.T) {
+	reg, router, cxt := cookoo.Cookoo()
+	AppRoutes(reg)
+	reg.Route(`@update`, `Updates all given CODL files`).
+	Does(support.Mock([]string{}), `created`).
+			Using(`files`).From(`cxt:files`).
+			Using(`skipEmpty`).WithDefault(true).
+			Using(`profile`).From(`cxt:profile`).
+			Using(`tags`).From(`cxt:tags`).
+			Using(`strict`).From(`cxt:strict`)
+	reg.Route(`build`, `Build all CODL files in the given directory`).
+	Does(cli.ParseArgs, `@args`).
+			Using(`subcommand`).WithDefault(true).
+			Using(`args`).From(`cxt:runner.Args`).
+			Using(`flagset`).WithDefault(buildFlags).
+	Does(cli.ShowHelp, `@help`).
+			Using(`show`).From(`cxt:h`).
+			Using(`summary`).WithDefault(`Build all CODL files in the given directory`).
+			Using(`flags`).WithDefault(buildFlags).
+	Does(cmd.FindCodl, `files`).
+			Using(`dir`).From(`cxt:d`).
+	Does(support.Mock([]string{}), `created`).
+			Using(`files`).From(`cxt:files`).
+			Using(`skipEmpty`).WithDefault(true).
+			Using(`profile`).From(`cxt:profile`).
+			Using(`tags`).From(`cxt:tags`).
+			Using(`strict`).From(`cxt:strict`)
+	support.ResetFlags(buildFlags)
+	cxt.Put("runner.Args", []string{`build`, support.FlagArg(`d`, `.`)})
+	cxt.Put(`d`, `.`)
+
+	if err := router.HandleRequest(`build`, cxt, false); err != nil {
+		t.Fatalf("Route %s failed: %s", `build`, err)
+	}
+	if err := support.CheckLen(cxt, `files`, 1); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,22 @@
+package support
+
+import (
+	"sync"
+
+	"github.com/Masterminds/cookoo"
+)
+
+// Deprecated returns a command that logs a warning that a route is
+// deprecated. It logs the first time it runs, and never again.
+//
+// CODL adds this as the first command of every route that is marked with
+// @deprecated.
+func Deprecated(route, message string) cookoo.Command {
+	var once sync.Once
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		once.Do(func() {
+			c.Logf("warn", "Route %s is deprecated: %s\n", route, message)
+		})
+		return nil, nil
+	}
+}
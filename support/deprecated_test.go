@@ -0,0 +1,32 @@
+package support
+
+import (
+	"testing"
+
+	"github.com/Masterminds/cookoo"
+)
+
+// logContext records calls to Logf.
+type logContext struct {
+	cookoo.Context
+	logs []string
+}
+
+func (l *logContext) Logf(prefix, format string, v ...interface{}) {
+	l.logs = append(l.logs, prefix)
+}
+
+func TestDeprecated(t *testing.T) {
+	c := &logContext{Context: cookoo.NewContext()}
+	cmd := Deprecated("old", "use new")
+
+	for i := 0; i < 3; i++ {
+		if _, irq := cmd(c, cookoo.NewParamsWithValues(nil)); irq != nil {
+			t.Errorf("Unexpected interrupt: %v", irq)
+		}
+	}
+
+	if len(c.logs) != 1 || c.logs[0] != "warn" {
+		t.Errorf("Expected one warning, got %v", c.logs)
+	}
+}
@@ -0,0 +1,53 @@
+package support
+
+import (
+	"github.com/Masterminds/cookoo"
+)
+
+// ErrorKey is the context key under which OnError and IgnoreErrors store
+// the error of a command that failed. A route that handles errors can read
+// it with "FROM cxt:codl.error".
+const ErrorKey = "codl.error"
+
+// OnError returns a command that runs cmd, and reroutes to route if cmd
+// fails. The error is stored in the context under ErrorKey.
+//
+// CODL wraps a command in this for ON ERROR REROUTE.
+func OnError(cmd cookoo.Command, route string) cookoo.Command {
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		out, irq := cmd(c, p)
+		if err := failure(irq); err != nil {
+			c.Put(ErrorKey, err)
+			return out, &cookoo.Reroute{Route: route}
+		}
+		return out, irq
+	}
+}
+
+// IgnoreErrors returns a command that runs cmd, and logs a warning instead
+// of stopping the route if cmd fails. The error is stored in the context
+// under ErrorKey.
+//
+// CODL wraps a command in this for IGNORE ERRORS.
+func IgnoreErrors(cmd cookoo.Command) cookoo.Command {
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		out, irq := cmd(c, p)
+		if err := failure(irq); err != nil {
+			c.Put(ErrorKey, err)
+			c.Logf("warn", "Ignoring error: %s\n", err)
+			return out, nil
+		}
+		return out, irq
+	}
+}
+
+// failure returns the error that an interrupt reports, if any. A Reroute or
+// a Stop is not a failure, even if it implements error.
+func failure(irq cookoo.Interrupt) error {
+	switch irq.(type) {
+	case *cookoo.Reroute, *cookoo.Stop:
+		return nil
+	}
+	err, _ := irq.(error)
+	return err
+}
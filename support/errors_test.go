@@ -0,0 +1,56 @@
+package support
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Masterminds/cookoo"
+)
+
+func fails(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	return "partial", errors.New("boom")
+}
+
+func stops(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	return nil, &cookoo.Stop{}
+}
+
+func TestOnError(t *testing.T) {
+	c := cookoo.NewContext()
+	out, irq := OnError(fails, "errors.show")(c, cookoo.NewParamsWithValues(nil))
+
+	rr, ok := irq.(*cookoo.Reroute)
+	if !ok || rr.Route != "errors.show" {
+		t.Fatalf("Expected a reroute to errors.show, got %v", irq)
+	}
+	if out != "partial" {
+		t.Errorf("Expected the command's output, got %v", out)
+	}
+	if err, ok := c.Get(ErrorKey, nil).(error); !ok || err.Error() != "boom" {
+		t.Errorf("Expected the error under %s", ErrorKey)
+	}
+
+	if _, irq := OnError(stops, "errors.show")(c, cookoo.NewParamsWithValues(nil)); irq == nil {
+		t.Errorf("Expected a Stop to pass through")
+	} else if _, ok := irq.(*cookoo.Stop); !ok {
+		t.Errorf("Expected a Stop, got %v", irq)
+	}
+}
+
+func TestIgnoreErrors(t *testing.T) {
+	c := &logContext{Context: cookoo.NewContext()}
+	out, irq := IgnoreErrors(fails)(c, cookoo.NewParamsWithValues(nil))
+
+	if irq != nil {
+		t.Errorf("Expected the error to be ignored, got %v", irq)
+	}
+	if out != "partial" {
+		t.Errorf("Expected the command's output, got %v", out)
+	}
+	if len(c.logs) != 1 || c.logs[0] != "warn" {
+		t.Errorf("Expected one warning, got %v", c.logs)
+	}
+	if _, ok := c.Has(ErrorKey); !ok {
+		t.Errorf("Expected the error under %s", ErrorKey)
+	}
+}
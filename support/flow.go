@@ -0,0 +1,23 @@
+package support
+
+import (
+	"github.com/Masterminds/cookoo"
+)
+
+// Reroute returns a command that sends the request on to another route.
+//
+// CODL adds this for a REROUTE statement.
+func Reroute(route string) cookoo.Command {
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		return nil, &cookoo.Reroute{Route: route}
+	}
+}
+
+// Stop returns a command that ends the route without an error.
+//
+// CODL adds this for a STOP statement.
+func Stop() cookoo.Command {
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		return nil, &cookoo.Stop{}
+	}
+}
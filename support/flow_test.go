@@ -0,0 +1,21 @@
+package support
+
+import (
+	"testing"
+
+	"github.com/Masterminds/cookoo"
+)
+
+func TestReroute(t *testing.T) {
+	_, irq := Reroute("next")(cookoo.NewContext(), cookoo.NewParamsWithValues(nil))
+	if rr, ok := irq.(*cookoo.Reroute); !ok || rr.Route != "next" {
+		t.Errorf("Expected a reroute to next, got %v", irq)
+	}
+}
+
+func TestStop(t *testing.T) {
+	_, irq := Stop()(cookoo.NewContext(), cookoo.NewParamsWithValues(nil))
+	if _, ok := irq.(*cookoo.Stop); !ok {
+		t.Errorf("Expected a stop, got %v", irq)
+	}
+}
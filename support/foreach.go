@@ -0,0 +1,80 @@
+package support
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/Masterminds/cookoo"
+)
+
+// ForEach returns a command that runs cmd once for each element of the
+// slice or array found at source, such as "cxt:files". Each element is
+// passed to cmd as the parameter name, along with the command's other
+// parameters. The outputs are returned as a []interface{}, in the order
+// of the elements. A missing source runs cmd no times.
+//
+// If parallel is 0, the elements are run one at a time, and the first
+// interrupt ends the loop. Otherwise, up to parallel elements run at once,
+// each on its own copy of the context, so changes they make to the context
+// are not kept. All elements are run, and the interrupt of the first
+// element that had one is returned.
+//
+// CODL wraps a command in this for "DOES ... FOREACH name IN source".
+func ForEach(cmd cookoo.Command, name, source string, parallel int) cookoo.Command {
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		v, ok := Lookup(c, source)
+		if !ok {
+			return []interface{}{}, nil
+		}
+		items := reflect.ValueOf(v)
+		if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+			return nil, fmt.Errorf("FOREACH %s: %s is a %T, not a slice", name, source, v)
+		}
+
+		out := make([]interface{}, items.Len())
+		run := func(c cookoo.Context, i int) cookoo.Interrupt {
+			var irq cookoo.Interrupt
+			out[i], irq = cmd(c, withParam(p, name, items.Index(i).Interface()))
+			return irq
+		}
+
+		if parallel <= 0 {
+			for i := range out {
+				if irq := run(c, i); irq != nil {
+					return out[:i+1], irq
+				}
+			}
+			return out, nil
+		}
+
+		irqs := make([]cookoo.Interrupt, len(out))
+		sem := make(chan struct{}, parallel)
+		var wg sync.WaitGroup
+		for i := range out {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, c cookoo.Context) {
+				defer func() { <-sem; wg.Done() }()
+				irqs[i] = run(c, i)
+			}(i, c.Copy())
+		}
+		wg.Wait()
+		for _, irq := range irqs {
+			if irq != nil {
+				return out, irq
+			}
+		}
+		return out, nil
+	}
+}
+
+// withParam returns a copy of p with one more parameter.
+func withParam(p *cookoo.Params, name string, value interface{}) *cookoo.Params {
+	values := make(map[string]interface{}, p.Len()+1)
+	for k, v := range p.AsMap() {
+		values[k] = v
+	}
+	values[name] = value
+	return cookoo.NewParamsWithValues(values)
+}
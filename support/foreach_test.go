@@ -0,0 +1,92 @@
+package support
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Masterminds/cookoo"
+)
+
+func double(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	n := p.Get("n", 0).(int)
+	if n < 0 {
+		return nil, errors.New("negative")
+	}
+	return n * p.Get("by", 1).(int), nil
+}
+
+func TestForEach(t *testing.T) {
+	c := cookoo.NewContext()
+	c.Put("nums", []int{1, 2, 3})
+	p := cookoo.NewParamsWithValues(map[string]interface{}{"by": 2})
+
+	for _, parallel := range []int{0, 2} {
+		out, irq := ForEach(double, "n", "cxt:nums", parallel)(c, p)
+		if irq != nil {
+			t.Fatalf("Unexpected interrupt: %v", irq)
+		}
+		got := out.([]interface{})
+		if len(got) != 3 || got[0] != 2 || got[1] != 4 || got[2] != 6 {
+			t.Errorf("Expected [2 4 6] with PARALLEL %d, got %v", parallel, got)
+		}
+	}
+	if _, ok := p.Has("n"); ok {
+		t.Errorf("Expected the command's parameters to be left alone")
+	}
+
+	out, irq := ForEach(double, "n", "cxt:missing", 0)(c, p)
+	if irq != nil || len(out.([]interface{})) != 0 {
+		t.Errorf("Expected a missing source to run nothing, got %v, %v", out, irq)
+	}
+
+	c.Put("word", "abc")
+	if _, irq := ForEach(double, "n", "cxt:word", 0)(c, p); irq == nil {
+		t.Errorf("Expected an error for a source that is not a slice")
+	}
+}
+
+func TestForEachErrors(t *testing.T) {
+	c := cookoo.NewContext()
+	c.Put("nums", []int{1, -1, 3})
+	p := cookoo.NewParamsWithValues(nil)
+
+	out, irq := ForEach(double, "n", "cxt:nums", 0)(c, p)
+	if irq == nil || len(out.([]interface{})) != 2 {
+		t.Errorf("Expected the loop to stop at the error, got %v, %v", out, irq)
+	}
+
+	out, irq = ForEach(double, "n", "cxt:nums", 3)(c, p)
+	if irq == nil || out.([]interface{})[2] != 3 {
+		t.Errorf("Expected every element to run in parallel, got %v, %v", out, irq)
+	}
+}
+
+func TestForEachParallelLimit(t *testing.T) {
+	c := cookoo.NewContext()
+	c.Put("nums", make([]int, 20))
+
+	var running, most int32
+	cmd := func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		c.Put("scratch", n)
+		atomic.AddInt32(&running, -1)
+		return nil, nil
+	}
+
+	if _, irq := ForEach(cmd, "n", "cxt:nums", 4)(c, cookoo.NewParamsWithValues(nil)); irq != nil {
+		t.Fatalf("Unexpected interrupt: %v", irq)
+	}
+	if most > 4 {
+		t.Errorf("Expected at most 4 at once, got %d", most)
+	}
+	if _, ok := c.Has("scratch"); ok {
+		t.Errorf("Expected parallel changes to the context to be dropped")
+	}
+}
@@ -0,0 +1,68 @@
+// Package support contains the runtime helpers used by Go code that CODL
+// generates.
+//
+// Nothing in here is needed to write routes by hand. Generated route files
+// import this package when they use a feature that needs it.
+package support
+
+import (
+	"sort"
+)
+
+// RouteMeta describes a route and the annotations on it.
+//
+// Generated files contain a map of route names to RouteMeta.
+type RouteMeta struct {
+	Description string
+	Tags        []string
+	Owner       string
+	// Deprecated is the deprecation message. It is empty if the route is not
+	// deprecated.
+	Deprecated string
+	Hidden     bool
+	// Commands holds the annotations on the route's commands, by command name.
+	// Commands without annotations are omitted.
+	Commands map[string]CommandMeta
+}
+
+// CommandMeta describes the annotations on one DOES of a route.
+type CommandMeta struct {
+	Tags       []string
+	Owner      string
+	Deprecated string
+	Hidden     bool
+}
+
+// HasTag reports whether a route is tagged with the given tag.
+func (m RouteMeta) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Visible returns the names of all routes that are not hidden, sorted.
+func Visible(meta map[string]RouteMeta) []string {
+	names := []string{}
+	for name, m := range meta {
+		if !m.Hidden {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tagged returns the names of all routes with the given tag, sorted.
+func Tagged(meta map[string]RouteMeta, tag string) []string {
+	names := []string{}
+	for name, m := range meta {
+		if m.HasTag(tag) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,27 @@
+package support
+
+import (
+	"testing"
+)
+
+func TestVisibleAndTagged(t *testing.T) {
+	meta := map[string]RouteMeta{
+		"build":     {Tags: []string{"cli"}},
+		"@update":   {Hidden: true},
+		"api.users": {Tags: []string{"api", "cli"}},
+	}
+
+	visible := Visible(meta)
+	if len(visible) != 2 || visible[0] != "api.users" || visible[1] != "build" {
+		t.Errorf("Expected [api.users build], got %v", visible)
+	}
+
+	tagged := Tagged(meta, "api")
+	if len(tagged) != 1 || tagged[0] != "api.users" {
+		t.Errorf("Expected [api.users], got %v", tagged)
+	}
+
+	if len(Tagged(meta, "nope")) != 0 {
+		t.Errorf("Expected no routes tagged 'nope'")
+	}
+}
@@ -0,0 +1,124 @@
+package support
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/cookoo"
+)
+
+// Step is one command in a Parallel group, along with its parameters. It is
+// declared like a command in a cookoo.Registry:
+//
+//	NewStep(db.Users, "users").Using("limit").WithDefault(10).From("query:limit")
+type Step struct {
+	cmd    cookoo.Command
+	name   string
+	params []*stepParam
+}
+
+type stepParam struct {
+	name  string
+	value interface{}
+	from  []string
+}
+
+// NewStep creates a step that runs cmd and stores its output as name.
+func NewStep(cmd cookoo.Command, name string) *Step {
+	return &Step{cmd: cmd, name: name}
+}
+
+// Using adds a parameter to the step.
+func (s *Step) Using(name string) *Step {
+	s.params = append(s.params, &stepParam{name: name})
+	return s
+}
+
+// WithDefault sets the default value of the last parameter.
+func (s *Step) WithDefault(value interface{}) *Step {
+	s.params[len(s.params)-1].value = value
+	return s
+}
+
+// From sets the sources of the last parameter, such as "cxt:name". The first
+// one that has a value is used.
+func (s *Step) From(sources ...string) *Step {
+	p := s.params[len(s.params)-1]
+	p.from = append(p.from, sources...)
+	return s
+}
+
+// resolve builds the step's parameters from a context.
+func (s *Step) resolve(c cookoo.Context) *cookoo.Params {
+	values := make(map[string]interface{}, len(s.params))
+	for _, p := range s.params {
+		values[p.name] = p.value
+		for _, src := range p.from {
+			if v, ok := Lookup(c, src); ok {
+				values[p.name] = v
+				break
+			}
+		}
+	}
+	return cookoo.NewParamsWithValues(values)
+}
+
+// Errors is the failures of several commands that ran at the same time.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Parallel returns a command that runs the steps at the same time.
+//
+// Each step runs on its own copy of the context. When all are done, the
+// output of each is put into the context under its name, in the order of
+// the steps. Nothing else that a step puts into the context is kept. The
+// command's own output is a map of the outputs by name.
+//
+// If any steps fail, their errors are returned together as Errors.
+// Otherwise, the first Reroute or Stop, in the order of the steps, is
+// returned.
+//
+// CODL generates this for a PARALLEL block.
+func Parallel(steps ...*Step) cookoo.Command {
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		outs := make([]interface{}, len(steps))
+		irqs := make([]cookoo.Interrupt, len(steps))
+
+		var wg sync.WaitGroup
+		for i, s := range steps {
+			wg.Add(1)
+			go func(i int, s *Step, c cookoo.Context) {
+				defer wg.Done()
+				outs[i], irqs[i] = s.cmd(c, s.resolve(c))
+			}(i, s, c.Copy())
+		}
+		wg.Wait()
+
+		all := make(map[string]interface{}, len(steps))
+		for i, s := range steps {
+			c.Put(s.name, outs[i])
+			all[s.name] = outs[i]
+		}
+
+		var errs Errors
+		var flow cookoo.Interrupt
+		for _, irq := range irqs {
+			if err := failure(irq); err != nil {
+				errs = append(errs, err)
+			} else if irq != nil && flow == nil {
+				flow = irq
+			}
+		}
+		if len(errs) > 0 {
+			return all, errs
+		}
+		return all, flow
+	}
+}
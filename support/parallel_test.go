@@ -0,0 +1,62 @@
+package support
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Masterminds/cookoo"
+)
+
+func TestParallel(t *testing.T) {
+	c := cookoo.NewContext()
+	c.Put("id", 7)
+
+	echo := func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		// Each step writes to its own copy of the context.
+		c.Put("scratch", p.Get("v", nil))
+		return p.Get("v", nil), nil
+	}
+	cmd := Parallel(
+		NewStep(echo, "a").Using("v").WithDefault(1),
+		NewStep(echo, "b").Using("v").WithDefault(2).From("cxt:missing", "cxt:id"),
+		NewStep(echo, "c"),
+	)
+
+	out, irq := cmd(c, cookoo.NewParamsWithValues(nil))
+	if irq != nil {
+		t.Fatalf("Unexpected interrupt: %v", irq)
+	}
+	if c.Get("a", nil) != 1 || c.Get("b", nil) != 7 || c.Get("c", nil) != nil {
+		t.Errorf("Expected outputs a=1 b=7 c=nil, got %v %v %v", c.Get("a", nil), c.Get("b", nil), c.Get("c", nil))
+	}
+	if all := out.(map[string]interface{}); len(all) != 3 || all["b"] != 7 {
+		t.Errorf("Expected a map of outputs, got %v", all)
+	}
+	if _, ok := c.Has("scratch"); ok {
+		t.Errorf("Expected changes to the context copies to be dropped")
+	}
+}
+
+func TestParallelErrors(t *testing.T) {
+	fail := func(msg string) cookoo.Command {
+		return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+			return nil, errors.New(msg)
+		}
+	}
+
+	_, irq := Parallel(
+		NewStep(fail("one"), "a"),
+		NewStep(stops, "b"),
+		NewStep(fail("two"), "c"),
+	)(cookoo.NewContext(), cookoo.NewParamsWithValues(nil))
+
+	errs, ok := irq.(Errors)
+	if !ok || len(errs) != 2 || errs.Error() != "one; two" {
+		t.Errorf("Expected both errors, got %v", irq)
+	}
+
+	_, irq = Parallel(NewStep(ran, "a"), NewStep(stops, "b"))(cookoo.NewContext(), cookoo.NewParamsWithValues(nil))
+	if _, ok := irq.(*cookoo.Stop); !ok {
+		t.Errorf("Expected a Stop, got %v", irq)
+	}
+}
@@ -0,0 +1,70 @@
+package support
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/cookoo"
+)
+
+// Timeout returns a command that runs cmd, and fails with a
+// cookoo.RecoverableError if cmd does not finish within d.
+//
+// Like the steps of Parallel, cmd runs on a copy of the context. If it
+// finishes in time, what it put into the copy is put into the context. Go
+// cannot stop a running function, so a command that times out is left to
+// finish in the background with its copy, and whatever it does is dropped.
+//
+// CODL wraps a command in this for "DOES ... TIMEOUT d".
+func Timeout(cmd cookoo.Command, d time.Duration) cookoo.Command {
+	type result struct {
+		out interface{}
+		irq cookoo.Interrupt
+	}
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		done := make(chan result, 1)
+		cc := c.Copy()
+		go func() {
+			out, irq := cmd(cc, p)
+			done <- result{out, irq}
+		}()
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case r := <-done:
+			for k, v := range cc.AsMap() {
+				c.Put(k, v)
+			}
+			return r.out, r.irq
+		case <-timer.C:
+			return nil, &cookoo.RecoverableError{Message: fmt.Sprintf("Timed out after %s", d)}
+		}
+	}
+}
+
+// Retry returns a command that runs cmd, and runs it again, up to retries
+// more times, while it fails with a cookoo.RecoverableError. It waits
+// backoff before the first retry, and twice as long before each one after
+// that. Other interrupts are returned at once.
+//
+// The number of times cmd was run is put into the context as
+// "name.attempts", where name is the name of the command.
+//
+// CODL wraps a command in this for "DOES ... RETRY n BACKOFF d".
+func Retry(cmd cookoo.Command, name string, retries int, backoff time.Duration) cookoo.Command {
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		wait := backoff
+		for attempt := 1; ; attempt++ {
+			out, irq := cmd(c, p)
+			c.Put(name+".attempts", attempt)
+
+			if _, ok := irq.(*cookoo.RecoverableError); !ok || attempt > retries {
+				return out, irq
+			}
+			c.Logf("warn", "Attempt %d of %s failed: %s\n", attempt, name, irq)
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+}
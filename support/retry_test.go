@@ -0,0 +1,84 @@
+package support
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/cookoo"
+)
+
+func TestTimeout(t *testing.T) {
+	slow := func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		time.Sleep(time.Second)
+		return "slow", nil
+	}
+
+	_, irq := Timeout(slow, 10*time.Millisecond)(cookoo.NewContext(), cookoo.NewParamsWithValues(nil))
+	if _, ok := irq.(*cookoo.RecoverableError); !ok {
+		t.Errorf("Expected a recoverable error, got %v", irq)
+	}
+
+	out, irq := Timeout(ran, time.Second)(cookoo.NewContext(), cookoo.NewParamsWithValues(nil))
+	if out != "ran" || irq != nil {
+		t.Errorf("Expected the command to finish, got %v, %v", out, irq)
+	}
+
+	// A command that finishes in time changes the context. One that times
+	// out does not, even once it finishes.
+	put := func(d time.Duration) cookoo.Command {
+		return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+			time.Sleep(d)
+			c.Put("found", d)
+			return nil, nil
+		}
+	}
+	c := cookoo.NewContext()
+	Timeout(put(0), time.Second)(c, cookoo.NewParamsWithValues(nil))
+	if c.Get("found", nil) != time.Duration(0) {
+		t.Errorf("Expected the command's changes to the context, got %v", c.Get("found", nil))
+	}
+	c = cookoo.NewContext()
+	Timeout(put(20*time.Millisecond), time.Millisecond)(c, cookoo.NewParamsWithValues(nil))
+	time.Sleep(40 * time.Millisecond)
+	if v, ok := c.Has("found"); ok {
+		t.Errorf("Expected no changes from a command that timed out, got %v", v)
+	}
+}
+
+// flaky fails with err until it has been called n times.
+func flaky(n int, err cookoo.Interrupt) cookoo.Command {
+	calls := 0
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		calls++
+		if calls < n {
+			return nil, err
+		}
+		return calls, nil
+	}
+}
+
+func TestRetry(t *testing.T) {
+	c := &logContext{Context: cookoo.NewContext()}
+	p := cookoo.NewParamsWithValues(nil)
+	recoverable := &cookoo.RecoverableError{Message: "busy"}
+
+	out, irq := Retry(flaky(3, recoverable), "rows", 3, time.Millisecond)(c, p)
+	if out != 3 || irq != nil {
+		t.Errorf("Expected success on the third attempt, got %v, %v", out, irq)
+	}
+	if c.Get("rows.attempts", nil) != 3 || len(c.logs) != 2 {
+		t.Errorf("Expected 3 attempts and 2 warnings, got %v and %v", c.Get("rows.attempts", nil), c.logs)
+	}
+
+	_, irq = Retry(flaky(5, recoverable), "rows", 2, 0)(c, p)
+	if irq != recoverable || c.Get("rows.attempts", nil) != 3 {
+		t.Errorf("Expected to give up after 3 attempts, got %v after %v", irq, c.Get("rows.attempts", nil))
+	}
+
+	fatal := errors.New("fatal")
+	_, irq = Retry(flaky(5, fatal), "rows", 2, 0)(c, p)
+	if irq != fatal || c.Get("rows.attempts", nil) != 1 {
+		t.Errorf("Expected other errors not to be retried, got %v", irq)
+	}
+}
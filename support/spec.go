@@ -0,0 +1,66 @@
+package support
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+
+	"github.com/Masterminds/cookoo"
+)
+
+// Mock returns a command that does nothing but return value.
+//
+// Tests generated from CODL TEST specs run this in place of each command
+// that is named with MOCK.
+func Mock(value interface{}) cookoo.Command {
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		return value, nil
+	}
+}
+
+// CheckLen returns an error unless the context value under key is an array,
+// slice, map, string or channel of length n.
+//
+// CODL checks this for "EXPECT cxt:key LEN n".
+func CheckLen(c cookoo.Context, key string, n int) error {
+	v, ok := c.Has(key)
+	if !ok {
+		return fmt.Errorf("Expected %s to have length %d, but it is not in the context", key, n)
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.String, reflect.Chan:
+		if rv.Len() != n {
+			return fmt.Errorf("Expected %s to have length %d, got %d: %v", key, n, rv.Len(), v)
+		}
+		return nil
+	}
+	return fmt.Errorf("Expected %s to have length %d, but it is a %T", key, n, v)
+}
+
+// CheckValue returns an error unless the context value under key is deeply
+// equal to want.
+//
+// CODL checks this for "EXPECT cxt:key value".
+func CheckValue(c cookoo.Context, key string, want interface{}) error {
+	v, ok := c.Has(key)
+	if !ok {
+		return fmt.Errorf("Expected %s to be %#v, but it is not in the context", key, want)
+	}
+	if !reflect.DeepEqual(v, want) {
+		return fmt.Errorf("Expected %s to be %#v, got %#v", key, want, v)
+	}
+	return nil
+}
+
+// ResetFlags sets every flag in flags back to its default, so that a test
+// does not see the flags of the one before it.
+func ResetFlags(flags *flag.FlagSet) {
+	flags.VisitAll(func(f *flag.Flag) {
+		f.Value.Set(f.DefValue)
+	})
+}
+
+// FlagArg returns a command line argument that sets a flag: "-d=routes".
+func FlagArg(name string, value interface{}) string {
+	return fmt.Sprintf("-%s=%v", name, value)
+}
@@ -0,0 +1,65 @@
+package support
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/Masterminds/cookoo"
+)
+
+func TestMock(t *testing.T) {
+	v, irq := Mock([]string{"a"})(cookoo.NewContext(), cookoo.NewParamsWithValues(nil))
+	if irq != nil {
+		t.Errorf("Unexpected interrupt: %v", irq)
+	}
+	if list, ok := v.([]string); !ok || len(list) != 1 || list[0] != "a" {
+		t.Errorf("Expected the mocked value, got %v", v)
+	}
+}
+
+func TestCheckLen(t *testing.T) {
+	c := cookoo.NewContext()
+	c.Put("files", []string{"a", "b"})
+	c.Put("name", "abc")
+	c.Put("n", 2)
+
+	if err := CheckLen(c, "files", 2); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if err := CheckLen(c, "name", 3); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	for _, key := range []string{"files", "n", "missing"} {
+		if err := CheckLen(c, key, 1); err == nil {
+			t.Errorf("Expected an error for %s", key)
+		}
+	}
+}
+
+func TestCheckValue(t *testing.T) {
+	c := cookoo.NewContext()
+	c.Put("files", []string{"a"})
+
+	if err := CheckValue(c, "files", []string{"a"}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if err := CheckValue(c, "files", []string{"b"}); err == nil {
+		t.Errorf("Expected an error for a different value")
+	}
+	if err := CheckValue(c, "missing", nil); err == nil {
+		t.Errorf("Expected an error for a missing key")
+	}
+}
+
+func TestResetFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	d := flags.String("d", ".", "dir")
+	flags.Parse([]string{FlagArg("d", "routes")})
+	if *d != "routes" {
+		t.Fatalf("Expected -d=routes to be parsed, got %s", *d)
+	}
+	ResetFlags(flags)
+	if *d != "." {
+		t.Errorf("Expected the default, got %s", *d)
+	}
+}
@@ -0,0 +1,80 @@
+package support
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/cookoo"
+)
+
+// Lookup finds the value of a source such as "cxt:name", the way cookoo
+// resolves a From. "cxt" and "context" are the context. Any other prefix is
+// the name of a datasource, which must be a cookoo.KeyValueDatasource.
+//
+// It reports false if the source is malformed or has no value.
+func Lookup(c cookoo.Context, source string) (interface{}, bool) {
+	parts := strings.SplitN(source, ":", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	if parts[0] == "cxt" || parts[0] == "context" {
+		v, ok := c.Has(parts[1])
+		return v, ok && v != nil
+	}
+	ds, ok := c.Datasource(parts[0]).(cookoo.KeyValueDatasource)
+	if !ok {
+		return nil, false
+	}
+	v := ds.Value(parts[1])
+	return v, v != nil
+}
+
+// When returns a command that runs cmd only if source is true. A missing
+// value is false, as is false, zero, or a string that is empty or that
+// strconv.ParseBool reads as false. Anything else is true.
+//
+// CODL wraps a command in this for "DOES ... IF cxt:name".
+func When(cmd cookoo.Command, source string) cookoo.Command {
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		if !isTrue(c, source) {
+			return nil, nil
+		}
+		return cmd(c, p)
+	}
+}
+
+// Unless returns a command that runs cmd only if source is false, in the
+// same sense as When.
+//
+// CODL wraps a command in this for "DOES ... UNLESS cxt:name".
+func Unless(cmd cookoo.Command, source string) cookoo.Command {
+	return func(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+		if isTrue(c, source) {
+			return nil, nil
+		}
+		return cmd(c, p)
+	}
+}
+
+func isTrue(c cookoo.Context, source string) bool {
+	v, ok := Lookup(c, source)
+	if !ok {
+		return false
+	}
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		if b, err := strconv.ParseBool(t); err == nil {
+			return b
+		}
+		return t != ""
+	case int:
+		return t != 0
+	case int64:
+		return t != 0
+	case float64:
+		return t != 0
+	}
+	return true
+}
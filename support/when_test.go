@@ -0,0 +1,79 @@
+package support
+
+import (
+	"testing"
+
+	"github.com/Masterminds/cookoo"
+)
+
+// mapDatasource is a KeyValueDatasource backed by a map.
+type mapDatasource map[string]interface{}
+
+func (m mapDatasource) Value(key string) interface{} {
+	return m[key]
+}
+
+func ran(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	return "ran", nil
+}
+
+func TestLookup(t *testing.T) {
+	c := cookoo.NewContext()
+	c.Put("a", 1)
+	c.AddDatasource("query", mapDatasource{"q": "x"})
+
+	tests := []struct {
+		source string
+		value  interface{}
+		ok     bool
+	}{
+		{"cxt:a", 1, true},
+		{"context:a", 1, true},
+		{"cxt:missing", nil, false},
+		{"query:q", "x", true},
+		{"query:missing", nil, false},
+		{"nosuch:q", nil, false},
+		{"bad", nil, false},
+	}
+	for _, tt := range tests {
+		v, ok := Lookup(c, tt.source)
+		if v != tt.value || ok != tt.ok {
+			t.Errorf("Lookup(%s): expected %v, %t, got %v, %t", tt.source, tt.value, tt.ok, v, ok)
+		}
+	}
+}
+
+func TestWhenAndUnless(t *testing.T) {
+	c := cookoo.NewContext()
+	values := map[string]bool{
+		"yes":   true,
+		"no":    false,
+		"one":   true,
+		"zero":  false,
+		"word":  true,
+		"empty": false,
+		"falsy": false,
+	}
+	c.Put("yes", true)
+	c.Put("no", false)
+	c.Put("one", 1)
+	c.Put("zero", 0)
+	c.Put("word", "help")
+	c.Put("empty", "")
+	c.Put("falsy", "false")
+
+	for name, expect := range values {
+		out, _ := When(ran, "cxt:"+name)(c, cookoo.NewParamsWithValues(nil))
+		if (out == "ran") != expect {
+			t.Errorf("When(cxt:%s): expected run to be %t", name, expect)
+		}
+		out, _ = Unless(ran, "cxt:"+name)(c, cookoo.NewParamsWithValues(nil))
+		if (out == "ran") == expect {
+			t.Errorf("Unless(cxt:%s): expected run to be %t", name, !expect)
+		}
+	}
+
+	if out, _ := When(ran, "cxt:missing")(c, cookoo.NewParamsWithValues(nil)); out != nil {
+		t.Errorf("Expected a missing value to skip the command")
+	}
+}